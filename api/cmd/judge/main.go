@@ -0,0 +1,82 @@
+// judge runs a problem's tests locally, without the API server or database.
+//
+// usage:
+//
+//	go run ./cmd/judge [-skip-reference] <problem dir> <solution file>
+//
+// the reference solution of the problem is judged first, then the given solution file.
+// exit code is 1 if any test fails and 2 if the problem or solution can not be loaded.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"serious-fin/api/validator"
+)
+
+const (
+	exitTestsFailed = 1
+	exitSetupError  = 2
+)
+
+func main() {
+	skipReference := flag.Bool("skip-reference", false, "do not judge the reference solution of the problem")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: judge [-skip-reference] <problem dir> <solution file>\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(exitSetupError)
+	}
+
+	os.Exit(run(os.Stdout, flag.Arg(0), flag.Arg(1), *skipReference))
+}
+
+func run(w io.Writer, problemPath, solutionPath string, skipReference bool) int {
+	problem, err := loadProblemDir(problemPath)
+	if err != nil {
+		fmt.Fprintf(w, "could not load problem: %v\n", err)
+		return exitSetupError
+	}
+	solution, err := readTextFile(solutionPath, true)
+	if err != nil {
+		fmt.Fprintf(w, "could not load solution: %v\n", err)
+		return exitSetupError
+	}
+
+	fmt.Fprintf(w, "problem: %s (%s, difficulty %d, %d test cases)\n", problem.Title, problem.Kind, problem.Difficulty, len(problem.TestSet.TestCases))
+
+	allPassed := true
+	if !skipReference {
+		passed, err := judge(w, "reference solution", problem.TestSet, problem.ReferenceSolution)
+		if err != nil {
+			fmt.Fprintf(w, "could not judge reference solution: %v\n", err)
+			return exitSetupError
+		}
+		allPassed = allPassed && passed
+	}
+
+	passed, err := judge(w, solutionPath, problem.TestSet, solution)
+	if err != nil {
+		fmt.Fprintf(w, "could not judge solution: %v\n", err)
+		return exitSetupError
+	}
+	allPassed = allPassed && passed
+
+	if !allPassed {
+		return exitTestsFailed
+	}
+	return 0
+}
+
+func judge(w io.Writer, name string, testSet validator.TestSet, code string) (bool, error) {
+	response, err := validator.RunTestSet(testSet, code, nil)
+	if err != nil {
+		return false, err
+	}
+	return printReport(w, name, testSet.TestCases, response), nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"serious-fin/api/common"
+	"serious-fin/api/validator"
+)
+
+// layout of a problem directory, description.md and templates/main.txt are not read by the judge:
+//
+//	problem.json            title, difficulty and kind ("function" or "stdio")
+//	description.md          problem description shown to users
+//	tests.json              test cases, same format as the problems.testCases column
+//	subtasks.json           optional subtasks, same format as the problems.subtasks column
+//	templates/main.txt      starting code, same as the goTemplates.mainFunction column
+//	templates/test.tmpl     single test template, required for function problems
+//	templates/helpers.tmpl  optional test helpers
+//	solution.txt            reference solution which has to pass every test
+type problemDir struct {
+	Title             string            `json:"title"`
+	Difficulty        int               `json:"difficulty"`
+	Kind              string            `json:"kind"`
+	ReferenceSolution string            `json:"-"`
+	TestSet           validator.TestSet `json:"-"`
+}
+
+func loadProblemDir(dirPath string) (*problemDir, error) {
+	var problem problemDir
+	err := readJSONFile(filepath.Join(dirPath, "problem.json"), &problem)
+	if err != nil {
+		return nil, err
+	}
+	if problem.Kind == "" {
+		problem.Kind = common.ProblemKindFunction
+	}
+	if problem.Kind != common.ProblemKindFunction && problem.Kind != common.ProblemKindStdio {
+		return nil, fmt.Errorf("unknown problem kind \"%s\"", problem.Kind)
+	}
+
+	problem.ReferenceSolution, err = readTextFile(filepath.Join(dirPath, "solution.txt"), true)
+	if err != nil {
+		return nil, err
+	}
+
+	problem.TestSet.Kind = problem.Kind
+	problem.TestSet.TestTemplate, err = readTextFile(filepath.Join(dirPath, "templates", "test.tmpl"), problem.Kind == common.ProblemKindFunction)
+	if err != nil {
+		return nil, err
+	}
+	problem.TestSet.TestHelpers, err = readTextFile(filepath.Join(dirPath, "templates", "helpers.tmpl"), false)
+	if err != nil {
+		return nil, err
+	}
+
+	err = readJSONFile(filepath.Join(dirPath, "tests.json"), &problem.TestSet.TestCases)
+	if err != nil {
+		return nil, err
+	}
+	if len(problem.TestSet.TestCases) == 0 {
+		return nil, fmt.Errorf("problem has no test cases")
+	}
+
+	subtasksPath := filepath.Join(dirPath, "subtasks.json")
+	if _, err = os.Stat(subtasksPath); err == nil {
+		err = readJSONFile(subtasksPath, &problem.TestSet.Subtasks)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &problem, nil
+}
+
+func readTextFile(path string, required bool) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !required {
+			return "", nil
+		}
+		return "", fmt.Errorf("could not read file \"%s\": %w", path, err)
+	}
+	return string(content), nil
+}
+
+func readJSONFile(path string, target any) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read file \"%s\": %w", path, err)
+	}
+	err = json.Unmarshal(content, target)
+	if err != nil {
+		return fmt.Errorf("could not unmarshal file \"%s\": %w", path, err)
+	}
+	return nil
+}
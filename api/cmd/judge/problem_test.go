@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"serious-fin/api/common"
+	"testing"
+)
+
+func TestLoadProblemDir(t *testing.T) {
+	problem, err := loadProblemDir("testdata/double")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if problem.Title != "Double" || problem.Kind != common.ProblemKindFunction {
+		t.Errorf("unexpected problem metadata: %s (%s)", problem.Title, problem.Kind)
+	}
+	if len(problem.TestSet.TestCases) != 3 {
+		t.Errorf("got %d test cases, want 3", len(problem.TestSet.TestCases))
+	}
+	if len(problem.TestSet.Subtasks) != 2 {
+		t.Errorf("got %d subtasks, want 2", len(problem.TestSet.Subtasks))
+	}
+	if problem.TestSet.TestHelpers != "" {
+		t.Errorf("expected no test helpers but got %s", problem.TestSet.TestHelpers)
+	}
+}
+
+func TestLoadProblemDirMissingFiles(t *testing.T) {
+	if _, err := loadProblemDir(t.TempDir()); err == nil {
+		t.Error("expected error for empty problem directory")
+	}
+}
+
+func TestLoadProblemDirUnknownKind(t *testing.T) {
+	dirPath := t.TempDir()
+	err := os.WriteFile(filepath.Join(dirPath, "problem.json"), []byte(`{"title": "foo", "kind": "interactive"}`), 0644)
+	if err != nil {
+		t.Fatalf("could not write problem file: %v", err)
+	}
+
+	if _, err := loadProblemDir(dirPath); err == nil {
+		t.Error("expected error for unknown problem kind")
+	}
+}
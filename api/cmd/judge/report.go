@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"serious-fin/api/common"
+	"serious-fin/api/validator"
+	"slices"
+	"strings"
+)
+
+// prints result of every test case in the order they are defined in and returns whether all of them passed
+func printReport(w io.Writer, name string, testCases []common.TestCase, response *validator.Response) bool {
+	fmt.Fprintf(w, "%s:\n", name)
+	noResult := "no result, does the code compile?"
+	if response.BuildOutput != "" {
+		noResult = "no result, see build output"
+		fmt.Fprintf(w, "  build output:\n")
+		for _, line := range strings.Split(strings.TrimSpace(response.BuildOutput), "\n") {
+			fmt.Fprintf(w, "    %s\n", line)
+		}
+	}
+	for _, testCase := range testCases {
+		if slices.Contains(response.SucceededTests, testCase.Id) {
+			fmt.Fprintf(w, "  case %d: PASS\n", testCase.Id)
+			continue
+		}
+
+		failIndex := slices.IndexFunc(response.FailedTests, func(fail validator.FailInfo) bool {
+			return fail.Id == testCase.Id
+		})
+		if failIndex == -1 {
+			fmt.Fprintf(w, "  case %d: FAIL (%s)\n", testCase.Id, noResult)
+			continue
+		}
+		fail := response.FailedTests[failIndex]
+		fmt.Fprintf(w, "  case %d: FAIL (%s) got %s, want %s\n", testCase.Id, fail.Message, fail.Got, fail.Want)
+	}
+
+	for _, subtask := range response.Subtasks {
+		fmt.Fprintf(w, "  subtask %d %s: %d/%d\n", subtask.Id, subtask.Name, subtask.Score, subtask.Weight)
+	}
+	fmt.Fprintf(w, "  score: %d/%d\n", response.Score, response.MaxScore)
+
+	return len(response.SucceededTests) == len(testCases)
+}
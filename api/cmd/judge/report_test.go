@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"serious-fin/api/common"
+	"serious-fin/api/validator"
+	"strings"
+	"testing"
+)
+
+func TestPrintReport(t *testing.T) {
+	testCases := []common.TestCase{{Id: 0}, {Id: 1}, {Id: 2}}
+	response := &validator.Response{
+		SucceededTests: []int{0},
+		FailedTests: []validator.FailInfo{
+			{Id: 1, Got: "3", Want: "4", Message: validator.WRONG_OUTPUT},
+		},
+		Subtasks: []validator.SubtaskResult{
+			{Id: 0, Name: "all tests", Weight: 100, Score: 0},
+		},
+		MaxScore: 100,
+	}
+
+	var output bytes.Buffer
+	passed := printReport(&output, "solution.go", testCases, response)
+	if passed {
+		t.Error("expected report to not pass")
+	}
+
+	want := `solution.go:
+  case 0: PASS
+  case 1: FAIL (wrong output) got 3, want 4
+  case 2: FAIL (no result, does the code compile?)
+  subtask 0 all tests: 0/100
+  score: 0/100
+`
+	if output.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestPrintReportWithBuildOutput(t *testing.T) {
+	testCases := []common.TestCase{{Id: 0}}
+	response := &validator.Response{
+		BuildOutput: "./solution.go:2:9: undefined: y\n",
+	}
+
+	var output bytes.Buffer
+	printReport(&output, "solution.go", testCases, response)
+
+	want := `solution.go:
+  build output:
+    ./solution.go:2:9: undefined: y
+  case 0: FAIL (no result, see build output)
+  score: 0/0
+`
+	if output.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
+
+func TestRunPassingSolution(t *testing.T) {
+	var output bytes.Buffer
+	exitCode := run(&output, "testdata/double", "testdata/double/solution.txt", false)
+	if exitCode != 0 {
+		t.Errorf("got exit code %d, want 0. output:\n%s", exitCode, output.String())
+	}
+}
+
+func TestRunFailingSolution(t *testing.T) {
+	var output bytes.Buffer
+	exitCode := run(&output, "testdata/double", "testdata/small_only.txt", true)
+	if exitCode != exitTestsFailed {
+		t.Errorf("got exit code %d, want %d. output:\n%s", exitCode, exitTestsFailed, output.String())
+	}
+	if !strings.Contains(output.String(), "subtask 1 small numbers: 40/40") {
+		t.Errorf("expected partial score in output:\n%s", output.String())
+	}
+}
+
+func TestRunMissingProblem(t *testing.T) {
+	var output bytes.Buffer
+	exitCode := run(&output, "testdata/missing", "testdata/small_only.txt", false)
+	if exitCode != exitSetupError {
+		t.Errorf("got exit code %d, want %d", exitCode, exitSetupError)
+	}
+}
@@ -0,0 +1,21 @@
+package common
+
+import "regexp"
+
+// templates and answers usually leave the package clause out
+var packageClauseRegex = regexp.MustCompile(`^\s*(//.*\n\s*)*package\s`)
+
+// followed by the code on the same line, so line numbers reported for the code do not change
+const PackageClausePrefix = "package main; "
+
+func HasPackageClause(code string) bool {
+	return packageClauseRegex.MatchString(code)
+}
+
+// code is returned unchanged when it already has a package clause
+func WithPackageClause(code string) string {
+	if HasPackageClause(code) {
+		return code
+	}
+	return PackageClausePrefix + code
+}
@@ -6,10 +6,28 @@ type TestCase struct {
 	Id             int      `json:"id"`
 	Inputs         []string `json:"inputs"`
 	ExpectedOutput string   `json:"output"`
+	Subtask        int      `json:"subtask"`
+}
+
+const (
+	ProblemKindFunction = "function"
+	ProblemKindStdio    = "stdio"
+)
+
+type Subtask struct {
+	Id     int    `json:"id"`
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+}
+
+type SourceFile struct {
+	Name string `json:"name"`
+	Code string `json:"code"`
 }
 
 type DBInterface interface {
 	Query(query string, args ...any) (*sql.Rows, error)
 	QueryRow(query string, args ...any) *sql.Row
 	Exec(query string, args ...any) (sql.Result, error)
+	Begin() (*sql.Tx, error)
 }
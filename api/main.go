@@ -1,10 +1,9 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"crypto/subtle"
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -14,14 +13,15 @@ import (
 	"serious-fin/api/query"
 	"serious-fin/api/user"
 	"serious-fin/api/validator"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	_ "github.com/joho/godotenv/autoload"
 	_ "github.com/mattn/go-sqlite3"
-	openai "github.com/sashabaranov/go-openai"
-	gemini "google.golang.org/genai"
 )
 
 type APIError struct {
@@ -29,8 +29,14 @@ type APIError struct {
 	Details string `json:"details,omitempty"`
 }
 
+// login session is forwarded by the frontend server, the browser can not read it
+type ValidateRequest struct {
+	validator.Request
+	LoginSession string `form:"loginSession"`
+}
+
 func sendError(c *gin.Context, statusCode int, message string, err error) {
-	sendToDiscord(err.Error())
+	errorNotifier.Notify(err.Error())
 
 	apiError := APIError{Message: message}
 	if gin.IsDebugging() {
@@ -39,6 +45,21 @@ func sendError(c *gin.Context, statusCode int, message string, err error) {
 	c.IndentedJSON(statusCode, apiError)
 }
 
+// used once a streamed response has started and the status code can no longer be changed
+func sendStreamError(c *gin.Context, message string, err error) {
+	errorNotifier.Notify(err.Error())
+
+	apiError := APIError{Message: message}
+	if gin.IsDebugging() {
+		apiError.Details = err.Error()
+	}
+	c.SSEvent("error", apiError)
+	c.Writer.Flush()
+}
+
+// not a standard status, used by proxies for requests which the client abandoned before getting a response
+const statusClientClosedRequest = 499
+
 func ErrorHandlerMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Next()
@@ -46,6 +67,12 @@ func ErrorHandlerMiddleware() gin.HandlerFunc {
 		if len(c.Errors) > 0 {
 			err := c.Errors.Last().Err
 
+			// nobody is waiting for the response and nothing went wrong on our side
+			if errors.Is(err, context.Canceled) {
+				c.Status(statusClientClosedRequest)
+				return
+			}
+
 			statusCode := http.StatusInternalServerError
 			message := "An unexpected server error encountered"
 
@@ -53,32 +80,116 @@ func ErrorHandlerMiddleware() gin.HandlerFunc {
 				statusCode = http.StatusNotFound
 				message = "Resource not found"
 			}
+			if errors.Is(err, query.ErrUnknownAgent) {
+				statusCode = http.StatusBadRequest
+				message = "Requested agent does not exist"
+			}
+			if errors.Is(err, query.ErrInvalidPromptTemplate) {
+				statusCode = http.StatusBadRequest
+				message = "Prompt template is not valid"
+			}
+			if errors.Is(err, query.ErrInvalidExperiment) {
+				statusCode = http.StatusBadRequest
+				message = "Experiment is not valid"
+			}
+			if errors.Is(err, query.ErrInvalidComparison) {
+				statusCode = http.StatusBadRequest
+				message = "Comparison needs at least one agent"
+			}
+			if errors.Is(err, query.ErrInvalidCandidates) {
+				statusCode = http.StatusBadRequest
+				message = "Candidates can only be selected for a problem"
+			}
+			if errors.Is(err, query.ErrAgentUnavailable) {
+				statusCode = http.StatusServiceUnavailable
+				message = "Agent is temporarily unavailable, try again later"
+			}
+			if errors.Is(err, query.ErrNoCodeInResponse) {
+				statusCode = http.StatusBadGateway
+				message = "Agent response did not contain code"
+			}
+			if errors.Is(err, context.DeadlineExceeded) {
+				statusCode = http.StatusGatewayTimeout
+				message = "Agent did not answer in time"
+			}
+			if errors.Is(err, query.ErrAutoSolveNotAllowed) {
+				statusCode = http.StatusForbidden
+				message = "Automatic solving is not allowed for this problem"
+			}
 
 			sendError(c, statusCode, message, err)
 		}
 	}
 }
 
+// admin routes require "Authorization: Bearer <token>" header and are disabled when no token is configured
+func AdminAuthMiddleware(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, APIError{Message: "Admin endpoints are disabled"})
+			return
+		}
+
+		provided, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, APIError{Message: "Admin token is missing or invalid"})
+			return
+		}
+		c.Next()
+	}
+}
+
 var problemHandler *problem.ProblemDBHandler
 var queryHandler *query.QueryHandler
+var autoSolver *query.AutoSolver
+var agentComparer *query.AgentComparer
 var validatorHandler *validator.ValidatorHandler
 var userHandler *user.UserDBHandler
+var errorNotifier ErrorNotifier
+var promptStore *query.PromptStore
+var experimentStore *query.ExperimentStore
 
 func main() {
-	sessionContextSize := 5
+	// history sent to agents is trimmed to their token budget and trimmed turns are summarised,
+	// so this only bounds memory and storage per session when no summary agent is set
+	sessionContextSize := 100
 	cacheCleanupInterval := 20 * time.Second
 	sessionTimeoutInCache := 3 * time.Minute
-
-	checkEnvVariablesOrFail()
+	validationCacheSize := 500
+	validationCacheTTL := 10 * time.Minute
+	autoSolveMaxRounds := 3
+	maxCodeCorrections := 2
+	maxCandidates := 5
+	maxConcurrentValidations := 4
+	historyRetention := 7 * 24 * time.Hour
+	historyCleanupInterval := time.Hour
+
+	devMode := os.Getenv("DEV_MODE") == "true"
+	agentConfigs := loadAgentConfigsOrFail(os.Getenv("AGENTS_CONFIG"), devMode)
+	cassetteMode := os.Getenv("CASSETTE_MODE")
+	checkEnvVariablesOrFail(agentConfigs, devMode, cassetteMode != query.CassetteReplay)
+	errorNotifier = newErrorNotifier(devMode)
 	cache := initializeContextCacheOrFail(sessionContextSize, cacheCleanupInterval, sessionTimeoutInCache)
+	setSummariserOrFail(cache, agentConfigs, os.Getenv("SUMMARY_AGENT"))
+	resultCache := initializeResultCacheOrFail(validationCacheSize, validationCacheTTL)
 	database := connectToDatabaseOrFail("database.db")
 	defer database.Close()
-	aiHandlers := createAIAgentClientsOrFail(openai.GPT3Dot5Turbo, "gemini-2.5-flash", cache)
+	history := initializeHistoryStoreOrFail(database, cache, sessionContextSize, historyRetention, historyCleanupInterval)
+	history.StartCleanupRoutine()
+	defer history.StopCleanupRoutine()
+	agentRegistry := createAgentRegistryOrFail(agentConfigs, cassetteMode, os.Getenv("CASSETTE_DIR"), history)
 
 	problemHandler = problem.NewProblemHandler(database)
-	queryHandler = query.NewQueryHandler(*aiHandlers)
-	validatorHandler = validator.NewValidatorHandler(database)
+	promptStore = query.NewPromptStore(database)
+	experimentStore = query.NewExperimentStore(database)
+	queryHandler = query.NewQueryHandlerWithExperiments(agentRegistry, promptStore, experimentStore)
+	queryHandler.SetVerifier(query.NewCodeVerifier(problemHandler, maxCodeCorrections))
+	validatorHandler = validator.NewValidatorHandlerWithCache(database, resultCache)
+	validatorHandler.SetMaxConcurrentRuns(maxConcurrentValidations)
+	queryHandler.SetCandidateSelector(query.NewCandidateSelector(validatorHandler, maxCandidates))
 	userHandler = user.NewUserHandler(database)
+	autoSolver = query.NewAutoSolver(queryHandler, validatorHandler, problemHandler, autoSolveMaxRounds)
+	agentComparer = query.NewAgentComparer(queryHandler, validatorHandler)
 
 	router := gin.Default()
 	router.Use(cors.New(cors.Config{
@@ -91,13 +202,26 @@ func main() {
 	router.GET("/problems/:id", GetProblemById)
 	router.POST("/problems/:id", CompleteProblem)
 	router.GET("/problems/:id/go", GetProblemTemplateGo)
+	router.GET("/agents", GetAgents)
 	router.POST("/query/:sessionId", QueryAgent)
+	router.POST("/query/:sessionId/auto", AutoSolve)
+	router.POST("/query/:sessionId/stream", StreamQueryAgent)
+	router.POST("/query/:sessionId/compare", CompareAgents)
 	router.POST("/validate", ValidateCode)
+	router.GET("/validate/cache", GetValidationCacheStats)
 	router.GET("/user/:userId", GetUser)
 	router.POST("/user", CreateUser)
 	router.POST("/session", StartSession)
 	router.GET("/session/:sessionId", GetSession)
 
+	admin := router.Group("/admin", AdminAuthMiddleware(os.Getenv("ADMIN_TOKEN")))
+	admin.GET("/prompts", GetPromptVersions)
+	admin.POST("/prompts", CreatePromptVersion)
+	admin.POST("/prompts/:id/activate", ActivatePromptVersion)
+	admin.POST("/experiments", CreateExperiment)
+	admin.POST("/experiments/:id/stop", StopExperiment)
+	admin.GET("/experiments/:id/results", GetExperimentResults)
+
 	router.Run("0.0.0.0:8080")
 }
 
@@ -148,6 +272,10 @@ func GetProblemTemplateGo(c *gin.Context) {
 	c.IndentedJSON(http.StatusOK, template)
 }
 
+func GetAgents(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, queryHandler.Agents.List())
+}
+
 func QueryAgent(c *gin.Context) {
 	sessionId := c.Param("sessionId")
 	var body query.Request
@@ -156,31 +284,212 @@ func QueryAgent(c *gin.Context) {
 		return
 	}
 
-	agentResponse, err := queryHandler.QueryAgent(sessionId, body)
+	agentResponse, err := queryHandler.QueryAgent(c.Request.Context(), sessionId, body)
 	if err != nil {
 		c.Error(err)
 		return
 	}
-	c.IndentedJSON(http.StatusOK, query.Response{
-		Response: agentResponse,
+	c.IndentedJSON(http.StatusOK, agentResponse)
+}
+
+// responds with server-sent events: "delta" for every piece of the agent response,
+// "code" with post-processed code once the response is complete and "error" if it fails midway
+func StreamQueryAgent(c *gin.Context) {
+	sessionId := c.Param("sessionId")
+	var body query.Request
+	if err := c.ShouldBind(&body); err != nil {
+		c.Error(err)
+		return
+	}
+
+	// headers are set with the first event, so errors before it are answered like other requests
+	agentResponse, err := queryHandler.StreamAgent(c.Request.Context(), sessionId, body, func(delta string) {
+		startStream(c)
+		c.SSEvent("delta", query.StreamDelta{Text: delta})
+		c.Writer.Flush()
 	})
+	if err != nil {
+		if !c.Writer.Written() {
+			c.Error(err)
+			return
+		}
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		message := "An unexpected server error encountered"
+		if errors.Is(err, context.DeadlineExceeded) {
+			message = "Agent did not answer in time"
+		}
+		if errors.Is(err, query.ErrNoCodeInResponse) {
+			message = "Agent response did not contain code"
+		}
+		sendStreamError(c, message, err)
+		return
+	}
+
+	startStream(c)
+	c.SSEvent("code", agentResponse)
+	c.Writer.Flush()
+}
+
+func startStream(c *gin.Context) {
+	if c.Writer.Written() {
+		return
+	}
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+}
+
+func AutoSolve(c *gin.Context) {
+	sessionId := c.Param("sessionId")
+	var body query.AutoSolveRequest
+	if err := c.ShouldBind(&body); err != nil {
+		c.Error(err)
+		return
+	}
+
+	solveResponse, err := autoSolver.Solve(c.Request.Context(), sessionId, body)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, solveResponse)
+}
+
+// each agent answers in its own conversation, results are in the order of requested agents
+func CompareAgents(c *gin.Context) {
+	sessionId := c.Param("sessionId")
+	var body query.CompareRequest
+	if err := c.ShouldBind(&body); err != nil {
+		c.Error(err)
+		return
+	}
+
+	compareResponse, err := agentComparer.Compare(c.Request.Context(), sessionId, body)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, compareResponse)
 }
 
 func ValidateCode(c *gin.Context) {
-	var body validator.Request
+	var body ValidateRequest
 	if err := c.ShouldBind(&body); err != nil {
 		c.Error(err)
 		return
 	}
+	if body.LoginSession != "" {
+		foundUser, err := userHandler.GetUserFromSession(body.LoginSession)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		if foundUser != nil {
+			body.UserId = foundUser.Id
+		}
+	}
 
-	validatorResponse, err := validatorHandler.Validate(body)
+	validatorResponse, err := validatorHandler.Validate(c.Request.Context(), body.Request)
 	if err != nil {
 		c.Error(err)
 		return
 	}
+	if err := queryHandler.RecordOutcome(body.SessionId, body.ProblemId, validatorResponse); err != nil {
+		errorNotifier.Notify(err.Error())
+	}
 	c.IndentedJSON(http.StatusOK, validatorResponse)
 }
 
+func GetValidationCacheStats(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, validatorHandler.Cache.Stats())
+}
+
+func GetPromptVersions(c *gin.Context) {
+	prompts, err := promptStore.ListPromptVersions()
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, prompts)
+}
+
+func CreatePromptVersion(c *gin.Context) {
+	var body query.CreatePromptRequest
+	if err := c.ShouldBind(&body); err != nil {
+		c.Error(err)
+		return
+	}
+
+	prompt, err := promptStore.CreatePromptVersion(body)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, prompt)
+}
+
+func ActivatePromptVersion(c *gin.Context) {
+	promptId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(fmt.Errorf("could not parse prompt id: %w", err))
+		return
+	}
+
+	prompt, err := promptStore.ActivatePromptVersion(promptId)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, prompt)
+}
+
+func CreateExperiment(c *gin.Context) {
+	var body query.CreateExperimentRequest
+	if err := c.ShouldBind(&body); err != nil {
+		c.Error(err)
+		return
+	}
+
+	experiment, err := experimentStore.CreateExperiment(body)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.IndentedJSON(http.StatusCreated, experiment)
+}
+
+func StopExperiment(c *gin.Context) {
+	experimentId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(fmt.Errorf("could not parse experiment id: %w", err))
+		return
+	}
+
+	err = experimentStore.StopExperiment(experimentId)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, nil)
+}
+
+func GetExperimentResults(c *gin.Context) {
+	experimentId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(fmt.Errorf("could not parse experiment id: %w", err))
+		return
+	}
+
+	results, err := experimentStore.GetExperimentResults(experimentId)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.IndentedJSON(http.StatusOK, results)
+}
+
 func GetSession(c *gin.Context) {
 	sessionId := c.Param("sessionId")
 	foundUser, err := userHandler.GetUserFromSession(sessionId)
@@ -275,51 +584,47 @@ func StartSession(c *gin.Context) {
 	})
 }
 
-func sendToDiscord(message string) {
-	runes := []rune(message)
-	sentChars := 0
-	for sentChars < len(runes) {
-		end := min(sentChars+2000, len(runes))
-		sendDiscordMessage(string(runes[sentChars:end]))
-		sentChars = end
+func initializeContextCacheOrFail(maxSize int, cleanupInterval time.Duration, sessionTimeout time.Duration) *query.ContextCache {
+	contextCache, err := query.NewContextCache(maxSize, cleanupInterval, sessionTimeout)
+	if err != nil {
+		log.Fatalf("Error creating context cache: %v", err)
 	}
+	return contextCache
 }
 
-func sendDiscordMessage(message string) {
-	discordToken := os.Getenv("DISCORD_TOKEN")
-	channelId := os.Getenv("DISCORD_CHANNEL_ID")
-	discordApiUrl := fmt.Sprintf("https://discord.com/api/channels/%s/messages", channelId)
-	body := map[string]string{"content": fmt.Sprintf("backend-msg: %s", message)}
-	bodyAsBytes, err := json.Marshal(body)
-	if err != nil {
-		// printing this to standard output because this function is supposed to send errors to discord normally
-		fmt.Printf("error marshaling discord message body: %v", err)
-	}
-	req, err := http.NewRequest("POST", discordApiUrl, bytes.NewBuffer(bodyAsBytes))
-	if err != nil {
-		fmt.Printf("could not create new discord request: %v", err)
+// summaryAgent is the name of a configured agent used to summarise old turns, summarising is disabled when it is empty
+func setSummariserOrFail(cache *query.ContextCache, agentConfigs []query.AgentConfig, summaryAgent string) {
+	if summaryAgent == "" {
+		return
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", discordToken)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	index := slices.IndexFunc(agentConfigs, func(config query.AgentConfig) bool {
+		return config.Name == summaryAgent
+	})
+	if index == -1 {
+		log.Fatalf("Summary agent %s is not configured", summaryAgent)
+	}
+	summariser, err := query.NewAgentSummariserFromConfig(agentConfigs[index], context.Background())
 	if err != nil {
-		fmt.Printf("error while sending request to discord: %v", err)
+		log.Fatalf("Error creating summariser: %v", err)
 	}
-	defer resp.Body.Close()
+	cache.SetSummariser(summariser)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("got response status code %d, when expected %d", resp.StatusCode, http.StatusOK)
+func initializeHistoryStoreOrFail(database *sql.DB, front *query.ContextCache, maxSize int, retention, cleanupInterval time.Duration) *query.HistoryStore {
+	history, err := query.NewHistoryStore(database, front, maxSize, retention, cleanupInterval)
+	if err != nil {
+		log.Fatalf("Error creating conversation history: %v", err)
 	}
+	return history
 }
 
-func initializeContextCacheOrFail(maxSize int, cleanupInterval time.Duration, sessionTimeout time.Duration) *query.ContextCache {
-	contextCache, err := query.NewContextCache(maxSize, cleanupInterval, sessionTimeout)
+func initializeResultCacheOrFail(maxSize int, ttl time.Duration) *validator.ResultCache {
+	resultCache, err := validator.NewResultCache(maxSize, ttl)
 	if err != nil {
-		log.Fatalf("Error creating context cache: %v", err)
+		log.Fatalf("Error creating validation result cache: %v", err)
 	}
-	return contextCache
+	return resultCache
 }
 
 func connectToDatabaseOrFail(dbFilePath string) *sql.DB {
@@ -334,12 +639,16 @@ func connectToDatabaseOrFail(dbFilePath string) *sql.DB {
 	return db
 }
 
-func checkEnvVariablesOrFail() {
-	if envVar := os.Getenv("CHATGPT_KEY"); envVar == "" {
-		log.Fatal("CHATGPT_KEY environment variable is not set")
+// in development mode discord is not used, so only credentials of the configured agents are required.
+// agent credentials are not needed at all when interactions are replayed from cassettes
+func checkEnvVariablesOrFail(agentConfigs []query.AgentConfig, devMode, requireAgentKeys bool) {
+	if requireAgentKeys {
+		if err := query.CheckAgentCredentials(agentConfigs); err != nil {
+			log.Fatal(err)
+		}
 	}
-	if envVar := os.Getenv("GEMINI_KEY"); envVar == "" {
-		log.Fatal("GEMINI_KEY environment variable is not set")
+	if devMode {
+		return
 	}
 	if envVar := os.Getenv("DISCORD_TOKEN"); envVar == "" {
 		log.Fatal("DISCORD_TOKEN environment variable is not set")
@@ -349,21 +658,39 @@ func checkEnvVariablesOrFail() {
 	}
 }
 
-func createAIAgentClientsOrFail(chatgptModel string, geminiModel string, cache *query.ContextCache) *query.AIAgents {
-	ctx := context.Background()
-	chatGPTClient := openai.NewClient(os.Getenv("CHATGPT_KEY"))
-	chatgptAgent := query.NewChatgptClientWrapper(chatGPTClient, chatgptModel, cache, ctx)
+// agents are read from the file at configPath. when it is empty the default chatgpt and gemini agents are used,
+// or their fake replacements in development mode
+func loadAgentConfigsOrFail(configPath string, devMode bool) []query.AgentConfig {
+	if configPath == "" {
+		if devMode {
+			return query.DevAgentConfigs
+		}
+		return query.DefaultAgentConfigs
+	}
 
-	geminiClient, err := gemini.NewClient(ctx, &gemini.ClientConfig{
-		APIKey:  os.Getenv("GEMINI_KEY"),
-		Backend: gemini.BackendGeminiAPI,
-	})
+	configs, err := query.LoadAgentConfigs(configPath)
 	if err != nil {
-		log.Fatalf("Error creating gemini client: %v", err)
+		log.Fatalf("Error loading agent configuration: %v", err)
 	}
-	geminiAgent := query.NewGeminiAgentWrapper(geminiClient, geminiModel, cache, ctx)
-	return &query.AIAgents{
-		Chatgpt: chatgptAgent,
-		Gemini:  geminiAgent,
+	return configs
+}
+
+// agents are wrapped with cassettes when cassetteMode is set, recordings are kept in cassetteDir ("cassettes" by default)
+func createAgentRegistryOrFail(configs []query.AgentConfig, cassetteMode, cassetteDir string, cache query.CacheInterface) *query.AgentRegistry {
+	if cassetteMode != "" {
+		if cassetteDir == "" {
+			cassetteDir = "cassettes"
+		}
+		registry, err := query.NewCassetteRegistryFromConfig(configs, cassetteMode, cassetteDir, cache, context.Background())
+		if err != nil {
+			log.Fatalf("Error creating agents: %v", err)
+		}
+		return registry
+	}
+
+	registry, err := query.NewAgentRegistryFromConfig(configs, cache, context.Background())
+	if err != nil {
+		log.Fatalf("Error creating agents: %v", err)
 	}
+	return registry
 }
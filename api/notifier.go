@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+type ErrorNotifier interface {
+	Notify(message string)
+}
+
+// sends messages to a discord channel, split into chunks fitting discord message length limit
+type DiscordNotifier struct {
+	Token     string
+	ChannelId string
+	Client    *http.Client
+}
+
+// only writes messages to the log, used in development mode
+type LogNotifier struct{}
+
+const discordMessageLimit = 2000
+
+func newErrorNotifier(devMode bool) ErrorNotifier {
+	if devMode {
+		return LogNotifier{}
+	}
+	return &DiscordNotifier{
+		Token:     os.Getenv("DISCORD_TOKEN"),
+		ChannelId: os.Getenv("DISCORD_CHANNEL_ID"),
+		Client:    &http.Client{},
+	}
+}
+
+func (LogNotifier) Notify(message string) {
+	log.Printf("error: %s", message)
+}
+
+func (notifier *DiscordNotifier) Notify(message string) {
+	runes := []rune(message)
+	sentChars := 0
+	for sentChars < len(runes) {
+		end := min(sentChars+discordMessageLimit, len(runes))
+		notifier.sendMessage(string(runes[sentChars:end]))
+		sentChars = end
+	}
+}
+
+func (notifier *DiscordNotifier) sendMessage(message string) {
+	discordApiUrl := fmt.Sprintf("https://discord.com/api/channels/%s/messages", notifier.ChannelId)
+	body := map[string]string{"content": fmt.Sprintf("backend-msg: %s", message)}
+	bodyAsBytes, err := json.Marshal(body)
+	if err != nil {
+		// printing this to standard output because this function is supposed to send errors to discord normally
+		fmt.Printf("error marshaling discord message body: %v", err)
+		return
+	}
+	req, err := http.NewRequest("POST", discordApiUrl, bytes.NewBuffer(bodyAsBytes))
+	if err != nil {
+		fmt.Printf("could not create new discord request: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", notifier.Token)
+
+	resp, err := notifier.Client.Do(req)
+	if err != nil {
+		fmt.Printf("error while sending request to discord: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf("got response status code %d, when expected %d", resp.StatusCode, http.StatusOK)
+	}
+}
@@ -10,7 +10,9 @@ type Problem struct {
 	Id            int               `json:"id"`
 	Title         string            `json:"title"`
 	Difficulty    int               `json:"difficulty"`
+	Kind          string            `json:"kind,omitempty"`
 	IsCompleted   bool              `json:"isCompleted"`
+	BestScore     int               `json:"bestScore"`
 	Description   string            `json:"description,omitempty"`
 	TestCases     []common.TestCase `json:"testCases,omitempty"`
 	GoPlaceholder string            `json:"goPlaceholder,omitempty"`
@@ -37,7 +39,8 @@ func (handler *ProblemDBHandler) GetProblems(userId string) ([]Problem, error) {
 		CASE WHEN ucp.problemId IS NULL 
 			THEN false 
 			ELSE true 
-		END AS isCompleted 
+		END AS isCompleted,
+		COALESCE(ups.bestScore, 0) AS bestScore
 	FROM problems 
 	LEFT JOIN (
 		SELECT 
@@ -45,9 +48,17 @@ func (handler *ProblemDBHandler) GetProblems(userId string) ([]Problem, error) {
 		FROM userCompletedProblems 
 		WHERE userId = ?
 	) AS ucp 
-	ON problems.id = ucp.problemId`
+	ON problems.id = ucp.problemId
+	LEFT JOIN (
+		SELECT
+			problemId,
+			bestScore
+		FROM userProblemScores
+		WHERE userId = ?
+	) AS ups
+	ON problems.id = ups.problemId`
 
-	rows, err := handler.DB.Query(query, userId)
+	rows, err := handler.DB.Query(query, userId, userId)
 	if err != nil {
 		return nil, fmt.Errorf("could not query problems data from db: %w", err)
 	}
@@ -56,7 +67,7 @@ func (handler *ProblemDBHandler) GetProblems(userId string) ([]Problem, error) {
 	problems := make([]Problem, 0)
 	for rows.Next() {
 		var problem Problem
-		err = rows.Scan(&problem.Id, &problem.Title, &problem.Difficulty, &problem.IsCompleted)
+		err = rows.Scan(&problem.Id, &problem.Title, &problem.Difficulty, &problem.IsCompleted, &problem.BestScore)
 		if err != nil {
 			return nil, fmt.Errorf("could not scan problems db output: %w", err)
 		}
@@ -77,6 +88,7 @@ func (handler *ProblemDBHandler) GetProblemById(userId, problemId string) (*Prob
 		difficulty, 
 		description, 
 		testCases,
+		COALESCE(kind, 'function') AS kind,
 		CASE WHEN ucp.problemId IS NULL 
 			THEN false 
 			ELSE true 
@@ -95,7 +107,7 @@ func (handler *ProblemDBHandler) GetProblemById(userId, problemId string) (*Prob
 	row := handler.DB.QueryRow(query, userId, problemId, problemId)
 	var problem Problem
 	var testCaseString string
-	err := row.Scan(&problem.Id, &problem.Title, &problem.Difficulty, &problem.Description, &testCaseString, &problem.IsCompleted)
+	err := row.Scan(&problem.Id, &problem.Title, &problem.Difficulty, &problem.Description, &testCaseString, &problem.Kind, &problem.IsCompleted)
 	if err != nil {
 		return nil, fmt.Errorf("could not scan single problem db output (problem id %s): %w", problemId, err)
 	}
@@ -126,3 +138,16 @@ func (handler *ProblemDBHandler) CompleteProblem(problemId, userId string) error
 	}
 	return nil
 }
+
+// problems allow automatic solving unless disabled. maxRounds of 0 means the server default is used
+func (handler *ProblemDBHandler) GetAutoSolveSettings(problemId int) (bool, int, error) {
+	row := handler.DB.QueryRow("SELECT COALESCE(autoSolveAllowed, true), COALESCE(autoSolveMaxRounds, 0) FROM problems WHERE id = ?", problemId)
+
+	var allowed bool
+	var maxRounds int
+	err := row.Scan(&allowed, &maxRounds)
+	if err != nil {
+		return false, 0, fmt.Errorf("could not scan auto solve settings (problem id %d): %w", problemId, err)
+	}
+	return allowed, maxRounds, nil
+}
@@ -21,7 +21,7 @@ func TestGetProblemsQueryThrowsError(t *testing.T) {
 
 	var mockDb = NewProblemHandler(db)
 
-	mock.ExpectQuery(`SELECT\s+id,\s+title,\s+difficulty,`).WithArgs(userId).WillReturnError(errors.New("error querying data"))
+	mock.ExpectQuery(`SELECT\s+id,\s+title,\s+difficulty,`).WithArgs(userId, userId).WillReturnError(errors.New("error querying data"))
 
 	if _, err = mockDb.GetProblems(userId); err == nil {
 		t.Error("expected error when query fails")
@@ -47,26 +47,28 @@ func TestGetProblems(t *testing.T) {
 			Title:       "one",
 			Difficulty:  1,
 			IsCompleted: true,
+			BestScore:   100,
 		},
 		{
 			Id:          2,
 			Title:       "two",
 			Difficulty:  2,
 			IsCompleted: false,
+			BestScore:   30,
 		},
 	}
 
 	values := [][]driver.Value{
 		{
-			want[0].Id, want[0].Title, want[0].Difficulty, want[0].IsCompleted,
+			want[0].Id, want[0].Title, want[0].Difficulty, want[0].IsCompleted, want[0].BestScore,
 		},
 		{
-			want[1].Id, want[1].Title, want[1].Difficulty, want[1].IsCompleted,
+			want[1].Id, want[1].Title, want[1].Difficulty, want[1].IsCompleted, want[1].BestScore,
 		},
 	}
 
-	mock.ExpectQuery(`SELECT\s+id,\s+title,\s+difficulty,`).WithArgs(userId).WillReturnRows(sqlmock.NewRows([]string{
-		"id", "title", "difficulty", "isCompleted",
+	mock.ExpectQuery(`SELECT\s+id,\s+title,\s+difficulty,`).WithArgs(userId, userId).WillReturnRows(sqlmock.NewRows([]string{
+		"id", "title", "difficulty", "isCompleted", "bestScore",
 	}).AddRows(values...))
 
 	got, err := mockDb.GetProblems(userId)
@@ -98,6 +100,7 @@ func TestGetProblemById(t *testing.T) {
 		Title:       "foo",
 		Description: "bar",
 		Difficulty:  3,
+		Kind:        "stdio",
 		IsCompleted: true,
 		TestCases: []common.TestCase{
 			{
@@ -112,12 +115,12 @@ func TestGetProblemById(t *testing.T) {
 
 	values := [][]driver.Value{
 		{
-			want.Id, want.Title, want.Difficulty, want.Description, `[{"id": 0,"inputs":  ["[]int{2, 7, 11, 15}","9"],"output": "[]int{0, 1}"}]`, want.IsCompleted,
+			want.Id, want.Title, want.Difficulty, want.Description, `[{"id": 0,"inputs":  ["[]int{2, 7, 11, 15}","9"],"output": "[]int{0, 1}"}]`, want.Kind, want.IsCompleted,
 		},
 	}
 
 	mock.ExpectQuery(`SELECT\s+id,\s+title,\s+difficulty,\s+description,\s+testCases,`).WithArgs(userId, problemId, problemId).WillReturnRows(sqlmock.NewRows([]string{
-		"id", "title", "difficulty", "description", "testCases", "isCompleted",
+		"id", "title", "difficulty", "description", "testCases", "kind", "isCompleted",
 	}).AddRows(values...))
 
 	got, err := mockDb.GetProblemById(userId, fmt.Sprint(want.Id))
@@ -164,3 +167,52 @@ func TestGetMainFuncGo(t *testing.T) {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 }
+
+func TestGetAutoSolveSettings(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	var mockDb = NewProblemHandler(db)
+	problemId := 1
+
+	mock.ExpectQuery(`SELECT COALESCE\(autoSolveAllowed, true\), COALESCE\(autoSolveMaxRounds, 0\) FROM problems WHERE id = \?`).WithArgs(problemId).WillReturnRows(sqlmock.NewRows([]string{
+		"autoSolveAllowed", "autoSolveMaxRounds",
+	}).AddRows([]driver.Value{false, 3}))
+
+	allowed, maxRounds, err := mockDb.GetAutoSolveSettings(problemId)
+	if err != nil {
+		t.Errorf("unexpected error when returned rows are in a correct format: %v", err)
+	}
+
+	if allowed || maxRounds != 3 {
+		t.Errorf("want: (false, 3), got: (%v, %d)", allowed, maxRounds)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetAutoSolveSettingsThrowsError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	var mockDb = NewProblemHandler(db)
+	problemId := 1
+
+	mock.ExpectQuery(`SELECT COALESCE\(autoSolveAllowed, true\)`).WithArgs(problemId).WillReturnError(errors.New("error querying data"))
+
+	if _, _, err = mockDb.GetAutoSolveSettings(problemId); err == nil {
+		t.Error("expected error when query fails")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,246 @@
+package query
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type AnthropicAgentWrapper struct {
+	Agent AnthropicInterface
+	Cache CacheInterface
+}
+
+type AnthropicInterface interface {
+	Query(ctx context.Context, request AnthropicRequest) (string, error)
+	Stream(ctx context.Context, request AnthropicRequest, onDelta func(string)) (string, error)
+}
+
+type Anthropic struct {
+	Client  *http.Client
+	BaseURL string
+	APIKey  string
+	Model   string
+}
+
+type AnthropicRequest struct {
+	Model     string             `json:"model"`
+	MaxTokens int                `json:"max_tokens"`
+	System    string             `json:"system,omitempty"`
+	Messages  []AnthropicMessage `json:"messages"`
+	Stream    bool               `json:"stream,omitempty"`
+}
+
+type AnthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type anthropicResponse struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+}
+
+type anthropicStreamEvent struct {
+	Type  string `json:"type"`
+	Delta struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"delta"`
+	Error *anthropicError `json:"error,omitempty"`
+}
+
+type anthropicErrorResponse struct {
+	Error anthropicError `json:"error"`
+}
+
+type anthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// status code is 0 for errors received in the middle of a stream
+type AnthropicAPIError struct {
+	StatusCode int
+	Type       string
+	Message    string
+}
+
+func (err *AnthropicAPIError) Error() string {
+	if err.StatusCode == 0 {
+		return fmt.Sprintf("anthropic API returned %s: %s", err.Type, err.Message)
+	}
+	return fmt.Sprintf("anthropic API returned status %d: %s", err.StatusCode, err.Message)
+}
+
+const (
+	AnthropicDefaultBaseURL = "https://api.anthropic.com"
+	anthropicVersion        = "2023-06-01"
+	anthropicMaxTokens      = 4096
+)
+
+func NewAnthropicAgentWrapper(client *http.Client, baseURL, apiKey, model string, cache CacheInterface) *AnthropicAgentWrapper {
+	return &AnthropicAgentWrapper{
+		Agent: &Anthropic{
+			Client:  client,
+			BaseURL: baseURL,
+			APIKey:  apiKey,
+			Model:   model,
+		},
+		Cache: cache,
+	}
+}
+
+func (wrapper *AnthropicAgentWrapper) QueryWithContext(ctx context.Context, sessionId, userQuery, systemPrompt string) (string, error) {
+	output, err := wrapper.Agent.Query(ctx, wrapper.buildRequest(sessionId, userQuery, systemPrompt))
+	if err != nil {
+		return "", fmt.Errorf("could not query anthropic agent: %w", err)
+	}
+
+	wrapper.Cache.Add(sessionId, userQuery, output)
+	return output, nil
+}
+
+// onDelta is called with every received piece of the response, full response is cached once the stream ends
+func (wrapper *AnthropicAgentWrapper) StreamWithContext(ctx context.Context, sessionId, userQuery, systemPrompt string, onDelta func(string)) (string, error) {
+	output, err := wrapper.Agent.Stream(ctx, wrapper.buildRequest(sessionId, userQuery, systemPrompt), onDelta)
+	if err != nil {
+		return "", fmt.Errorf("could not stream anthropic agent: %w", err)
+	}
+
+	wrapper.Cache.Add(sessionId, userQuery, output)
+	return output, nil
+}
+
+// system prompt is a separate field in the messages API instead of a message with system role
+func (wrapper *AnthropicAgentWrapper) buildRequest(sessionId, userQuery, systemPrompt string) AnthropicRequest {
+	previousContext := previousContextWithinBudget(wrapper.Cache, sessionId, userQuery, systemPrompt)
+	messages := make([]AnthropicMessage, 0, len(previousContext)+1)
+	for _, context := range previousContext {
+		messages = append(messages, AnthropicMessage{
+			Role:    context.Role,
+			Content: context.Content,
+		})
+	}
+	messages = append(messages, AnthropicMessage{
+		Role:    RoleUser,
+		Content: userQuery,
+	})
+
+	return AnthropicRequest{
+		MaxTokens: anthropicMaxTokens,
+		System:    systemPrompt,
+		Messages:  messages,
+	}
+}
+
+func (agent *Anthropic) Query(ctx context.Context, request AnthropicRequest) (string, error) {
+	request.Model = agent.Model
+	request.Stream = false
+	resp, err := agent.send(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var response anthropicResponse
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return "", fmt.Errorf("could not decode anthropic response: %w", err)
+	}
+
+	var output strings.Builder
+	for _, content := range response.Content {
+		if content.Type == "text" {
+			output.WriteString(content.Text)
+		}
+	}
+	if output.Len() == 0 {
+		return "", fmt.Errorf("no text response was received from anthropic query")
+	}
+	return output.String(), nil
+}
+
+func (agent *Anthropic) Stream(ctx context.Context, request AnthropicRequest, onDelta func(string)) (string, error) {
+	request.Model = agent.Model
+	request.Stream = true
+	resp, err := agent.send(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var output strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, found := strings.CutPrefix(scanner.Text(), "data:")
+		if !found {
+			continue
+		}
+
+		var event anthropicStreamEvent
+		err = json.Unmarshal([]byte(strings.TrimSpace(data)), &event)
+		if err != nil {
+			return "", fmt.Errorf("could not decode anthropic stream event: %w", err)
+		}
+
+		switch event.Type {
+		case "content_block_delta":
+			if event.Delta.Type != "text_delta" || event.Delta.Text == "" {
+				continue
+			}
+			output.WriteString(event.Delta.Text)
+			onDelta(event.Delta.Text)
+		case "error":
+			if event.Error == nil {
+				return "", fmt.Errorf("anthropic stream returned error without details")
+			}
+			return "", fmt.Errorf("anthropic stream returned error: %w", &AnthropicAPIError{Type: event.Error.Type, Message: event.Error.Message})
+		case "message_stop":
+			return output.String(), nil
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return "", fmt.Errorf("could not read anthropic stream: %w", err)
+	}
+	// connection closed before the whole answer was sent
+	return "", fmt.Errorf("anthropic stream ended before message_stop: %w", io.ErrUnexpectedEOF)
+}
+
+// returns response with successful status code, caller has to close its body
+func (agent *Anthropic) send(ctx context.Context, request AnthropicRequest) (*http.Response, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal anthropic request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, agent.BaseURL+"/v1/messages", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("could not create anthropic request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", agent.APIKey)
+	req.Header.Set("anthropic-version", anthropicVersion)
+
+	resp, err := agent.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not make API call to anthropic: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var errorResponse anthropicErrorResponse
+		respBody, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(respBody, &errorResponse) == nil && errorResponse.Error.Message != "" {
+			return nil, &AnthropicAPIError{StatusCode: resp.StatusCode, Type: errorResponse.Error.Type, Message: errorResponse.Error.Message}
+		}
+		return nil, &AnthropicAPIError{StatusCode: resp.StatusCode, Message: string(respBody)}
+	}
+	return resp, nil
+}
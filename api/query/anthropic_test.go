@@ -0,0 +1,214 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type mockAnthropic struct {
+	QueryFunc  func(request AnthropicRequest) (string, error)
+	StreamFunc func(request AnthropicRequest, onDelta func(string)) (string, error)
+}
+
+func (mockAnthropic *mockAnthropic) Query(ctx context.Context, request AnthropicRequest) (string, error) {
+	if mockAnthropic.QueryFunc != nil {
+		return mockAnthropic.QueryFunc(request)
+	}
+	return "", nil
+}
+
+func (mockAnthropic *mockAnthropic) Stream(ctx context.Context, request AnthropicRequest, onDelta func(string)) (string, error) {
+	if mockAnthropic.StreamFunc != nil {
+		return mockAnthropic.StreamFunc(request, onDelta)
+	}
+	return "", nil
+}
+
+func TestAnthropicShouldAddSystemPromptToQuery(t *testing.T) {
+	anthropicAgentWrapper := &AnthropicAgentWrapper{
+		Agent: &mockAnthropic{
+			QueryFunc: func(request AnthropicRequest) (string, error) {
+				return request.System, nil
+			},
+		},
+		Cache: &mockCache{},
+	}
+
+	got, err := anthropicAgentWrapper.QueryWithContext(context.Background(), "1", "input", systemPrompt)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got != systemPrompt {
+		t.Errorf("got %s, want %s", got, systemPrompt)
+	}
+}
+
+func TestAnthropicShouldAddHistory(t *testing.T) {
+	history := []Context{
+		{
+			Role:    RoleUser,
+			Content: "Hey",
+		},
+		{
+			Role:    RoleAssistant,
+			Content: "Hello, how can I help you?",
+		},
+	}
+
+	anthropicAgentWrapper := &AnthropicAgentWrapper{
+		Agent: &mockAnthropic{
+			QueryFunc: func(request AnthropicRequest) (string, error) {
+				context := []Context{}
+				for _, message := range request.Messages {
+					context = append(context, Context{
+						Content: message.Content,
+						Role:    message.Role,
+					})
+				}
+				return contextToString(context), nil
+			},
+		},
+		Cache: &mockCache{
+			GetFunc: func(sessionId string) []Context {
+				return history
+			},
+		},
+	}
+
+	got, err := anthropicAgentWrapper.QueryWithContext(context.Background(), "1", "what's 2+2?", systemPrompt)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	want := append(history, Context{
+		Content: "what's 2+2?",
+		Role:    RoleUser,
+	})
+	if got != contextToString(want) {
+		t.Errorf("got %s, want %s", got, contextToString(want))
+	}
+}
+
+func TestAnthropicHistoryShouldSaveConversation(t *testing.T) {
+	cache, _ := NewContextCache(5, time.Minute, 2*time.Minute)
+	anthropicAgentWrapper := &AnthropicAgentWrapper{
+		Agent: &mockAnthropic{
+			QueryFunc: func(request AnthropicRequest) (string, error) {
+				return "agent response", nil
+			},
+		},
+		Cache: cache,
+	}
+
+	_, err := anthropicAgentWrapper.QueryWithContext(context.Background(), "1", "input", systemPrompt)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	want := contextToString([]Context{
+		{
+			Content: "input",
+			Role:    RoleUser,
+		},
+		{
+			Content: "agent response",
+			Role:    RoleAssistant,
+		},
+	})
+	if got := contextToString(cache.Get("1")); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestAnthropicQueryOverHTTP(t *testing.T) {
+	var gotRequest AnthropicRequest
+	var gotHeaders http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			t.Errorf("got request path %s, want /v1/messages", r.URL.Path)
+		}
+		gotHeaders = r.Header
+		json.NewDecoder(r.Body).Decode(&gotRequest)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id": "msg_1", "type": "message", "role": "assistant", "content": [{"type": "text", "text": "claude answer"}]}`)
+	}))
+	defer server.Close()
+
+	agent := NewAnthropicAgentWrapper(server.Client(), server.URL, "secret", "claude-model", &mockCache{})
+	got, err := agent.QueryWithContext(context.Background(), "1", "question", "system")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got != "claude answer" {
+		t.Errorf("got %s, want claude answer", got)
+	}
+	if gotHeaders.Get("x-api-key") != "secret" || gotHeaders.Get("anthropic-version") != anthropicVersion {
+		t.Errorf("missing authentication or version headers: %v", gotHeaders)
+	}
+	if gotRequest.Model != "claude-model" || gotRequest.System != "system" || gotRequest.MaxTokens != anthropicMaxTokens {
+		t.Errorf("unexpected request: %+v", gotRequest)
+	}
+}
+
+func TestAnthropicStreamOverHTTP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "event: message_start\ndata: {\"type\": \"message_start\"}\n\n")
+		fmt.Fprint(w, "event: content_block_delta\ndata: {\"type\": \"content_block_delta\", \"index\": 0, \"delta\": {\"type\": \"text_delta\", \"text\": \"foo \"}}\n\n")
+		fmt.Fprint(w, "event: content_block_delta\ndata: {\"type\": \"content_block_delta\", \"index\": 0, \"delta\": {\"type\": \"text_delta\", \"text\": \"bar\"}}\n\n")
+		fmt.Fprint(w, "event: message_stop\ndata: {\"type\": \"message_stop\"}\n\n")
+	}))
+	defer server.Close()
+
+	agent := NewAnthropicAgentWrapper(server.Client(), server.URL, "secret", "claude-model", &mockCache{})
+	deltas := []string{}
+	got, err := agent.StreamWithContext(context.Background(), "1", "question", "system", func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got != "foo bar" || len(deltas) != 2 {
+		t.Errorf("got %s with deltas %v, want foo bar in 2 deltas", got, deltas)
+	}
+}
+
+func TestAnthropicStreamFailures(t *testing.T) {
+	tests := map[string]string{
+		"error without details": "event: error\ndata: {\"type\": \"error\"}\n\n",
+		"ended before stop":     "event: content_block_delta\ndata: {\"type\": \"content_block_delta\", \"index\": 0, \"delta\": {\"type\": \"text_delta\", \"text\": \"foo\"}}\n\n",
+	}
+	for name, events := range tests {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				fmt.Fprint(w, events)
+			}))
+			defer server.Close()
+
+			agent := NewAnthropicAgentWrapper(server.Client(), server.URL, "secret", "claude-model", &mockCache{})
+			if _, err := agent.StreamWithContext(context.Background(), "1", "question", "system", func(string) {}); err == nil {
+				t.Error("expected error for incomplete stream")
+			}
+		})
+	}
+}
+
+func TestAnthropicErrorResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"type": "error", "error": {"type": "rate_limit_error", "message": "slow down"}}`)
+	}))
+	defer server.Close()
+
+	agent := NewAnthropicAgentWrapper(server.Client(), server.URL, "secret", "claude-model", &mockCache{})
+	if _, err := agent.QueryWithContext(context.Background(), "1", "question", "system"); err == nil {
+		t.Error("expected error for rate limited response")
+	}
+}
@@ -0,0 +1,150 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"serious-fin/api/validator"
+	"strings"
+)
+
+var ErrAutoSolveNotAllowed = errors.New("automatic solving is not allowed for this problem")
+
+type CodeValidator interface {
+	Validate(ctx context.Context, body validator.Request) (*validator.Response, error)
+}
+
+type AutoSolveSettingsProvider interface {
+	// returns whether automatic solving is allowed and maximum number of rounds (0 means default)
+	GetAutoSolveSettings(problemId int) (bool, int, error)
+}
+
+type AutoSolveRequest struct {
+	Request
+	MaxRounds int `form:"maxRounds"`
+}
+
+type AutoSolveAttempt struct {
+	Round      int                 `json:"round"`
+	Agent      string              `json:"agent"`
+	Code       string              `json:"code"`
+	Validation *validator.Response `json:"validation"`
+}
+
+type AutoSolveResponse struct {
+	Solved   bool               `json:"solved"`
+	Code     string             `json:"code"`
+	Attempts []AutoSolveAttempt `json:"attempts"`
+}
+
+type AutoSolver struct {
+	Handler          *QueryHandler
+	Validator        CodeValidator
+	Settings         AutoSolveSettingsProvider
+	DefaultMaxRounds int
+}
+
+var failureFeedbackTemplate = `Your code did not pass all tests. Fix the code so that every test passes.
+%s`
+
+func NewAutoSolver(handler *QueryHandler, validator CodeValidator, settings AutoSolveSettingsProvider, defaultMaxRounds int) *AutoSolver {
+	return &AutoSolver{
+		Handler:          handler,
+		Validator:        validator,
+		Settings:         settings,
+		DefaultMaxRounds: defaultMaxRounds,
+	}
+}
+
+// queries the agent and validates its answer. failing tests are sent back to the same session
+// until the code passes or the round limit is reached
+func (solver *AutoSolver) Solve(ctx context.Context, sessionId string, requestBody AutoSolveRequest) (*AutoSolveResponse, error) {
+	maxRounds, err := solver.maxRounds(requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &AutoSolveResponse{
+		Attempts: make([]AutoSolveAttempt, 0, maxRounds),
+	}
+	agentRequest := requestBody.Request
+	for round := 1; round <= maxRounds; round++ {
+		agentResponse, err := solver.Handler.QueryAgent(ctx, sessionId, agentRequest)
+		if err != nil {
+			return nil, fmt.Errorf("error querying agent in round %d: %w", round, err)
+		}
+		code := agentResponse.Response
+
+		validation, err := solver.Validator.Validate(ctx, validator.Request{
+			ProblemId: requestBody.ProblemId,
+			Code:      code,
+			Files:     requestBody.Files,
+			Language:  requestBody.Language,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error validating agent answer in round %d: %w", round, err)
+		}
+
+		response.Attempts = append(response.Attempts, AutoSolveAttempt{
+			Round:      round,
+			Agent:      agentResponse.Agent,
+			Code:       code,
+			Validation: validation,
+		})
+		response.Code = code
+		if isSolved(validation) {
+			response.Solved = true
+			err = solver.Handler.RecordOutcome(sessionId, requestBody.ProblemId, validation)
+			if err != nil {
+				fmt.Printf("Session %s: %v\n", sessionId, err)
+			}
+			break
+		}
+
+		agentRequest.Input = fmt.Sprintf(failureFeedbackTemplate, describeFailures(validation))
+		agentRequest.Code = code
+	}
+	return response, nil
+}
+
+func (solver *AutoSolver) maxRounds(requestBody AutoSolveRequest) (int, error) {
+	allowed, problemMaxRounds, err := solver.Settings.GetAutoSolveSettings(requestBody.ProblemId)
+	if err != nil {
+		return 0, fmt.Errorf("could not get auto solve settings: %w", err)
+	}
+	if !allowed {
+		return 0, fmt.Errorf("problem id %d: %w", requestBody.ProblemId, ErrAutoSolveNotAllowed)
+	}
+
+	maxRounds := solver.DefaultMaxRounds
+	if problemMaxRounds > 0 {
+		maxRounds = problemMaxRounds
+	}
+	if requestBody.MaxRounds > 0 && requestBody.MaxRounds < maxRounds {
+		maxRounds = requestBody.MaxRounds
+	}
+	return max(maxRounds, 1), nil
+}
+
+func isSolved(validation *validator.Response) bool {
+	return len(validation.FailedTests) == 0 && len(validation.SucceededTests) > 0 && validation.BuildOutput == ""
+}
+
+func describeFailures(validation *validator.Response) string {
+	var builder strings.Builder
+	if validation.BuildOutput != "" {
+		fmt.Fprintf(&builder, "<compileErrors>\n%s\n</compileErrors>\n", strings.TrimSpace(validation.BuildOutput))
+	}
+	for _, fail := range validation.FailedTests {
+		if fail.Message != validator.WRONG_OUTPUT && fail.Want == "" {
+			// test stopped before comparing, got holds its output
+			fmt.Fprintf(&builder, "<failedTest id=\"%d\">%s:\n%s</failedTest>\n", fail.Id, fail.Message, fail.Got)
+			continue
+		}
+		fmt.Fprintf(&builder, "<failedTest id=\"%d\">%s: got %s, want %s</failedTest>\n", fail.Id, fail.Message, fail.Got, fail.Want)
+	}
+	if builder.Len() == 0 {
+		builder.WriteString("No test produced a result. Make sure the code compiles and keeps the expected function signature.\n")
+	}
+	return strings.TrimSpace(builder.String())
+}
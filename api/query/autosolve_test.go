@@ -0,0 +1,157 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"serious-fin/api/validator"
+	"strings"
+	"testing"
+)
+
+type mockValidator struct {
+	ValidateFunc func(body validator.Request) (*validator.Response, error)
+}
+
+func (mockValidator *mockValidator) Validate(ctx context.Context, body validator.Request) (*validator.Response, error) {
+	if mockValidator.ValidateFunc != nil {
+		return mockValidator.ValidateFunc(body)
+	}
+	return &validator.Response{}, nil
+}
+
+type mockAutoSolveSettings struct {
+	allowed   bool
+	maxRounds int
+}
+
+func (mockSettings *mockAutoSolveSettings) GetAutoSolveSettings(problemId int) (bool, int, error) {
+	return mockSettings.allowed, mockSettings.maxRounds, nil
+}
+
+func passingValidation() *validator.Response {
+	return &validator.Response{SucceededTests: []int{0, 1}, FailedTests: []validator.FailInfo{}}
+}
+
+func failingValidation() *validator.Response {
+	return &validator.Response{
+		SucceededTests: []int{0},
+		FailedTests:    []validator.FailInfo{{Id: 1, Got: "3", Want: "4", Message: validator.WRONG_OUTPUT}},
+	}
+}
+
+func newTestAutoSolver(agent *mockAgent, validate func(body validator.Request) (*validator.Response, error), settings *mockAutoSolveSettings) *AutoSolver {
+	queryHandler := NewQueryHandler(newTestRegistry(&mockAgent{}, agent))
+	return NewAutoSolver(queryHandler, &mockValidator{ValidateFunc: validate}, settings, 3)
+}
+
+func TestAutoSolveStopsWhenSolved(t *testing.T) {
+	queries := 0
+	agent := &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			queries++
+			return "solution", nil
+		},
+	}
+	solver := newTestAutoSolver(agent, func(body validator.Request) (*validator.Response, error) {
+		return passingValidation(), nil
+	}, &mockAutoSolveSettings{allowed: true})
+
+	got, err := solver.Solve(context.Background(), "1", AutoSolveRequest{Request: Request{Input: "input", Agent: GEMINI, ProblemId: 1}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !got.Solved || got.Code != "solution" || len(got.Attempts) != 1 || queries != 1 {
+		t.Errorf("expected to be solved in one round but got %+v after %d queries", got, queries)
+	}
+}
+
+func TestAutoSolveFeedsFailuresBack(t *testing.T) {
+	userQueries := []string{}
+	agent := &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			userQueries = append(userQueries, userQuery)
+			if len(userQueries) == 1 {
+				return "wrong solution", nil
+			}
+			return "fixed solution", nil
+		},
+	}
+	solver := newTestAutoSolver(agent, func(body validator.Request) (*validator.Response, error) {
+		if body.Code == "wrong solution" {
+			return failingValidation(), nil
+		}
+		return passingValidation(), nil
+	}, &mockAutoSolveSettings{allowed: true})
+
+	got, err := solver.Solve(context.Background(), "1", AutoSolveRequest{Request: Request{Input: "input", Code: "code", Agent: GEMINI, ProblemId: 1}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !got.Solved || got.Code != "fixed solution" || len(got.Attempts) != 2 {
+		t.Errorf("expected to be solved in second round but got %+v", got)
+	}
+	if !strings.Contains(userQueries[1], "got 3, want 4") {
+		t.Errorf("expected failing test in follow-up query but got %s", userQueries[1])
+	}
+	if !strings.Contains(userQueries[1], "wrong solution") {
+		t.Errorf("expected previous answer as code in follow-up query but got %s", userQueries[1])
+	}
+}
+
+func TestAutoSolveStopsAfterMaxRounds(t *testing.T) {
+	agent := &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			return "wrong solution", nil
+		},
+	}
+	solver := newTestAutoSolver(agent, func(body validator.Request) (*validator.Response, error) {
+		return failingValidation(), nil
+	}, &mockAutoSolveSettings{allowed: true, maxRounds: 5})
+
+	got, err := solver.Solve(context.Background(), "1", AutoSolveRequest{Request: Request{Agent: GEMINI, ProblemId: 1}, MaxRounds: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got.Solved || len(got.Attempts) != 2 {
+		t.Errorf("expected two unsuccessful attempts but got %+v", got)
+	}
+}
+
+func TestAutoSolveProblemLimitOverridesDefault(t *testing.T) {
+	agent := &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			return "wrong solution", nil
+		},
+	}
+	solver := newTestAutoSolver(agent, func(body validator.Request) (*validator.Response, error) {
+		return failingValidation(), nil
+	}, &mockAutoSolveSettings{allowed: true, maxRounds: 5})
+
+	got, err := solver.Solve(context.Background(), "1", AutoSolveRequest{Request: Request{Agent: GEMINI, ProblemId: 1}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(got.Attempts) != 5 {
+		t.Errorf("got %d attempts, want 5", len(got.Attempts))
+	}
+}
+
+func TestAutoSolveNotAllowed(t *testing.T) {
+	solver := newTestAutoSolver(&mockAgent{}, nil, &mockAutoSolveSettings{allowed: false})
+
+	_, err := solver.Solve(context.Background(), "1", AutoSolveRequest{Request: Request{Agent: GEMINI, ProblemId: 1}})
+	if !errors.Is(err, ErrAutoSolveNotAllowed) {
+		t.Errorf("got error %v, want %v", err, ErrAutoSolveNotAllowed)
+	}
+}
+
+func TestDescribeFailuresWithBuildOutput(t *testing.T) {
+	got := describeFailures(&validator.Response{BuildOutput: "./code_test.go:3:29: undefined: x\n"})
+	if !strings.Contains(got, "undefined: x") {
+		t.Errorf("expected compile errors in description but got %s", got)
+	}
+}
@@ -2,6 +2,7 @@ package query
 
 import (
 	"fmt"
+	"slices"
 	"sync"
 	"time"
 )
@@ -19,6 +20,20 @@ type CacheInterface interface {
 	StopCleanupRoutine()
 }
 
+// implemented by caches which condense old turns into a summary. the summary is returned apart from the turns,
+// so trimming history to a token budget keeps it and hands the turns it drops over to be summarised
+type SummarisingCache interface {
+	// summary is empty when the session has none
+	GetWithSummary(sessionId string) ([]Context, string)
+	// removes the given contexts from the front of the session and summarises them, returns whether they were removed
+	Evict(sessionId string, evicted []Context) bool
+}
+
+// keeps summaries next to the stored conversation, so they outlive the in-memory session
+type SummaryStore interface {
+	StoreSummary(sessionId, summary string) error
+}
+
 type Context struct {
 	Role    string
 	Content string
@@ -33,6 +48,13 @@ type ContextCache struct {
 	sessionTimeout  time.Duration
 	stopChan        chan struct{}
 	nowFunc         func() time.Time
+	summariser      Summariser
+	summaries       map[string]string
+	summaryStore    SummaryStore
+	// evicted contexts waiting for the summariser and sessions which are being summarised
+	pendingEvicted map[string][]Context
+	summarising    map[string]bool
+	summaryWait    sync.WaitGroup
 }
 
 // maxSize - number of most recent requests/responses to cache;
@@ -65,9 +87,28 @@ func NewContextCacheWithTimeFunc(maxSize int, cleanupInterval time.Duration, ses
 		sessionTimeout:  sessionTimeout,
 		stopChan:        make(chan struct{}),
 		nowFunc:         nowFunc,
+		summaries:       make(map[string]string),
+		pendingEvicted:  make(map[string][]Context),
+		summarising:     make(map[string]bool),
 	}, nil
 }
 
+// when set, contexts removed from a full session or over the token budget of an agent are condensed
+// into a summary, which is returned in front of the remaining contexts instead of being forgotten
+func (cc *ContextCache) SetSummariser(summariser Summariser) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.summariser = summariser
+}
+
+func (cc *ContextCache) SetSummaryStore(store SummaryStore) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.summaryStore = store
+}
+
+// contexts removed to keep the session within maxSize are summarised in the background,
+// so the summary agent does not delay the request which filled the session
 func (cc *ContextCache) Add(sessionId, userInput, aiOutput string) {
 	cc.mu.Lock()
 	defer cc.mu.Unlock()
@@ -84,6 +125,10 @@ func (cc *ContextCache) Add(sessionId, userInput, aiOutput string) {
 
 	if len(cache) > cc.maxSize*2 {
 		valuesToRemove := (len(cache) - (cc.maxSize * 2))
+		if cc.summariser != nil {
+			cc.pendingEvicted[sessionId] = append(cc.pendingEvicted[sessionId], cache[:valuesToRemove]...)
+			cc.startSummarising(sessionId)
+		}
 		cache = cache[valuesToRemove:]
 	}
 	cc.cache[sessionId] = cache
@@ -91,12 +136,108 @@ func (cc *ContextCache) Add(sessionId, userInput, aiOutput string) {
 	fmt.Printf("Session %s: Added contexts. Current count: %d\n", sessionId, len(cc.cache[sessionId]))
 }
 
+// a session is summarised by a single goroutine at a time, so every summary builds on the previous one
+func (cc *ContextCache) startSummarising(sessionId string) {
+	if cc.summarising[sessionId] {
+		return
+	}
+	cc.summarising[sessionId] = true
+	cc.summaryWait.Add(1)
+	go cc.summarise(sessionId)
+}
+
+// summariser is called without holding the lock, since it usually queries an agent.
+// on error the previous summary is kept and evicted contexts are dropped
+func (cc *ContextCache) summarise(sessionId string) {
+	defer cc.summaryWait.Done()
+	for {
+		cc.mu.Lock()
+		evicted := cc.pendingEvicted[sessionId]
+		delete(cc.pendingEvicted, sessionId)
+		if len(evicted) == 0 {
+			delete(cc.summarising, sessionId)
+			cc.mu.Unlock()
+			return
+		}
+		summariser := cc.summariser
+		store := cc.summaryStore
+		previousSummary := cc.summaries[sessionId]
+		cc.mu.Unlock()
+
+		summary, err := summariser.Summarise(previousSummary, evicted)
+		if err != nil {
+			fmt.Printf("Session %s: could not summarise evicted contexts: %v\n", sessionId, err)
+			continue
+		}
+
+		cc.mu.Lock()
+		// session may have been cleaned up meanwhile, a summary alone would hide its stored history
+		if _, exists := cc.cache[sessionId]; exists {
+			cc.summaries[sessionId] = summary
+		}
+		cc.mu.Unlock()
+		fmt.Printf("Session %s: Summarised %d evicted contexts.\n", sessionId, len(evicted))
+
+		if store != nil {
+			err = store.StoreSummary(sessionId, summary)
+			if err != nil {
+				fmt.Printf("Session %s: could not store summary: %v\n", sessionId, err)
+			}
+		}
+	}
+}
+
+// blocks until summaries of already evicted contexts are done
+func (cc *ContextCache) waitForSummaries() {
+	cc.summaryWait.Wait()
+}
+
 func (cc *ContextCache) Get(sessionId string) []Context {
+	contexts, summary := cc.GetWithSummary(sessionId)
+	if summary == "" {
+		return contexts
+	}
+	return append(summaryContexts(summary), contexts...)
+}
+
+func (cc *ContextCache) GetWithSummary(sessionId string) ([]Context, string) {
 	cc.mu.Lock()
 	defer cc.mu.Unlock()
 	cc.lastAccessed[sessionId] = cc.nowFunc()
 	fmt.Printf("Session %s: Retrieved contexts.\n", sessionId)
-	return cc.cache[sessionId]
+	return cc.cache[sessionId], cc.summaries[sessionId]
+}
+
+// contexts are only removed when a summariser is set and they are still the oldest of the session,
+// the most recent request/response pair is never removed
+func (cc *ContextCache) Evict(sessionId string, evicted []Context) bool {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	cache := cc.cache[sessionId]
+	if cc.summariser == nil || len(evicted) == 0 || len(evicted) > len(cache)-2 || !slices.Equal(cache[:len(evicted)], evicted) {
+		return false
+	}
+	cc.pendingEvicted[sessionId] = append(cc.pendingEvicted[sessionId], evicted...)
+	cc.startSummarising(sessionId)
+	cc.cache[sessionId] = cache[len(evicted):]
+	fmt.Printf("Session %s: Evicted %d contexts over the token budget.\n", sessionId, len(evicted))
+	return true
+}
+
+// replaces history of the session, used when a conversation is loaded from storage. empty summary means none
+func (cc *ContextCache) set(sessionId string, contexts []Context, summary string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if len(contexts) > cc.maxSize*2 {
+		contexts = contexts[len(contexts)-cc.maxSize*2:]
+	}
+	cc.cache[sessionId] = contexts
+	cc.lastAccessed[sessionId] = cc.nowFunc()
+	if summary != "" {
+		cc.summaries[sessionId] = summary
+	}
 }
 
 func (cc *ContextCache) StartCleanupRoutine() {
@@ -141,5 +282,6 @@ func (cc *ContextCache) cleanupStaleSessions() {
 		fmt.Printf("Cleanup: Deleted session %s (not accessed for %s).\n", sessionId, now.Sub(cc.lastAccessed[sessionId]))
 		delete(cc.cache, sessionId)
 		delete(cc.lastAccessed, sessionId)
+		delete(cc.summaries, sessionId)
 	}
 }
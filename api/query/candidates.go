@@ -0,0 +1,235 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"serious-fin/api/validator"
+	"sync"
+	"time"
+)
+
+var ErrInvalidCandidates = errors.New("invalid candidates request")
+
+// asks one agent for several independent answers and keeps the one passing the most tests.
+// validations run in parallel, limited by the validator itself
+type CandidateSelector struct {
+	Validator     CodeValidator
+	MaxCandidates int
+}
+
+// error is set when the candidate could not be generated or validated
+type CandidateSummary struct {
+	Index       int    `json:"index"`
+	PassedTests int    `json:"passedTests"`
+	FailedTests int    `json:"failedTests"`
+	CodeLength  int    `json:"codeLength"`
+	LatencyMs   int64  `json:"latencyMs"`
+	Selected    bool   `json:"selected"`
+	Error       string `json:"error,omitempty"`
+}
+
+type candidate struct {
+	Summary CandidateSummary
+	Output  string
+	Agent   string
+	Code    string
+	Merged  []string
+	Err     error
+}
+
+// candidate sessions being generated, mapped to the session they were generated for.
+// only registered sessions are treated as candidates, whatever their id looks like
+type candidateSessions struct {
+	mu    sync.Mutex
+	bases map[string]string
+}
+
+// candidate sessions read the history of the session they were generated for and store nothing,
+// so candidates do not see each other and only the selected answer is added to the history
+type candidateCache struct {
+	CacheInterface
+	sessions *candidateSessions
+}
+
+func NewCandidateSelector(validator CodeValidator, maxCandidates int) *CandidateSelector {
+	return &CandidateSelector{
+		Validator:     validator,
+		MaxCandidates: maxCandidates,
+	}
+}
+
+func newCandidateSessions() *candidateSessions {
+	return &candidateSessions{
+		bases: make(map[string]string),
+	}
+}
+
+func (sessions *candidateSessions) register(candidateSessionId, sessionId string) {
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+	sessions.bases[candidateSessionId] = sessionId
+}
+
+func (sessions *candidateSessions) unregister(candidateSessionId string) {
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+	delete(sessions.bases, candidateSessionId)
+}
+
+// returns the session a candidate session was generated for, false for any other session
+func (sessions *candidateSessions) base(sessionId string) (string, bool) {
+	sessions.mu.Lock()
+	defer sessions.mu.Unlock()
+	baseSessionId, ok := sessions.bases[sessionId]
+	return baseSessionId, ok
+}
+
+func (cache candidateCache) Add(sessionId, userInput, aiOutput string) {
+	if _, isCandidate := cache.sessions.base(sessionId); isCandidate {
+		return
+	}
+	cache.CacheInterface.Add(sessionId, userInput, aiOutput)
+}
+
+func (cache candidateCache) Get(sessionId string) []Context {
+	if baseSessionId, isCandidate := cache.sessions.base(sessionId); isCandidate {
+		return cache.CacheInterface.Get(baseSessionId)
+	}
+	return cache.CacheInterface.Get(sessionId)
+}
+
+// summary is kept out of the history of a cache which does not summarise
+func (cache candidateCache) GetWithSummary(sessionId string) ([]Context, string) {
+	if baseSessionId, isCandidate := cache.sessions.base(sessionId); isCandidate {
+		sessionId = baseSessionId
+	}
+	summarising, ok := cache.CacheInterface.(SummarisingCache)
+	if !ok {
+		return cache.CacheInterface.Get(sessionId), ""
+	}
+	return summarising.GetWithSummary(sessionId)
+}
+
+func (cache candidateCache) Evict(sessionId string, evicted []Context) bool {
+	if baseSessionId, isCandidate := cache.sessions.base(sessionId); isCandidate {
+		sessionId = baseSessionId
+	}
+	summarising, ok := cache.CacheInterface.(SummarisingCache)
+	return ok && summarising.Evict(sessionId, evicted)
+}
+
+func candidateSessionId(sessionId string, index int) string {
+	return fmt.Sprintf("%s#candidate-%d", sessionId, index)
+}
+
+// candidates are not corrected, code which does not compile simply passes no tests
+func (handler *QueryHandler) queryCandidates(ctx context.Context, sessionId string, requestBody Request, prompt *PromptTemplate, userQuery string) (*Response, error) {
+	if requestBody.ProblemId == 0 {
+		return nil, fmt.Errorf("candidates are selected by tests of a problem: %w", ErrInvalidCandidates)
+	}
+	count := min(requestBody.Candidates, handler.Selector.MaxCandidates)
+
+	candidates := make([]candidate, count)
+	var wg sync.WaitGroup
+	for index := range candidates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			candidates[index] = handler.generateCandidate(ctx, sessionId, index, requestBody, prompt, userQuery)
+		}()
+	}
+	wg.Wait()
+
+	best := bestCandidate(candidates)
+	if best == -1 {
+		return nil, fmt.Errorf("none of %d candidates could be validated: %w", count, candidates[0].Err)
+	}
+	candidates[best].Summary.Selected = true
+	if handler.Agents.history != nil {
+		handler.Agents.history.Add(sessionId, userQuery, candidates[best].Output)
+	}
+
+	response := &Response{
+		Response:           formatCode(candidates[best].Code, requestBody.Language),
+		Agent:              candidates[best].Agent,
+		MergedDeclarations: candidates[best].Merged,
+		Candidates:         make([]CandidateSummary, 0, count),
+	}
+	response.Diff = unifiedDiff(requestBody.Code, response.Response)
+	for _, generated := range candidates {
+		response.Candidates = append(response.Candidates, generated.Summary)
+	}
+	return response, nil
+}
+
+func (handler *QueryHandler) generateCandidate(ctx context.Context, sessionId string, index int, requestBody Request, prompt *PromptTemplate, userQuery string) candidate {
+	generated := candidate{
+		Summary: CandidateSummary{Index: index},
+	}
+	candidateSession := candidateSessionId(sessionId, index)
+	handler.Agents.candidates.register(candidateSession, sessionId)
+	defer handler.Agents.candidates.unregister(candidateSession)
+
+	start := time.Now()
+	output, agentName, err := handler.dispatchToAgent(ctx, requestBody.Agent, candidateSession, userQuery, prompt.SystemPrompt)
+	generated.Summary.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		return candidateFailed(candidateSession, generated, fmt.Errorf("error querying agent: %w", err))
+	}
+	generated.Output = output
+	generated.Agent = agentName
+
+	generated.Code, generated.Merged, err = prepareCode(candidateSession, output, requestBody)
+	if err != nil {
+		return candidateFailed(candidateSession, generated, err)
+	}
+	generated.Summary.CodeLength = len(generated.Code)
+
+	validation, err := handler.Selector.Validator.Validate(ctx, validator.Request{
+		ProblemId: requestBody.ProblemId,
+		Code:      generated.Code,
+		Files:     requestBody.Files,
+		Language:  requestBody.Language,
+	})
+	if err != nil {
+		return candidateFailed(candidateSession, generated, fmt.Errorf("error validating candidate: %w", err))
+	}
+	generated.Summary.PassedTests = len(validation.SucceededTests)
+	generated.Summary.FailedTests = len(validation.FailedTests)
+	return generated
+}
+
+func candidateFailed(sessionId string, generated candidate, err error) candidate {
+	fmt.Printf("Session %s: %v\n", sessionId, err)
+	generated.Err = err
+	generated.Summary.Error = "Candidate could not be generated or validated"
+	if errors.Is(err, ErrNoCodeInResponse) {
+		generated.Summary.Error = "Agent response did not contain code"
+	}
+	return generated
+}
+
+// most passed tests wins, ties go to shorter code and then to the faster answer. -1 when every candidate failed
+func bestCandidate(candidates []candidate) int {
+	best := -1
+	for index, current := range candidates {
+		if current.Summary.Error != "" {
+			continue
+		}
+		if best == -1 || isBetterCandidate(current.Summary, candidates[best].Summary) {
+			best = index
+		}
+	}
+	return best
+}
+
+func isBetterCandidate(current, best CandidateSummary) bool {
+	if current.PassedTests != best.PassedTests {
+		return current.PassedTests > best.PassedTests
+	}
+	if current.CodeLength != best.CodeLength {
+		return current.CodeLength < best.CodeLength
+	}
+	return current.LatencyMs < best.LatencyMs
+}
@@ -0,0 +1,123 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"serious-fin/api/validator"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// answers with a different candidate for every candidate session, named after its last character
+func newCandidateAgent(answers map[string]string) *mockAgent {
+	return &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			answer, ok := answers[sessionId[len(sessionId)-1:]]
+			if !ok {
+				return "", errors.New("agent failed")
+			}
+			return answer, nil
+		},
+	}
+}
+
+// passes as many tests as the code has lines
+func linesPassingValidator() *mockValidator {
+	return &mockValidator{
+		ValidateFunc: func(body validator.Request) (*validator.Response, error) {
+			passed := make([]int, strings.Count(body.Code, "\n")+1)
+			return &validator.Response{SucceededTests: passed, FailedTests: []validator.FailInfo{{Id: 9}}}, nil
+		},
+	}
+}
+
+func TestQueryAgentSelectsBestCandidate(t *testing.T) {
+	agent := newCandidateAgent(map[string]string{
+		"0": "one line",
+		"1": "two\nlines long",
+		"2": "two\nlines",
+	})
+	var mu sync.Mutex
+	stored := []string{}
+	registry := newTestRegistry(&mockAgent{}, agent)
+	registry.history = &mockCache{
+		AddFunc: func(sessionId, userInput, aiOutput string) {
+			mu.Lock()
+			defer mu.Unlock()
+			stored = append(stored, sessionId+": "+aiOutput)
+		},
+	}
+	queryHandler := NewQueryHandler(registry)
+	queryHandler.SetCandidateSelector(NewCandidateSelector(linesPassingValidator(), 3))
+
+	got, err := queryHandler.QueryAgent(context.Background(), "1", Request{Agent: GEMINI, Language: "lang", ProblemId: 1, Candidates: 5})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Response != "two\nlines" || len(got.Candidates) != 3 {
+		t.Fatalf("expected shorter of the best candidates out of 3 but got %+v", got)
+	}
+	if !got.Candidates[2].Selected || got.Candidates[2].PassedTests != 2 || got.Candidates[2].FailedTests != 1 || got.Candidates[0].Selected {
+		t.Errorf("unexpected candidate summaries %+v", got.Candidates)
+	}
+	if len(stored) != 1 || stored[0] != "1: two\nlines" {
+		t.Errorf("expected only the selected answer in the session history but got %v", stored)
+	}
+}
+
+func TestQueryAgentSkipsFailedCandidates(t *testing.T) {
+	queryHandler := NewQueryHandler(newTestRegistry(&mockAgent{}, newCandidateAgent(map[string]string{"1": "answer"})))
+	queryHandler.SetCandidateSelector(NewCandidateSelector(linesPassingValidator(), 5))
+
+	got, err := queryHandler.QueryAgent(context.Background(), "1", Request{Agent: GEMINI, ProblemId: 1, Candidates: 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Response != "answer" || got.Candidates[0].Error == "" || got.Candidates[0].Selected {
+		t.Errorf("expected failed candidate to be skipped but got %+v", got)
+	}
+}
+
+func TestQueryAgentFailsWhenNoCandidateWorks(t *testing.T) {
+	queryHandler := NewQueryHandler(newTestRegistry(&mockAgent{}, newCandidateAgent(map[string]string{})))
+	queryHandler.SetCandidateSelector(NewCandidateSelector(linesPassingValidator(), 5))
+
+	if _, err := queryHandler.QueryAgent(context.Background(), "1", Request{Agent: GEMINI, ProblemId: 1, Candidates: 2}); err == nil {
+		t.Error("expected error when every candidate failed")
+	}
+	_, err := queryHandler.QueryAgent(context.Background(), "1", Request{Agent: GEMINI, Candidates: 2})
+	if !errors.Is(err, ErrInvalidCandidates) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidCandidates)
+	}
+}
+
+func TestCandidateCacheSharesHistoryWithoutStoring(t *testing.T) {
+	added := []string{}
+	sessions := newCandidateSessions()
+	cache := candidateCache{&mockCache{
+		AddFunc: func(sessionId, userInput, aiOutput string) {
+			added = append(added, sessionId)
+		},
+		GetFunc: func(sessionId string) []Context {
+			return []Context{{Role: RoleUser, Content: sessionId}}
+		},
+	}, sessions}
+	sessions.register(candidateSessionId("1", 0), "1")
+
+	cache.Add(candidateSessionId("1", 0), "query", "answer")
+	cache.Add("1", "query", "answer")
+	if len(added) != 1 || added[0] != "1" {
+		t.Errorf("expected only the base session to be stored but got %v", added)
+	}
+	if got := cache.Get(candidateSessionId("1", 0)); got[0].Content != "1" {
+		t.Errorf("expected candidate to read base session history but got %v", got)
+	}
+
+	// ids of user sessions are not interpreted, only registered candidates are kept out of the history
+	sessions.unregister(candidateSessionId("1", 0))
+	cache.Add(candidateSessionId("1", 0), "query", "answer")
+	if len(added) != 2 || added[1] != candidateSessionId("1", 0) {
+		t.Errorf("expected unregistered session to be stored but got %v", added)
+	}
+}
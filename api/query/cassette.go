@@ -0,0 +1,214 @@
+package query
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrCassetteMiss = errors.New("no recorded interaction for request")
+
+const (
+	CassetteRecord = "record"
+	CassetteReplay = "replay"
+)
+
+// wraps an agent and stores every interaction to a file in record mode, or serves stored interactions
+// without calling the agent in replay mode
+type CassetteAgent struct {
+	Agent Agent
+	Model string
+	Mode  string
+	Dir   string
+	Cache CacheInterface
+}
+
+type CassetteMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type CassetteInteraction struct {
+	Model        string            `json:"model"`
+	SystemPrompt string            `json:"systemPrompt"`
+	Messages     []CassetteMessage `json:"messages"`
+	Output       string            `json:"output"`
+}
+
+// agent is not used in replay mode and can be nil
+func NewCassetteAgent(agent Agent, model, mode, dir string, cache CacheInterface) (*CassetteAgent, error) {
+	if mode != CassetteRecord && mode != CassetteReplay {
+		return nil, fmt.Errorf("unknown cassette mode %s", mode)
+	}
+	if mode == CassetteRecord && agent == nil {
+		return nil, fmt.Errorf("agent is required in record mode")
+	}
+	return &CassetteAgent{
+		Agent: agent,
+		Model: model,
+		Mode:  mode,
+		Dir:   dir,
+		Cache: cache,
+	}, nil
+}
+
+// every configured agent is wrapped with a cassette. real agents are only created in record mode,
+// so replaying does not need any credentials. they retry, time out and fall back like in the default registry
+func NewCassetteRegistryFromConfig(configs []AgentConfig, mode, dir string, cache CacheInterface, ctx context.Context) (*AgentRegistry, error) {
+	registry := NewAgentRegistry()
+	registry.history = cache
+	for _, config := range configs {
+		agentCache := newAgentCache(cache, config, registry.candidates)
+		var agent Agent
+		if mode == CassetteRecord {
+			var err error
+			agent, err = newAgentFromConfig(config, agentCache, ctx)
+			if err != nil {
+				return nil, fmt.Errorf("could not create agent %s: %w", config.Name, err)
+			}
+			agent = newResilientAgent(agent, config)
+		}
+
+		cassette, err := NewCassetteAgent(agent, config.Model, mode, dir, agentCache)
+		if err != nil {
+			return nil, fmt.Errorf("could not create cassette for agent %s: %w", config.Name, err)
+		}
+		err = registry.Register(AgentInfo{
+			Name:     config.Name,
+			Provider: config.Provider,
+			Model:    config.Model,
+		}, cassette)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := setFallbacks(registry, configs)
+	if err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+func (cassette *CassetteAgent) QueryWithContext(ctx context.Context, sessionId, userQuery, systemPrompt string) (string, error) {
+	return cassette.play(sessionId, userQuery, systemPrompt, func() (string, error) {
+		return cassette.Agent.QueryWithContext(ctx, sessionId, userQuery, systemPrompt)
+	}, nil)
+}
+
+// replayed response is passed to onDelta as a single piece
+func (cassette *CassetteAgent) StreamWithContext(ctx context.Context, sessionId, userQuery, systemPrompt string, onDelta func(string)) (string, error) {
+	return cassette.play(sessionId, userQuery, systemPrompt, func() (string, error) {
+		return cassette.Agent.StreamWithContext(ctx, sessionId, userQuery, systemPrompt, onDelta)
+	}, onDelta)
+}
+
+// interaction has to be built before calling the agent, because the agent adds the new turn to the session context
+func (cassette *CassetteAgent) play(sessionId, userQuery, systemPrompt string, call func() (string, error), onDelta func(string)) (string, error) {
+	interaction := cassette.buildInteraction(sessionId, userQuery, systemPrompt)
+	filePath := filepath.Join(cassette.Dir, cassetteKey(interaction)+".json")
+
+	if cassette.Mode == CassetteReplay {
+		recorded, err := readCassette(filePath)
+		if err != nil {
+			return "", err
+		}
+		if onDelta != nil && recorded.Output != "" {
+			onDelta(recorded.Output)
+		}
+		cassette.Cache.Add(sessionId, userQuery, recorded.Output)
+		return recorded.Output, nil
+	}
+
+	output, err := call()
+	if err != nil {
+		return "", err
+	}
+	interaction.Output = output
+	err = writeCassette(filePath, interaction)
+	if err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
+func (cassette *CassetteAgent) buildInteraction(sessionId, userQuery, systemPrompt string) CassetteInteraction {
+	previousContext := previousContextWithinBudget(cassette.Cache, sessionId, userQuery, systemPrompt)
+	messages := make([]CassetteMessage, 0, len(previousContext)+1)
+	for _, context := range previousContext {
+		messages = append(messages, CassetteMessage{
+			Role:    context.Role,
+			Content: context.Content,
+		})
+	}
+	messages = append(messages, CassetteMessage{
+		Role:    RoleUser,
+		Content: userQuery,
+	})
+
+	return CassetteInteraction{
+		Model:        cassette.Model,
+		SystemPrompt: systemPrompt,
+		Messages:     messages,
+	}
+}
+
+// session id is not part of the key, so a recording can be replayed in any session
+func cassetteKey(interaction CassetteInteraction) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00", interaction.Model, normaliseCassetteText(interaction.SystemPrompt))
+	for _, message := range interaction.Messages {
+		fmt.Fprintf(hash, "%s\x00%s\x00", message.Role, normaliseCassetteText(message.Content))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// ignores line ending style and trailing spaces
+func normaliseCassetteText(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+func readCassette(filePath string) (*CassetteInteraction, error) {
+	content, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("cassette %s: %w", filepath.Base(filePath), ErrCassetteMiss)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read cassette: %w", err)
+	}
+
+	var interaction CassetteInteraction
+	err = json.Unmarshal(content, &interaction)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal cassette %s: %w", filepath.Base(filePath), err)
+	}
+	return &interaction, nil
+}
+
+func writeCassette(filePath string, interaction CassetteInteraction) error {
+	err := os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		return fmt.Errorf("could not create cassette directory: %w", err)
+	}
+
+	content, err := json.MarshalIndent(interaction, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal cassette: %w", err)
+	}
+	err = os.WriteFile(filePath, content, 0644)
+	if err != nil {
+		return fmt.Errorf("could not write cassette: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCassetteRecordThenReplay(t *testing.T) {
+	dir := t.TempDir()
+	calls := 0
+	agent := &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			calls++
+			return "recorded answer", nil
+		},
+	}
+
+	recorder, err := NewCassetteAgent(agent, "gpt-4o", CassetteRecord, dir, &mockCache{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := recorder.QueryWithContext(context.Background(), "1", "query", systemPrompt); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	added := []string{}
+	player, err := NewCassetteAgent(nil, "gpt-4o", CassetteReplay, dir, &mockCache{
+		AddFunc: func(sessionId, userInput, aiOutput string) {
+			added = append(added, userInput, aiOutput)
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := player.QueryWithContext(context.Background(), "2", "query\r\n", systemPrompt)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got != "recorded answer" {
+		t.Errorf("got %s, want recorded answer", got)
+	}
+	if calls != 1 {
+		t.Errorf("expected agent to be called once but was called %d times", calls)
+	}
+	if len(added) != 2 || added[1] != "recorded answer" {
+		t.Errorf("expected replayed turn to be cached but got %v", added)
+	}
+}
+
+func TestCassetteReplayMiss(t *testing.T) {
+	player, _ := NewCassetteAgent(nil, "gpt-4o", CassetteReplay, t.TempDir(), &mockCache{})
+
+	_, err := player.QueryWithContext(context.Background(), "1", "query", systemPrompt)
+	if !errors.Is(err, ErrCassetteMiss) {
+		t.Errorf("expected cassette miss but got %v", err)
+	}
+}
+
+func TestCassetteStreamReplay(t *testing.T) {
+	dir := t.TempDir()
+	agent := &mockAgent{
+		StreamWithContextFunc: func(sessionId, userQuery, systemPrompt string, onDelta func(string)) (string, error) {
+			onDelta("foo ")
+			onDelta("bar")
+			return "foo bar", nil
+		},
+	}
+	recorder, _ := NewCassetteAgent(agent, "gemini", CassetteRecord, dir, &mockCache{})
+	recorder.StreamWithContext(context.Background(), "1", "query", systemPrompt, func(string) {})
+
+	player, _ := NewCassetteAgent(nil, "gemini", CassetteReplay, dir, &mockCache{})
+	deltas := []string{}
+	got, err := player.StreamWithContext(context.Background(), "1", "query", systemPrompt, func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "foo bar" || len(deltas) != 1 || deltas[0] != "foo bar" {
+		t.Errorf("got %s with deltas %v", got, deltas)
+	}
+}
+
+func TestCassetteKeyDependsOnContextAndModel(t *testing.T) {
+	base := CassetteInteraction{
+		Model:        "gpt-4o",
+		SystemPrompt: "system",
+		Messages:     []CassetteMessage{{Role: RoleUser, Content: "query"}},
+	}
+	otherModel := base
+	otherModel.Model = "gpt-4o-mini"
+	withHistory := base
+	withHistory.Messages = []CassetteMessage{
+		{Role: RoleUser, Content: "earlier"},
+		{Role: RoleAssistant, Content: "answer"},
+		{Role: RoleUser, Content: "query"},
+	}
+	trailingSpaces := base
+	trailingSpaces.Messages = []CassetteMessage{{Role: RoleUser, Content: "query  \n"}}
+
+	if cassetteKey(base) == cassetteKey(otherModel) {
+		t.Error("expected key to depend on model")
+	}
+	if cassetteKey(base) == cassetteKey(withHistory) {
+		t.Error("expected key to depend on previous messages")
+	}
+	if cassetteKey(base) != cassetteKey(trailingSpaces) {
+		t.Error("expected key to ignore trailing whitespace")
+	}
+}
+
+func TestCassetteRecordWritesReadableFile(t *testing.T) {
+	dir := t.TempDir()
+	agent := &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			return "answer", nil
+		},
+	}
+	recorder, _ := NewCassetteAgent(agent, "gpt-4o", CassetteRecord, dir, &mockCache{})
+	recorder.QueryWithContext(context.Background(), "1", "query", systemPrompt)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected one cassette file but got %v, %v", entries, err)
+	}
+	interaction, err := readCassette(dir + "/" + entries[0].Name())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if interaction.Model != "gpt-4o" || interaction.Output != "answer" || len(interaction.Messages) != 1 {
+		t.Errorf("unexpected recorded interaction %+v", interaction)
+	}
+}
+
+func TestNewCassetteAgentRejectsUnknownMode(t *testing.T) {
+	if _, err := NewCassetteAgent(&mockAgent{}, "gpt-4o", "rewind", t.TempDir(), &mockCache{}); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
+
+func TestCassetteRegistryRecordsThroughResilientAgents(t *testing.T) {
+	registry, err := NewCassetteRegistryFromConfig([]AgentConfig{{Name: "fake", Provider: ProviderFake, TimeoutSeconds: 5}}, CassetteRecord, t.TempDir(), &mockCache{}, context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	agent, _ := registry.Get("fake")
+	resilient, ok := agent.(*CassetteAgent).Agent.(*ResilientAgent)
+	if !ok {
+		t.Fatalf("expected recorded agent to be resilient but got %T", agent.(*CassetteAgent).Agent)
+	}
+	if resilient.Timeout != 5*time.Second {
+		t.Errorf("expected configured timeout but got %v", resilient.Timeout)
+	}
+}
@@ -2,43 +2,64 @@ package query
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"strings"
 
 	"github.com/sashabaranov/go-openai"
 )
 
-type ChatgptAgentWrapperInterface interface {
-	QueryWithContext(sessionId, userQuery, systemPrompt string) (string, error)
-}
-
 type ChatgptAgentWrapper struct {
 	Agent ChatgptInterface
 	Cache CacheInterface
 }
 
 type ChatgptInterface interface {
-	Query(messages []openai.ChatCompletionMessage) (string, error)
+	Query(ctx context.Context, messages []openai.ChatCompletionMessage) (string, error)
+	Stream(ctx context.Context, messages []openai.ChatCompletionMessage, onDelta func(string)) (string, error)
 }
 
 type Chatgpt struct {
 	Client *openai.Client
 	Model  string
-	Ctx    context.Context
 }
 
-func NewChatgptClientWrapper(client *openai.Client, model string, cache *ContextCache, ctx context.Context) *ChatgptAgentWrapper {
+func NewChatgptClientWrapper(client *openai.Client, model string, cache CacheInterface) *ChatgptAgentWrapper {
 	return &ChatgptAgentWrapper{
 		Agent: &Chatgpt{
 			Client: client,
 			Model:  model,
-			Ctx:    ctx,
 		},
 		Cache: cache,
 	}
 }
 
-func (wrapper *ChatgptAgentWrapper) QueryWithContext(sessionId, userQuery, systemPrompt string) (string, error) {
-	previousContext := wrapper.Cache.Get(sessionId)
+func (wrapper *ChatgptAgentWrapper) QueryWithContext(ctx context.Context, sessionId, userQuery, systemPrompt string) (string, error) {
+	messages := wrapper.buildMessages(sessionId, userQuery, systemPrompt)
+	output, err := wrapper.Agent.Query(ctx, messages)
+	if err != nil {
+		return "", fmt.Errorf("could not query chatgpt agent: %w", err)
+	}
+
+	wrapper.Cache.Add(sessionId, userQuery, output)
+	return output, nil
+}
+
+// onDelta is called with every received piece of the response, full response is cached once the stream ends
+func (wrapper *ChatgptAgentWrapper) StreamWithContext(ctx context.Context, sessionId, userQuery, systemPrompt string, onDelta func(string)) (string, error) {
+	messages := wrapper.buildMessages(sessionId, userQuery, systemPrompt)
+	output, err := wrapper.Agent.Stream(ctx, messages, onDelta)
+	if err != nil {
+		return "", fmt.Errorf("could not stream chatgpt agent: %w", err)
+	}
+
+	wrapper.Cache.Add(sessionId, userQuery, output)
+	return output, nil
+}
+
+func (wrapper *ChatgptAgentWrapper) buildMessages(sessionId, userQuery, systemPrompt string) []openai.ChatCompletionMessage {
+	previousContext := previousContextWithinBudget(wrapper.Cache, sessionId, userQuery, systemPrompt)
 	messages := make([]openai.ChatCompletionMessage, 0)
 	messages = append(messages, openai.ChatCompletionMessage{
 		Role:    RoleSystem,
@@ -54,19 +75,12 @@ func (wrapper *ChatgptAgentWrapper) QueryWithContext(sessionId, userQuery, syste
 		Role:    RoleUser,
 		Content: userQuery,
 	})
-
-	output, err := wrapper.Agent.Query(messages)
-	if err != nil {
-		return "", fmt.Errorf("could not query chatgpt agent: %w", err)
-	}
-
-	wrapper.Cache.Add(sessionId, userQuery, output)
-	return output, nil
+	return messages
 }
 
-func (wrapper *Chatgpt) Query(messages []openai.ChatCompletionMessage) (string, error) {
+func (wrapper *Chatgpt) Query(ctx context.Context, messages []openai.ChatCompletionMessage) (string, error) {
 	resp, err := wrapper.Client.CreateChatCompletion(
-		wrapper.Ctx,
+		ctx,
 		openai.ChatCompletionRequest{
 			Model:    wrapper.Model,
 			Messages: messages,
@@ -75,5 +89,40 @@ func (wrapper *Chatgpt) Query(messages []openai.ChatCompletionMessage) (string,
 	if err != nil {
 		return "", fmt.Errorf("could not make API call to chatgpt: %w", err)
 	}
+	if len(resp.Choices) == 0 {
+		return "", fmt.Errorf("no choices were received from chatgpt query")
+	}
 	return resp.Choices[0].Message.Content, nil
 }
+
+func (wrapper *Chatgpt) Stream(ctx context.Context, messages []openai.ChatCompletionMessage, onDelta func(string)) (string, error) {
+	stream, err := wrapper.Client.CreateChatCompletionStream(
+		ctx,
+		openai.ChatCompletionRequest{
+			Model:    wrapper.Model,
+			Messages: messages,
+			Stream:   true,
+		},
+	)
+	if err != nil {
+		return "", fmt.Errorf("could not make streaming API call to chatgpt: %w", err)
+	}
+	defer stream.Close()
+
+	var output strings.Builder
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("could not receive chatgpt stream response: %w", err)
+		}
+		if len(resp.Choices) == 0 || resp.Choices[0].Delta.Content == "" {
+			continue
+		}
+		output.WriteString(resp.Choices[0].Delta.Content)
+		onDelta(resp.Choices[0].Delta.Content)
+	}
+	return output.String(), nil
+}
@@ -1,31 +1,30 @@
 package query
 
 import (
-	"fmt"
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/sashabaranov/go-openai"
 )
 
-type mockChatgptAgentWrapper struct {
-	QueryWithContextFunc func(sessionId, userQuery, systemPrompt string) (string, error)
+type mockChatgpt struct {
+	QueryFunc  func(messages []openai.ChatCompletionMessage) (string, error)
+	StreamFunc func(messages []openai.ChatCompletionMessage, onDelta func(string)) (string, error)
 }
 
-func (mockWrapper *mockChatgptAgentWrapper) QueryWithContext(sessionId, userQuery, systemPrompt string) (string, error) {
-	if mockWrapper.QueryWithContextFunc != nil {
-		return mockWrapper.QueryWithContextFunc(sessionId, userQuery, systemPrompt)
+func (mockChatgpt *mockChatgpt) Query(ctx context.Context, messages []openai.ChatCompletionMessage) (string, error) {
+	if mockChatgpt.QueryFunc != nil {
+		return mockChatgpt.QueryFunc(messages)
 	}
 	return "", nil
 }
 
-type mockChatgpt struct {
-	QueryFunc func(messages []openai.ChatCompletionMessage) (string, error)
-}
-
-func (mockChatgpt *mockChatgpt) Query(messages []openai.ChatCompletionMessage) (string, error) {
-	if mockChatgpt.QueryFunc != nil {
-		return mockChatgpt.QueryFunc(messages)
+func (mockChatgpt *mockChatgpt) Stream(ctx context.Context, messages []openai.ChatCompletionMessage, onDelta func(string)) (string, error) {
+	if mockChatgpt.StreamFunc != nil {
+		return mockChatgpt.StreamFunc(messages, onDelta)
 	}
 	return "", nil
 }
@@ -45,20 +44,18 @@ func TestChatgptShouldAddSystemPromptToQuery(t *testing.T) {
 		Cache: &mockCache{},
 	}
 
-	queryHandler := NewQueryHandler(AIAgents{
-		Chatgpt: chatgptAgentWrapper,
-		Gemini:  &mockGeminiAgentWrapper{},
-	})
+	queryHandler := NewQueryHandler(newTestRegistry(chatgptAgentWrapper, &mockAgent{}))
 
-	got, err := queryHandler.QueryAgent("1", Request{
+	response, err := queryHandler.QueryAgent(context.Background(), "1", Request{
 		Input:    "input",
 		Code:     "code",
 		Language: "lang",
 		Agent:    CHATGPT,
 	})
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Fatalf("unexpected error: %v", err)
 	}
+	got := response.Response
 	want := contextToString([]Context{{
 		Content: systemPrompt,
 		Role:    RoleSystem,
@@ -88,6 +85,7 @@ func TestChatgptShouldAddHistory(t *testing.T) {
 		},
 	}
 
+	var got string
 	chatgptAgentWrapper := &ChatgptAgentWrapper{
 		Agent: &mockChatgpt{
 			QueryFunc: func(messages []openai.ChatCompletionMessage) (string, error) {
@@ -98,7 +96,8 @@ func TestChatgptShouldAddHistory(t *testing.T) {
 						Role:    ctx.Role,
 					})
 				}
-				return contextToString(context), nil
+				got = contextToString(context)
+				return "answer", nil
 			},
 		},
 		Cache: &mockCache{
@@ -108,26 +107,23 @@ func TestChatgptShouldAddHistory(t *testing.T) {
 		},
 	}
 
-	queryHandler := NewQueryHandler(AIAgents{
-		Chatgpt: chatgptAgentWrapper,
-		Gemini:  &mockGeminiAgentWrapper{},
-	})
+	queryHandler := NewQueryHandler(newTestRegistry(chatgptAgentWrapper, &mockAgent{}))
 
-	got, err := queryHandler.QueryAgent("1", Request{
+	_, err := queryHandler.QueryAgent(context.Background(), "1", Request{
 		Input:    "input",
 		Code:     "code",
 		Language: "lang",
 		Agent:    CHATGPT,
 	})
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Fatalf("unexpected error: %v", err)
 	}
 	want := append([]Context{{
 		Content: systemPrompt,
 		Role:    RoleSystem,
 	}}, history...)
 	want = append(want, Context{
-		Content: fmt.Sprintf(userPromptTemplate, "input", "lang", "code"),
+		Content: defaultUserQuery("input", "lang", "code"),
 		Role:    RoleUser,
 	})
 	if got != contextToString(want) {
@@ -136,7 +132,7 @@ func TestChatgptShouldAddHistory(t *testing.T) {
 }
 
 func TestChatgptHistoryShouldSavePreviousRequestsConversation(t *testing.T) {
-	inputs := []string{fmt.Sprintf(userPromptTemplate, "input1", "lang1", "code1"), fmt.Sprintf(userPromptTemplate, "input2", "lang2", "code2")}
+	inputs := []string{defaultUserQuery("input1", "lang1", "code1"), defaultUserQuery("input2", "lang2", "code2")}
 	outputs := []string{"agent response 1", "agent response 2"}
 	requestResponseIndex := 0
 	sessionId := "1"
@@ -150,13 +146,10 @@ func TestChatgptHistoryShouldSavePreviousRequestsConversation(t *testing.T) {
 		Cache: cache,
 	}
 
-	queryHandler := NewQueryHandler(AIAgents{
-		Chatgpt: chatgptAgentWrapper,
-		Gemini:  &mockGeminiAgentWrapper{},
-	})
+	queryHandler := NewQueryHandler(newTestRegistry(chatgptAgentWrapper, &mockAgent{}))
 
 	// send first message. request/response should be cached
-	_, err := queryHandler.QueryAgent(sessionId, Request{
+	_, err := queryHandler.QueryAgent(context.Background(), sessionId, Request{
 		Input:    "input1",
 		Code:     "code1",
 		Language: "lang1",
@@ -168,7 +161,7 @@ func TestChatgptHistoryShouldSavePreviousRequestsConversation(t *testing.T) {
 
 	// send second message. request/response should be cached
 	requestResponseIndex++
-	_, err = queryHandler.QueryAgent(sessionId, Request{
+	_, err = queryHandler.QueryAgent(context.Background(), sessionId, Request{
 		Input:    "input2",
 		Code:     "code2",
 		Language: "lang2",
@@ -207,3 +200,70 @@ func TestChatgptHistoryShouldSavePreviousRequestsConversation(t *testing.T) {
 		t.Errorf("got %s, want %s", got, want)
 	}
 }
+
+func TestChatgptStreamShouldSendDeltasAndCacheFullResponse(t *testing.T) {
+	sessionId := "1"
+	cache, _ := NewContextCache(5, time.Minute, 2*time.Minute)
+	chatgptAgentWrapper := &ChatgptAgentWrapper{
+		Agent: &mockChatgpt{
+			StreamFunc: func(messages []openai.ChatCompletionMessage, onDelta func(string)) (string, error) {
+				onDelta("```go\nfunc ")
+				onDelta("main() {}\n```")
+				return "```go\nfunc main() {}\n```", nil
+			},
+		},
+		Cache: cache,
+	}
+
+	queryHandler := NewQueryHandler(newTestRegistry(chatgptAgentWrapper, &mockAgent{}))
+
+	deltas := []string{}
+	response, err := queryHandler.StreamAgent(context.Background(), sessionId, Request{
+		Input:    "input",
+		Code:     "code",
+		Language: "lang",
+		Agent:    CHATGPT,
+	}, func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := response.Response
+
+	if got != "func main() {}" {
+		t.Errorf("got %s, want post-processed code", got)
+	}
+	if len(deltas) != 2 {
+		t.Errorf("expected 2 deltas but got %d", len(deltas))
+	}
+
+	want := contextToString([]Context{
+		{
+			Content: defaultUserQuery("input", "lang", "code"),
+			Role:    RoleUser,
+		},
+		{
+			Content: "```go\nfunc main() {}\n```",
+			Role:    RoleAssistant,
+		},
+	})
+	if history := contextToString(cache.Get(sessionId)); history != want {
+		t.Errorf("got %s, want %s", history, want)
+	}
+}
+
+func TestChatgptQueryFailsWithoutChoices(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": "1", "object": "chat.completion", "choices": []}`))
+	}))
+	defer server.Close()
+	config := openai.DefaultConfig("key")
+	config.BaseURL = server.URL
+	chatgpt := &Chatgpt{Client: openai.NewClientWithConfig(config), Model: "model"}
+
+	if _, err := chatgpt.Query(context.Background(), []openai.ChatCompletionMessage{{Role: RoleUser, Content: "a"}}); err == nil {
+		t.Error("expected error for response without choices")
+	}
+}
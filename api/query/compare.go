@@ -0,0 +1,165 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"serious-fin/api/validator"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrInvalidComparison = errors.New("invalid comparison")
+
+type CompareRequest struct {
+	Request
+	Agents []string `form:"agents"`
+}
+
+// token counts are estimated the same way as for the history budget, since agents do not report usage.
+// prompt tokens cover the system prompt and queries but not the conversation history.
+// latency and tokens include correction rounds
+type CompareResult struct {
+	Agent          string              `json:"agent"`
+	Response       *Response           `json:"response,omitempty"`
+	Validation     *validator.Response `json:"validation,omitempty"`
+	LatencyMs      int64               `json:"latencyMs"`
+	PromptTokens   int                 `json:"promptTokens"`
+	ResponseTokens int                 `json:"responseTokens"`
+	Error          string              `json:"error,omitempty"`
+}
+
+type CompareResponse struct {
+	Results []CompareResult `json:"results"`
+}
+
+// sends the same prompt to several agents at once and validates every answer.
+// every agent keeps its own conversation, so follow-up comparisons build on each agent's previous answer
+type AgentComparer struct {
+	Handler   *QueryHandler
+	Validator CodeValidator
+}
+
+func NewAgentComparer(handler *QueryHandler, validator CodeValidator) *AgentComparer {
+	return &AgentComparer{
+		Handler:   handler,
+		Validator: validator,
+	}
+}
+
+// an agent failing does not fail the comparison, its result holds the error instead. agents are not replaced
+// by their fallbacks, so every result is the answer of its agent. experiments are not applied,
+// so every agent gets the active prompt
+func (comparer *AgentComparer) Compare(ctx context.Context, sessionId string, requestBody CompareRequest) (*CompareResponse, error) {
+	agents, err := comparer.checkAgents(requestBody.Agents)
+	if err != nil {
+		return nil, err
+	}
+	prompt, err := comparer.Handler.activePrompt(requestBody.Request)
+	if err != nil {
+		return nil, err
+	}
+	userQuery, err := buildUserQuery(prompt, requestBody.Request)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &CompareResponse{
+		Results: make([]CompareResult, len(agents)),
+	}
+	var wg sync.WaitGroup
+	for index, agentName := range agents {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response.Results[index] = comparer.compareAgent(ctx, sessionId, agentName, requestBody, prompt, userQuery)
+		}()
+	}
+	wg.Wait()
+	return response, nil
+}
+
+// duplicates are dropped, unknown agents fail the whole comparison before any agent is queried
+func (comparer *AgentComparer) checkAgents(agentNames []string) ([]string, error) {
+	agents := []string{}
+	for _, agentName := range agentNames {
+		if slices.Contains(agents, agentName) {
+			continue
+		}
+		if _, err := comparer.Handler.Agents.Get(agentName); err != nil {
+			return nil, err
+		}
+		agents = append(agents, agentName)
+	}
+	if len(agents) == 0 {
+		return nil, fmt.Errorf("no agents to compare: %w", ErrInvalidComparison)
+	}
+	return agents, nil
+}
+
+func (comparer *AgentComparer) compareAgent(ctx context.Context, sessionId, agentName string, requestBody CompareRequest, prompt *PromptTemplate, userQuery string) CompareResult {
+	agentSessionId := compareSessionId(sessionId, agentName)
+	agentRequest := requestBody.Request
+	agentRequest.Agent = agentName
+	agentRequest.withoutFallback = true
+	result := CompareResult{
+		Agent:        agentName,
+		PromptTokens: estimateTokens(prompt.SystemPrompt) + estimateTokens(userQuery),
+	}
+
+	start := time.Now()
+	output, answeredBy, err := comparer.Handler.queryNamedAgent(ctx, agentName, agentSessionId, userQuery, prompt.SystemPrompt)
+	if err != nil {
+		result.LatencyMs = time.Since(start).Milliseconds()
+		return comparisonFailed(agentSessionId, result, fmt.Errorf("error querying agent: %w", err))
+	}
+	result.ResponseTokens = estimateTokens(output)
+	comparer.Handler.recordQuery(agentSessionId, answeredBy, agentRequest, prompt, nil)
+
+	result.Response, err = comparer.Handler.buildResponse(ctx, agentSessionId, agentRequest, prompt, output, answeredBy)
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		return comparisonFailed(agentSessionId, result, err)
+	}
+	result.PromptTokens += result.Response.correctionTokens.prompt
+	result.ResponseTokens += result.Response.correctionTokens.response
+	if requestBody.ProblemId == 0 {
+		return result
+	}
+
+	result.Validation, err = comparer.Validator.Validate(ctx, validator.Request{
+		ProblemId: requestBody.ProblemId,
+		Code:      result.Response.Response,
+		Files:     requestBody.Files,
+		Language:  requestBody.Language,
+	})
+	if err != nil {
+		return comparisonFailed(agentSessionId, result, fmt.Errorf("error validating answer: %w", err))
+	}
+	return result
+}
+
+func compareSessionId(sessionId, agentName string) string {
+	return fmt.Sprintf("%s/%s", sessionId, agentName)
+}
+
+// details stay in the log like for other server errors, the result only says what went wrong
+func comparisonFailed(sessionId string, result CompareResult, err error) CompareResult {
+	fmt.Printf("Session %s: %v\n", sessionId, err)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		result.Error = "Agent did not answer in time"
+	case errors.Is(err, context.Canceled):
+		result.Error = "Comparison was cancelled"
+	case errors.Is(err, ErrAgentUnavailable):
+		result.Error = "Agent is temporarily unavailable"
+	case errors.Is(err, ErrNoCodeInResponse):
+		result.Error = "Agent response did not contain code"
+	case result.Response != nil:
+		result.Error = "Answer could not be validated"
+	default:
+		result.Error = "Agent could not be queried"
+	}
+	return result
+}
@@ -0,0 +1,153 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"serious-fin/api/validator"
+	"sync"
+	"testing"
+)
+
+func TestCompareQueriesEveryAgentInItsOwnSession(t *testing.T) {
+	var mu sync.Mutex
+	sessions := map[string]string{}
+	newAgent := func(answer string) *mockAgent {
+		return &mockAgent{
+			QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				sessions[answer] = sessionId
+				return answer, nil
+			},
+		}
+	}
+	validated := []string{}
+	comparer := NewAgentComparer(NewQueryHandler(newTestRegistry(newAgent("chatgpt answer"), newAgent("gemini answer"))), &mockValidator{
+		ValidateFunc: func(body validator.Request) (*validator.Response, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			validated = append(validated, body.Code)
+			if body.Code == "gemini answer" {
+				return passingValidation(), nil
+			}
+			return failingValidation(), nil
+		},
+	})
+
+	got, err := comparer.Compare(context.Background(), "1", CompareRequest{
+		Request: Request{Input: "input", Code: "code", Language: "lang", ProblemId: 3},
+		Agents:  []string{CHATGPT, GEMINI, CHATGPT},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(got.Results) != 2 || got.Results[0].Agent != CHATGPT || got.Results[1].Agent != GEMINI {
+		t.Fatalf("expected results for chatgpt and gemini but got %+v", got.Results)
+	}
+	if got.Results[0].Response.Response != "chatgpt answer" || isSolved(got.Results[0].Validation) {
+		t.Errorf("unexpected chatgpt result %+v", got.Results[0])
+	}
+	if got.Results[1].Response.Response != "gemini answer" || !isSolved(got.Results[1].Validation) {
+		t.Errorf("unexpected gemini result %+v", got.Results[1])
+	}
+	if got.Results[0].PromptTokens == 0 || got.Results[0].ResponseTokens == 0 {
+		t.Errorf("expected token estimates but got %+v", got.Results[0])
+	}
+	if sessions["chatgpt answer"] != "1/chatgpt" || sessions["gemini answer"] != "1/gemini" {
+		t.Errorf("expected separate sessions but got %v", sessions)
+	}
+	if len(validated) != 2 {
+		t.Errorf("expected both answers to be validated but got %v", validated)
+	}
+}
+
+func TestCompareKeepsOtherResultsWhenAgentFails(t *testing.T) {
+	failing := &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			return "", ErrAgentUnavailable
+		},
+	}
+	answering := &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			return "answer", nil
+		},
+	}
+	comparer := NewAgentComparer(NewQueryHandler(newTestRegistry(failing, answering)), &mockValidator{})
+
+	got, err := comparer.Compare(context.Background(), "1", CompareRequest{Agents: []string{CHATGPT, GEMINI}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Results[0].Error != "Agent is temporarily unavailable" || got.Results[0].Response != nil {
+		t.Errorf("expected chatgpt to fail but got %+v", got.Results[0])
+	}
+	if got.Results[1].Error != "" || got.Results[1].Response.Response != "answer" || got.Results[1].Validation != nil {
+		t.Errorf("expected unvalidated gemini answer without problem but got %+v", got.Results[1])
+	}
+}
+
+func TestCompareRejectsInvalidAgents(t *testing.T) {
+	comparer := NewAgentComparer(NewQueryHandler(newTestRegistry(&mockAgent{}, &mockAgent{})), &mockValidator{})
+
+	if _, err := comparer.Compare(context.Background(), "1", CompareRequest{}); !errors.Is(err, ErrInvalidComparison) {
+		t.Errorf("got error %v, want %v", err, ErrInvalidComparison)
+	}
+	if _, err := comparer.Compare(context.Background(), "1", CompareRequest{Agents: []string{CHATGPT, "unknown"}}); !errors.Is(err, ErrUnknownAgent) {
+		t.Errorf("got error %v, want %v", err, ErrUnknownAgent)
+	}
+}
+
+func TestCompareCountsCorrectionRounds(t *testing.T) {
+	answers := []string{"func Double(x int) int {\n\treturn x * 2\n}", "func double(x int) int {\n\treturn x * 2\n}"}
+	queries := []string{}
+	agent := &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			queries = append(queries, userQuery)
+			return answers[len(queries)-1], nil
+		},
+	}
+	handler := NewQueryHandler(newTestRegistry(agent, &mockAgent{}))
+	handler.SetVerifier(newTestVerifier("func double(x int) int {\n\n}"))
+	comparer := NewAgentComparer(handler, &mockValidator{})
+
+	got, err := comparer.Compare(context.Background(), "1", CompareRequest{
+		Request: Request{Language: "go", ProblemId: 1},
+		Agents:  []string{CHATGPT},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result := got.Results[0]
+	wantPromptTokens := 2*estimateTokens(defaultPrompt.SystemPrompt) + estimateTokens(queries[0]) + estimateTokens(queries[1])
+	wantResponseTokens := estimateTokens(answers[0]) + estimateTokens(answers[1])
+	if result.Response.Corrections != 1 || result.PromptTokens != wantPromptTokens || result.ResponseTokens != wantResponseTokens {
+		t.Errorf("expected tokens of both rounds (%d prompt, %d response) but got %+v", wantPromptTokens, wantResponseTokens, result)
+	}
+}
+
+func TestCompareDoesNotFailOver(t *testing.T) {
+	registry := newTestRegistry(&mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			return "", ErrAgentUnavailable
+		},
+	}, &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			if sessionId == compareSessionId("1", CHATGPT) {
+				t.Error("expected fallback agent not to answer in the conversation of chatgpt")
+			}
+			return "answer", nil
+		},
+	})
+	registry.SetFallback(CHATGPT, GEMINI)
+	comparer := NewAgentComparer(NewQueryHandler(registry), &mockValidator{})
+
+	got, err := comparer.Compare(context.Background(), "1", CompareRequest{Agents: []string{CHATGPT}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result := got.Results[0]; result.Agent != CHATGPT || result.Response != nil || result.Error == "" {
+		t.Errorf("expected unavailable chatgpt to fail its result but got %+v", result)
+	}
+}
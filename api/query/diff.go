@@ -0,0 +1,179 @@
+package query
+
+import (
+	"fmt"
+	"go/format"
+	"slices"
+	"strings"
+)
+
+const (
+	diffContextLines = 3
+	// cells of the longest common subsequence table, around 8MB. changed blocks larger than that are
+	// shown as removed and added as a whole, so a large request can not exhaust memory
+	maxDiffTableSize = 1 << 20
+)
+
+type diffLine struct {
+	Kind byte // ' ' unchanged, '-' removed, '+' added
+	Text string
+}
+
+// go code is formatted like gofmt would, code which does not parse is returned unchanged
+func formatCode(code, language string) string {
+	if !isGoLanguage(language) {
+		return code
+	}
+	formatted, err := format.Source([]byte(code))
+	if err != nil {
+		return code
+	}
+	return strings.TrimSpace(string(formatted))
+}
+
+// returns unified diff between submitted and suggested code, empty when nothing changed
+func unifiedDiff(submitted, suggested string) string {
+	lines := diffLines(splitLines(submitted), splitLines(suggested))
+	if !slices.ContainsFunc(lines, func(line diffLine) bool { return line.Kind != ' ' }) {
+		return ""
+	}
+
+	var builder strings.Builder
+	builder.WriteString("--- submitted\n+++ suggested\n")
+	for _, hunk := range diffHunks(lines) {
+		writeHunk(&builder, lines, hunk)
+	}
+	return builder.String()
+}
+
+func splitLines(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.TrimSuffix(text, "\n")
+	if text == "" {
+		return []string{}
+	}
+	return strings.Split(text, "\n")
+}
+
+// edit script built from the longest common subsequence of the lines between the unchanged start and end
+func diffLines(old, new []string) []diffLine {
+	prefix := 0
+	for prefix < len(old) && prefix < len(new) && old[prefix] == new[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(new)-prefix && old[len(old)-1-suffix] == new[len(new)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]diffLine, 0, len(old)+len(new))
+	for _, text := range old[:prefix] {
+		lines = append(lines, diffLine{Kind: ' ', Text: text})
+	}
+	oldChanged, newChanged := old[prefix:len(old)-suffix], new[prefix:len(new)-suffix]
+	if (len(oldChanged)+1)*(len(newChanged)+1) > maxDiffTableSize {
+		lines = appendLines(lines, '-', oldChanged)
+		lines = appendLines(lines, '+', newChanged)
+	} else {
+		lines = append(lines, commonSubsequenceDiff(oldChanged, newChanged)...)
+	}
+	return appendLines(lines, ' ', old[len(old)-suffix:])
+}
+
+func appendLines(lines []diffLine, kind byte, texts []string) []diffLine {
+	for _, text := range texts {
+		lines = append(lines, diffLine{Kind: kind, Text: text})
+	}
+	return lines
+}
+
+func commonSubsequenceDiff(old, new []string) []diffLine {
+	common := make([][]int, len(old)+1)
+	for i := range common {
+		common[i] = make([]int, len(new)+1)
+	}
+	for i := len(old) - 1; i >= 0; i-- {
+		for j := len(new) - 1; j >= 0; j-- {
+			if old[i] == new[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	lines := make([]diffLine, 0, len(old)+len(new))
+	i, j := 0, 0
+	for i < len(old) && j < len(new) {
+		switch {
+		case old[i] == new[j]:
+			lines = append(lines, diffLine{Kind: ' ', Text: old[i]})
+			i++
+			j++
+		case common[i+1][j] >= common[i][j+1]:
+			lines = append(lines, diffLine{Kind: '-', Text: old[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{Kind: '+', Text: new[j]})
+			j++
+		}
+	}
+	lines = appendLines(lines, '-', old[i:])
+	return appendLines(lines, '+', new[j:])
+}
+
+// returns [start, end) ranges of the edit script, changes closer than twice the context are kept in one hunk
+func diffHunks(lines []diffLine) [][2]int {
+	hunks := [][2]int{}
+	for index, line := range lines {
+		if line.Kind == ' ' {
+			continue
+		}
+		start := max(index-diffContextLines, 0)
+		end := min(index+diffContextLines+1, len(lines))
+		if len(hunks) > 0 && start <= hunks[len(hunks)-1][1] {
+			hunks[len(hunks)-1][1] = end
+			continue
+		}
+		hunks = append(hunks, [2]int{start, end})
+	}
+	return hunks
+}
+
+func writeHunk(builder *strings.Builder, lines []diffLine, hunk [2]int) {
+	oldStart, newStart := 1, 1
+	for _, line := range lines[:hunk[0]] {
+		if line.Kind != '+' {
+			oldStart++
+		}
+		if line.Kind != '-' {
+			newStart++
+		}
+	}
+	oldCount, newCount := 0, 0
+	for _, line := range lines[hunk[0]:hunk[1]] {
+		if line.Kind != '+' {
+			oldCount++
+		}
+		if line.Kind != '-' {
+			newCount++
+		}
+	}
+
+	fmt.Fprintf(builder, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+	for _, line := range lines[hunk[0]:hunk[1]] {
+		fmt.Fprintf(builder, "%c%s\n", line.Kind, line.Text)
+	}
+}
+
+// empty ranges point at the line before them, like in diff -u
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start-1)
+	case 1:
+		return fmt.Sprintf("%d", start)
+	default:
+		return fmt.Sprintf("%d,%d", start, count)
+	}
+}
@@ -0,0 +1,119 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFormatCode(t *testing.T) {
+	got := formatCode("func double(x int)int{\nreturn x*2}", "go")
+	want := "func double(x int) int {\n\treturn x * 2\n}"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestFormatCodeKeepsInvalidAndOtherCode(t *testing.T) {
+	for _, test := range []struct{ code, language string }{
+		{"func double(x int) int {", "go"},
+		{"int  main(){}", "cpp"},
+	} {
+		if got := formatCode(test.code, test.language); got != test.code {
+			t.Errorf("expected %q to stay unchanged but got %q", test.code, got)
+		}
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	submitted := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n"
+	suggested := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn"
+	want := `--- submitted
++++ suggested
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -11,3 +11,4 @@
+ k
+ l
+ m
++n
+`
+	if got := unifiedDiff(submitted, suggested); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestUnifiedDiffMergesCloseChanges(t *testing.T) {
+	want := `--- submitted
++++ suggested
+@@ -1,4 +1,4 @@
+-a
++A
+ b
+ c
+-d
++D
+`
+	if got := unifiedDiff("a\nb\nc\nd", "A\nb\nc\nD"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestUnifiedDiffEdgeCases(t *testing.T) {
+	if got := unifiedDiff("same\r\ncode\n", "same\ncode"); got != "" {
+		t.Errorf("expected no diff for equal code but got %s", got)
+	}
+	want := "--- submitted\n+++ suggested\n@@ -0,0 +1,2 @@\n+new\n+code\n"
+	if got := unifiedDiff("", "new\ncode"); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestUnifiedDiffOfLargeChange(t *testing.T) {
+	var submitted, suggested strings.Builder
+	submitted.WriteString("func solve() {\n")
+	suggested.WriteString("func solve() {\n")
+	for i := range 2000 {
+		fmt.Fprintf(&submitted, "\told%d()\n", i)
+		fmt.Fprintf(&suggested, "\tnew%d()\n", i)
+	}
+	submitted.WriteString("}")
+	suggested.WriteString("}")
+
+	got := unifiedDiff(submitted.String(), suggested.String())
+	if !strings.HasPrefix(got, "--- submitted\n+++ suggested\n@@ -1,2002 +1,2002 @@\n func solve() {\n-\told0()\n") {
+		t.Errorf("expected changed block to be removed and added as a whole but got\n%.200s", got)
+	}
+	if !strings.HasSuffix(got, "+\tnew1999()\n }\n") {
+		t.Errorf("expected unchanged end as context but got\n%s", got[len(got)-100:])
+	}
+}
+
+func TestQueryAgentFormatsCodeAndReturnsDiff(t *testing.T) {
+	agent := &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			return "```go\nfunc double(x int)int{\nreturn x*2}\n```", nil
+		},
+	}
+	queryHandler := NewQueryHandler(newTestRegistry(&mockAgent{}, agent))
+
+	got, err := queryHandler.QueryAgent(context.Background(), "1", Request{
+		Code:     "func double(x int) int {\n\n}",
+		Language: "go",
+		Agent:    GEMINI,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantCode := "func double(x int) int {\n\treturn x * 2\n}"
+	wantDiff := "--- submitted\n+++ suggested\n@@ -1,3 +1,3 @@\n func double(x int) int {\n-\n+\treturn x * 2\n }\n"
+	if got.Response != wantCode || got.Diff != wantDiff {
+		t.Errorf("got code %q and diff %q, want %q and %q", got.Response, got.Diff, wantCode, wantDiff)
+	}
+}
@@ -0,0 +1,276 @@
+package query
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"serious-fin/api/common"
+	"strings"
+	"time"
+)
+
+var ErrInvalidExperiment = errors.New("invalid experiment")
+
+// only one experiment is active at a time. a variant without prompt id uses the built-in prompt
+type Experiment struct {
+	Id        int                 `json:"id"`
+	Name      string              `json:"name"`
+	IsActive  bool                `json:"isActive"`
+	Variants  []ExperimentVariant `json:"variants"`
+	CreatedAt string              `json:"createdAt,omitempty"`
+}
+
+type ExperimentVariant struct {
+	Id       int    `json:"id"`
+	Name     string `json:"name"`
+	PromptId int    `json:"promptId,omitempty"`
+}
+
+type CreateExperimentRequest struct {
+	Name     string              `form:"name"`
+	Variants []ExperimentVariant `form:"variants"`
+}
+
+type ExperimentAssignment struct {
+	ExperimentId int
+	VariantId    int
+	Prompt       *PromptTemplate
+}
+
+// participants are counted per session and problem
+type VariantResult struct {
+	VariantId         int     `json:"variantId"`
+	Name              string  `json:"name"`
+	PromptId          int     `json:"promptId,omitempty"`
+	Participants      int     `json:"participants"`
+	Solved            int     `json:"solved"`
+	SolveRate         float64 `json:"solveRate"`
+	AvgQueriesToSolve float64 `json:"avgQueriesToSolve"`
+}
+
+type ExperimentProvider interface {
+	// returns nil without error when no experiment is active
+	AssignVariant(sessionId string) (*ExperimentAssignment, error)
+	RecordExperimentQuery(sessionId string, problemId int, assignment *ExperimentAssignment) error
+	RecordSolved(sessionId string, problemId int, assignment *ExperimentAssignment) error
+}
+
+type ExperimentStore struct {
+	DB common.DBInterface
+}
+
+func NewExperimentStore(db common.DBInterface) *ExperimentStore {
+	return &ExperimentStore{DB: db}
+}
+
+// new experiment is activated and every other experiment is stopped, all or nothing
+func (store *ExperimentStore) CreateExperiment(request CreateExperimentRequest) (*Experiment, error) {
+	err := checkExperiment(request)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := store.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("could not start experiment transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE experiments SET isActive = false WHERE isActive = true")
+	if err != nil {
+		return nil, fmt.Errorf("could not stop previous experiments: %w", err)
+	}
+
+	experiment := Experiment{Name: request.Name, IsActive: true, CreatedAt: time.Now().UTC().Format(time.RFC3339)}
+	row := tx.QueryRow("INSERT INTO experiments (name, isActive, createdAt) VALUES (?, true, ?) RETURNING id", experiment.Name, experiment.CreatedAt)
+	err = row.Scan(&experiment.Id)
+	if err != nil {
+		return nil, fmt.Errorf("could not insert experiment: %w", err)
+	}
+
+	for _, variant := range request.Variants {
+		err = checkVariantPrompt(tx, variant)
+		if err != nil {
+			return nil, err
+		}
+		promptId := sql.NullInt64{Int64: int64(variant.PromptId), Valid: variant.PromptId != 0}
+		row := tx.QueryRow("INSERT INTO experimentVariants (experimentId, name, promptId) VALUES (?, ?, ?) RETURNING id", experiment.Id, variant.Name, promptId)
+		err = row.Scan(&variant.Id)
+		if err != nil {
+			return nil, fmt.Errorf("could not insert experiment variant %s: %w", variant.Name, err)
+		}
+		experiment.Variants = append(experiment.Variants, variant)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("could not commit experiment: %w", err)
+	}
+	return &experiment, nil
+}
+
+// a variant pointing to a missing prompt would fail every query assigned to it
+func checkVariantPrompt(tx *sql.Tx, variant ExperimentVariant) error {
+	if variant.PromptId == 0 {
+		return nil
+	}
+	var promptId int
+	err := tx.QueryRow("SELECT id FROM promptTemplates WHERE id = ?", variant.PromptId).Scan(&promptId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("prompt %d of variant %s does not exist: %w", variant.PromptId, variant.Name, ErrInvalidExperiment)
+	}
+	if err != nil {
+		return fmt.Errorf("could not check prompt of variant %s: %w", variant.Name, err)
+	}
+	return nil
+}
+
+func (store *ExperimentStore) StopExperiment(experimentId int) error {
+	result, err := store.DB.Exec("UPDATE experiments SET isActive = false WHERE id = ?", experimentId)
+	if err != nil {
+		return fmt.Errorf("could not stop experiment (id %d): %w", experimentId, err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not check stopped experiment (id %d): %w", experimentId, err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("experiment with id %d does not exist: %w", experimentId, sql.ErrNoRows)
+	}
+	return nil
+}
+
+// session is always assigned to the same variant of an experiment.
+// variants are read from the latest active experiment only, should several ever be active
+func (store *ExperimentStore) AssignVariant(sessionId string) (*ExperimentAssignment, error) {
+	rows, err := store.DB.Query("SELECT e.id, v.id, v.promptId FROM experiments AS e JOIN experimentVariants AS v ON v.experimentId = e.id WHERE e.id = (SELECT MAX(id) FROM experiments WHERE isActive = true) ORDER BY v.id")
+	if err != nil {
+		return nil, fmt.Errorf("could not query active experiment: %w", err)
+	}
+	defer rows.Close()
+
+	type variantRow struct {
+		experimentId int
+		variantId    int
+		promptId     sql.NullInt64
+	}
+	variants := []variantRow{}
+	for rows.Next() {
+		var variant variantRow
+		err = rows.Scan(&variant.experimentId, &variant.variantId, &variant.promptId)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan experiment variant: %w", err)
+		}
+		variants = append(variants, variant)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating experiment variants: %w", err)
+	}
+	if len(variants) == 0 {
+		return nil, nil
+	}
+
+	chosen := variants[variantIndex(variants[0].experimentId, sessionId, len(variants))]
+	assignment := &ExperimentAssignment{
+		ExperimentId: chosen.experimentId,
+		VariantId:    chosen.variantId,
+		Prompt:       &defaultPrompt,
+	}
+	if chosen.promptId.Valid {
+		row := store.DB.QueryRow("SELECT "+promptColumns+" FROM promptTemplates WHERE id = ?", chosen.promptId.Int64)
+		assignment.Prompt, err = scanPromptTemplate(row)
+		if err != nil {
+			return nil, fmt.Errorf("could not get prompt of experiment variant %d: %w", chosen.variantId, err)
+		}
+	}
+	return assignment, nil
+}
+
+func (store *ExperimentStore) RecordExperimentQuery(sessionId string, problemId int, assignment *ExperimentAssignment) error {
+	_, err := store.DB.Exec(
+		"INSERT INTO experimentSessions (experimentId, variantId, sessionId, problemId, queries, solved) VALUES (?, ?, ?, ?, 1, false) ON CONFLICT (experimentId, sessionId, problemId) DO UPDATE SET queries = queries + 1",
+		assignment.ExperimentId, assignment.VariantId, sessionId, problemId,
+	)
+	if err != nil {
+		return fmt.Errorf("could not record experiment query (session id %s): %w", sessionId, err)
+	}
+	return nil
+}
+
+// number of queries made until the problem was first solved is kept, only in the experiment of the assignment
+func (store *ExperimentStore) RecordSolved(sessionId string, problemId int, assignment *ExperimentAssignment) error {
+	_, err := store.DB.Exec(
+		"UPDATE experimentSessions SET solved = true, solvedAfterQueries = queries WHERE experimentId = ? AND sessionId = ? AND problemId = ? AND solved = false",
+		assignment.ExperimentId, sessionId, problemId,
+	)
+	if err != nil {
+		return fmt.Errorf("could not record solved problem (session id %s, problem id %d): %w", sessionId, problemId, err)
+	}
+	return nil
+}
+
+func (store *ExperimentStore) GetExperimentResults(experimentId int) ([]VariantResult, error) {
+	query := `
+	SELECT
+		v.id,
+		v.name,
+		COALESCE(v.promptId, 0),
+		COUNT(s.sessionId),
+		COALESCE(SUM(CASE WHEN s.solved THEN 1 ELSE 0 END), 0),
+		COALESCE(AVG(CASE WHEN s.solved THEN s.solvedAfterQueries END), 0)
+	FROM experimentVariants AS v
+	LEFT JOIN experimentSessions AS s
+	ON s.variantId = v.id
+	WHERE v.experimentId = ?
+	GROUP BY v.id
+	ORDER BY v.id`
+	rows, err := store.DB.Query(query, experimentId)
+	if err != nil {
+		return nil, fmt.Errorf("could not query experiment results (id %d): %w", experimentId, err)
+	}
+	defer rows.Close()
+
+	results := []VariantResult{}
+	for rows.Next() {
+		var result VariantResult
+		err = rows.Scan(&result.VariantId, &result.Name, &result.PromptId, &result.Participants, &result.Solved, &result.AvgQueriesToSolve)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan experiment result: %w", err)
+		}
+		if result.Participants > 0 {
+			result.SolveRate = float64(result.Solved) / float64(result.Participants)
+		}
+		results = append(results, result)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating experiment results: %w", err)
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("experiment with id %d does not exist: %w", experimentId, sql.ErrNoRows)
+	}
+	return results, nil
+}
+
+func checkExperiment(request CreateExperimentRequest) error {
+	if strings.TrimSpace(request.Name) == "" {
+		return fmt.Errorf("experiment name can not be empty: %w", ErrInvalidExperiment)
+	}
+	if len(request.Variants) < 2 {
+		return fmt.Errorf("experiment needs at least two variants: %w", ErrInvalidExperiment)
+	}
+	names := make(map[string]bool)
+	for _, variant := range request.Variants {
+		if strings.TrimSpace(variant.Name) == "" || names[variant.Name] {
+			return fmt.Errorf("variant names have to be unique and not empty: %w", ErrInvalidExperiment)
+		}
+		names[variant.Name] = true
+	}
+	return nil
+}
+
+func variantIndex(experimentId int, sessionId string, variantCount int) int {
+	hash := fnv.New32a()
+	fmt.Fprintf(hash, "%d\x00%s", experimentId, sessionId)
+	return int(hash.Sum32() % uint32(variantCount))
+}
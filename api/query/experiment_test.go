@@ -0,0 +1,298 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"serious-fin/api/validator"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+type mockExperiments struct {
+	AssignVariantFunc         func(sessionId string) (*ExperimentAssignment, error)
+	RecordExperimentQueryFunc func(sessionId string, problemId int, assignment *ExperimentAssignment) error
+	RecordSolvedFunc          func(sessionId string, problemId int, assignment *ExperimentAssignment) error
+}
+
+func (mockExperiments *mockExperiments) AssignVariant(sessionId string) (*ExperimentAssignment, error) {
+	if mockExperiments.AssignVariantFunc != nil {
+		return mockExperiments.AssignVariantFunc(sessionId)
+	}
+	return nil, nil
+}
+
+func (mockExperiments *mockExperiments) RecordExperimentQuery(sessionId string, problemId int, assignment *ExperimentAssignment) error {
+	if mockExperiments.RecordExperimentQueryFunc != nil {
+		return mockExperiments.RecordExperimentQueryFunc(sessionId, problemId, assignment)
+	}
+	return nil
+}
+
+func (mockExperiments *mockExperiments) RecordSolved(sessionId string, problemId int, assignment *ExperimentAssignment) error {
+	if mockExperiments.RecordSolvedFunc != nil {
+		return mockExperiments.RecordSolvedFunc(sessionId, problemId, assignment)
+	}
+	return nil
+}
+
+func TestVariantIndexIsDeterministic(t *testing.T) {
+	first := variantIndex(1, "session", 3)
+	for range 10 {
+		if got := variantIndex(1, "session", 3); got != first {
+			t.Fatalf("expected same variant for same session but got %d and %d", first, got)
+		}
+	}
+
+	seen := make(map[int]bool)
+	for _, sessionId := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"} {
+		seen[variantIndex(1, sessionId, 2)] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("expected sessions to be spread over both variants but got %v", seen)
+	}
+}
+
+func TestAssignVariantWithoutActiveExperiment(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT e.id, v.id, v.promptId FROM experiments").WillReturnRows(sqlmock.NewRows([]string{"e.id", "v.id", "v.promptId"}))
+
+	got, err := NewExperimentStore(db).AssignVariant("session")
+	if err != nil || got != nil {
+		t.Errorf("expected no assignment and no error but got %v, %v", got, err)
+	}
+}
+
+func TestAssignVariantLoadsVariantPrompt(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	sessionId := "session"
+	variants := sqlmock.NewRows([]string{"e.id", "v.id", "v.promptId"}).AddRow(1, 10, nil).AddRow(1, 11, 5)
+	mock.ExpectQuery("SELECT e.id, v.id, v.promptId FROM experiments").WillReturnRows(variants)
+	wantVariant := []int{10, 11}[variantIndex(1, sessionId, 2)]
+	if wantVariant == 11 {
+		mock.ExpectQuery("SELECT .* FROM promptTemplates WHERE id = ?").WithArgs(5).WillReturnRows(
+			sqlmock.NewRows(promptRowColumns).AddRow(5, 1, "system", "{{.Code}}", nil, nil, false, "2026-01-01T00:00:00Z"),
+		)
+	}
+
+	got, err := NewExperimentStore(db).AssignVariant(sessionId)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.ExperimentId != 1 || got.VariantId != wantVariant {
+		t.Errorf("unexpected assignment %+v", got)
+	}
+	if wantVariant == 10 && got.Prompt != &defaultPrompt {
+		t.Errorf("expected variant without prompt to use built-in prompt")
+	}
+	if wantVariant == 11 && got.Prompt.Id != 5 {
+		t.Errorf("expected variant prompt but got %+v", got.Prompt)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateExperimentRejectsInvalidRequests(t *testing.T) {
+	store := NewExperimentStore(nil)
+	requests := []CreateExperimentRequest{
+		{Name: "", Variants: []ExperimentVariant{{Name: "a"}, {Name: "b"}}},
+		{Name: "prompts", Variants: []ExperimentVariant{{Name: "a"}}},
+		{Name: "prompts", Variants: []ExperimentVariant{{Name: "a"}, {Name: "a"}}},
+	}
+	for _, request := range requests {
+		if _, err := store.CreateExperiment(request); !errors.Is(err, ErrInvalidExperiment) {
+			t.Errorf("expected invalid experiment error for %+v but got %v", request, err)
+		}
+	}
+}
+
+func TestCreateExperimentStopsOthers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE experiments SET isActive = false WHERE isActive = true").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO experiments").WithArgs("prompts", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectQuery("INSERT INTO experimentVariants").WithArgs(2, "control", sql.NullInt64{}).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(20))
+	mock.ExpectQuery("SELECT id FROM promptTemplates WHERE id = ?").WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectQuery("INSERT INTO experimentVariants").WithArgs(2, "new", sql.NullInt64{Int64: 5, Valid: true}).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(21))
+	mock.ExpectCommit()
+
+	got, err := NewExperimentStore(db).CreateExperiment(CreateExperimentRequest{
+		Name:     "prompts",
+		Variants: []ExperimentVariant{{Name: "control"}, {Name: "new", PromptId: 5}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Id != 2 || !got.IsActive || len(got.Variants) != 2 || got.Variants[1].Id != 21 {
+		t.Errorf("unexpected experiment %+v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreateExperimentWithMissingPromptRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE experiments SET isActive = false WHERE isActive = true").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO experiments").WithArgs("prompts", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectQuery("SELECT id FROM promptTemplates WHERE id = ?").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectRollback()
+
+	_, err = NewExperimentStore(db).CreateExperiment(CreateExperimentRequest{
+		Name:     "prompts",
+		Variants: []ExperimentVariant{{Name: "typo", PromptId: 7}, {Name: "control"}},
+	})
+	if !errors.Is(err, ErrInvalidExperiment) {
+		t.Errorf("expected invalid experiment error but got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetExperimentResults(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT .* FROM experimentVariants AS v LEFT JOIN experimentSessions").WithArgs(2).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "name", "promptId", "participants", "solved", "avgQueries"}).
+			AddRow(20, "control", 0, 4, 1, 3.0).
+			AddRow(21, "new", 5, 0, 0, 0.0),
+	)
+
+	got, err := NewExperimentStore(db).GetExperimentResults(2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 2 || got[0].SolveRate != 0.25 || got[0].AvgQueriesToSolve != 3 || got[1].SolveRate != 0 {
+		t.Errorf("unexpected results %+v", got)
+	}
+}
+
+func TestGetResultsOfMissingExperiment(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT .* FROM experimentVariants").WillReturnRows(sqlmock.NewRows([]string{"id", "name", "promptId", "participants", "solved", "avgQueries"}))
+
+	if _, err := NewExperimentStore(db).GetExperimentResults(2); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected no rows error but got %v", err)
+	}
+}
+
+func TestQueryUsesExperimentVariantAndRecordsQuery(t *testing.T) {
+	variantPrompt := &PromptTemplate{Id: 5, SystemPrompt: "variant system", UserTemplate: "{{.Code}}"}
+	var gotSystemPrompt string
+	agent := &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			gotSystemPrompt = systemPrompt
+			return "code", nil
+		},
+	}
+	var recorded *ExperimentAssignment
+	experiments := &mockExperiments{
+		AssignVariantFunc: func(sessionId string) (*ExperimentAssignment, error) {
+			return &ExperimentAssignment{ExperimentId: 1, VariantId: 11, Prompt: variantPrompt}, nil
+		},
+		RecordExperimentQueryFunc: func(sessionId string, problemId int, assignment *ExperimentAssignment) error {
+			recorded = assignment
+			return nil
+		},
+	}
+	prompts := &mockPromptProvider{
+		GetActivePromptFunc: func(problemId int, language string) (*PromptTemplate, error) {
+			t.Error("expected active prompt not to be used for sessions in experiment")
+			return nil, nil
+		},
+	}
+
+	handler := NewQueryHandlerWithExperiments(newTestRegistry(agent, &mockAgent{}), prompts, experiments)
+	if _, err := handler.QueryAgent(context.Background(), "1", Request{Agent: CHATGPT, ProblemId: 3}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if gotSystemPrompt != "variant system" || recorded == nil || recorded.VariantId != 11 {
+		t.Errorf("expected variant prompt to be used and recorded but got %q, %+v", gotSystemPrompt, recorded)
+	}
+}
+
+func TestRecordOutcomeOnlyForSolvedValidation(t *testing.T) {
+	solved := []string{}
+	handler := NewQueryHandlerWithExperiments(newTestRegistry(&mockAgent{}, &mockAgent{}), nil, &mockExperiments{
+		AssignVariantFunc: func(sessionId string) (*ExperimentAssignment, error) {
+			return &ExperimentAssignment{ExperimentId: 2, VariantId: 11}, nil
+		},
+		RecordSolvedFunc: func(sessionId string, problemId int, assignment *ExperimentAssignment) error {
+			solved = append(solved, sessionId)
+			return nil
+		},
+	})
+
+	handler.RecordOutcome("failed", 1, &validator.Response{SucceededTests: []int{1}, FailedTests: []validator.FailInfo{{Id: 2}}})
+	handler.RecordOutcome("", 1, &validator.Response{SucceededTests: []int{1}})
+	handler.RecordOutcome("passed", 1, &validator.Response{SucceededTests: []int{1}})
+
+	if len(solved) != 1 || solved[0] != "passed" {
+		t.Errorf("expected only passing validation with session to be recorded but got %v", solved)
+	}
+}
+
+func TestRecordOutcomeWithoutActiveExperiment(t *testing.T) {
+	handler := NewQueryHandlerWithExperiments(newTestRegistry(&mockAgent{}, &mockAgent{}), nil, &mockExperiments{
+		RecordSolvedFunc: func(sessionId string, problemId int, assignment *ExperimentAssignment) error {
+			t.Error("expected nothing to be recorded without an active experiment")
+			return nil
+		},
+	})
+
+	if err := handler.RecordOutcome("passed", 1, &validator.Response{SucceededTests: []int{1}}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRecordSolvedOnlyInExperimentOfAssignment(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	store := NewExperimentStore(db)
+
+	mock.ExpectExec("UPDATE experimentSessions SET solved = true, solvedAfterQueries = queries WHERE experimentId = \\? AND sessionId = \\? AND problemId = \\?").
+		WithArgs(2, "session", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := store.RecordSolved("session", 1, &ExperimentAssignment{ExperimentId: 2, VariantId: 11}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,126 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var ErrNoCodeInResponse = errors.New("agent response does not contain code")
+
+// fence may be left unclosed when the agent ran out of tokens, the block then runs until the end of the output
+var (
+	fencedCodeRegex = regexp.MustCompile("(?s)```[ \\t]*([\\w+#.-]*)[ \\t]*\\n?(.*?)(?:```|\\z)")
+	xmlCodeRegex    = regexp.MustCompile(`(?s)<code>(.*?)</code>`)
+)
+
+// fence tags used by agents for each language the validator supports
+var languageAliases = map[string][]string{
+	"go":  {"go", "golang"},
+	"cpp": {"cpp", "c++", "cc", "cxx", "hpp", "h"},
+}
+
+func isGoLanguage(language string) bool {
+	return slices.Contains(languageAliases["go"], strings.ToLower(language))
+}
+
+type codeBlock struct {
+	Language string
+	Code     string
+}
+
+// agents are asked for plain code, but often wrap it in markdown or xml blocks and add prose around them.
+// blocks in the requested language are preferred over untagged ones, blocks in other languages are ignored.
+// several blocks are merged in order since agents tend to split helper functions from the solution
+func extractCode(aiOutput, language string) (string, error) {
+	blocks, found := findCodeBlocks(aiOutput)
+	if !found {
+		return rawCode(aiOutput, language)
+	}
+
+	blocks = selectCodeBlocks(blocks, language)
+	if len(blocks) == 0 {
+		return "", fmt.Errorf("no code block in %s: %w", language, ErrNoCodeInResponse)
+	}
+	return mergeCodeBlocks(blocks), nil
+}
+
+// output without blocks is taken as plain code. go answers have to parse, so prose is not returned as code.
+// other languages can not be checked and are taken as they are
+func rawCode(aiOutput, language string) (string, error) {
+	code := strings.TrimSpace(aiOutput)
+	if code == "" {
+		return "", ErrNoCodeInResponse
+	}
+	if isGoLanguage(language) {
+		if _, err := parseGoCode(code); err != nil {
+			return "", fmt.Errorf("output without code blocks is not valid go: %w", ErrNoCodeInResponse)
+		}
+	}
+	return code, nil
+}
+
+// returns whether the output has any block at all, empty blocks are skipped. xml blocks have no language
+func findCodeBlocks(aiOutput string) ([]codeBlock, bool) {
+	blocks := []codeBlock{}
+	fenced := fencedCodeRegex.FindAllStringSubmatch(aiOutput, -1)
+	for _, match := range fenced {
+		blocks = appendCodeBlock(blocks, strings.ToLower(match[1]), match[2])
+	}
+	if len(fenced) > 0 {
+		return blocks, true
+	}
+	xml := xmlCodeRegex.FindAllStringSubmatch(aiOutput, -1)
+	for _, match := range xml {
+		blocks = appendCodeBlock(blocks, "", match[1])
+	}
+	return blocks, len(xml) > 0
+}
+
+func appendCodeBlock(blocks []codeBlock, language, code string) []codeBlock {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return blocks
+	}
+	return append(blocks, codeBlock{Language: language, Code: code})
+}
+
+// every block is kept when the language is not known, since its tags can not be told apart
+func selectCodeBlocks(blocks []codeBlock, language string) []codeBlock {
+	aliases, known := languageAliases[strings.ToLower(language)]
+	if !known {
+		return blocks
+	}
+
+	matching := []codeBlock{}
+	untagged := []codeBlock{}
+	for _, block := range blocks {
+		if slices.Contains(aliases, block.Language) {
+			matching = append(matching, block)
+		}
+		if block.Language == "" {
+			untagged = append(untagged, block)
+		}
+	}
+	if len(matching) > 0 {
+		return matching
+	}
+	return untagged
+}
+
+// a block repeated inside another one (e.g. a helper shown on its own and then in the full solution) is dropped
+func mergeCodeBlocks(blocks []codeBlock) string {
+	codes := []string{}
+	for _, block := range blocks {
+		duplicate := slices.ContainsFunc(blocks, func(other codeBlock) bool {
+			return other.Code != block.Code && strings.Contains(other.Code, block.Code)
+		})
+		if duplicate || slices.Contains(codes, block.Code) {
+			continue
+		}
+		codes = append(codes, block.Code)
+	}
+	return strings.Join(codes, "\n\n")
+}
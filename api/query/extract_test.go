@@ -0,0 +1,47 @@
+package query
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExtractCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		language string
+		want     string
+	}{
+		{"plain code", "\nfunc main() {}\n", "go", "func main() {}"},
+		{"prose around fence", "Here is the fix:\n```go\nfunc main() {}\n```\nIt runs in O(n).", "go", "func main() {}"},
+		{"golang tag", "```golang\nfunc main() {}\n```", "go", "func main() {}"},
+		{"untagged fence", "```\nint main() {}\n```", "cpp", "int main() {}"},
+		{"xml block", "Solution:\n<code>\nfunc main() {}\n</code>", "go", "func main() {}"},
+		{"unclosed fence", "```go\nfunc main() {", "go", "func main() {"},
+		{"other language ignored", "```python\nprint(1)\n```\n```c++\nint main() {}\n```", "cpp", "int main() {}"},
+		{"tagged preferred over untagged", "```\n$ go run .\n```\n```go\nfunc main() {}\n```", "go", "func main() {}"},
+		{"blocks merged", "```go\nfunc helper() {}\n```\nand\n```go\nfunc main() {}\n```", "go", "func helper() {}\n\nfunc main() {}"},
+		{"repeated block dropped", "```go\nfunc helper() {}\n```\n```go\nfunc helper() {}\n\nfunc main() {}\n```", "go", "func helper() {}\n\nfunc main() {}"},
+		{"unknown language keeps every block", "```ruby\nputs 1\n```", "lang", "puts 1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := extractCode(test.output, test.language)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestExtractCodeReportsMissingCode(t *testing.T) {
+	for _, output := range []string{"", " \n\t", "```go\n```", "```python\nprint(1)\n```", "I can not solve this problem."} {
+		if _, err := extractCode(output, "go"); !errors.Is(err, ErrNoCodeInResponse) {
+			t.Errorf("expected missing code error for %q but got %v", output, err)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// deterministic agent for development without API keys. scripted responses are returned in order for every
+// session (the last one is repeated once they run out), a placeholder answer when none are given.
+// an echo agent returns the code from the user query unchanged instead
+type FakeAgent struct {
+	mu        sync.Mutex
+	Responses []string
+	Echo      bool
+	Cache     CacheInterface
+	calls     map[string]int
+}
+
+// sessions whose position in the responses is remembered. once full it is cleared,
+// forgotten sessions start again from the first response
+const maxFakeSessions = 1000
+
+// model of the fake provider which echoes the code. it is found only in prompts which send it in <code> tags,
+// like the built-in one
+const FakeModelEcho = "echo"
+
+const defaultFakeResponse = "Scripted answer of a fake agent, configure a real one to get answers.\n\n```go\nfunc solve() {}\n```"
+
+var codeFromQueryRegex = regexp.MustCompile(`(?s)<code>\n(.*)\n</code>`)
+
+func NewFakeAgent(responses []string, cache CacheInterface) *FakeAgent {
+	if len(responses) == 0 {
+		responses = []string{defaultFakeResponse}
+	}
+	return &FakeAgent{
+		Responses: responses,
+		Cache:     cache,
+		calls:     make(map[string]int),
+	}
+}
+
+func NewEchoFakeAgent(cache CacheInterface) *FakeAgent {
+	return &FakeAgent{
+		Echo:  true,
+		Cache: cache,
+		calls: make(map[string]int),
+	}
+}
+
+func (agent *FakeAgent) QueryWithContext(ctx context.Context, sessionId, userQuery, systemPrompt string) (string, error) {
+	output, err := agent.nextResponse(sessionId, userQuery)
+	if err != nil {
+		return "", err
+	}
+	agent.Cache.Add(sessionId, userQuery, output)
+	return output, nil
+}
+
+// response is split into words to imitate the way real agents stream
+func (agent *FakeAgent) StreamWithContext(ctx context.Context, sessionId, userQuery, systemPrompt string, onDelta func(string)) (string, error) {
+	output, err := agent.nextResponse(sessionId, userQuery)
+	if err != nil {
+		return "", err
+	}
+	for _, word := range strings.SplitAfter(output, " ") {
+		if word != "" {
+			onDelta(word)
+		}
+	}
+	agent.Cache.Add(sessionId, userQuery, output)
+	return output, nil
+}
+
+func (agent *FakeAgent) nextResponse(sessionId, userQuery string) (string, error) {
+	if agent.Echo {
+		matches := codeFromQueryRegex.FindStringSubmatch(userQuery)
+		if len(matches) != 2 {
+			return "", fmt.Errorf("echo agent found no code in <code> tags of the query")
+		}
+		return matches[1], nil
+	}
+
+	agent.mu.Lock()
+	defer agent.mu.Unlock()
+
+	calls, exists := agent.calls[sessionId]
+	if !exists && len(agent.calls) >= maxFakeSessions {
+		clear(agent.calls)
+	}
+	index := min(calls, len(agent.Responses)-1)
+	// count stops at the last response, which is repeated from then on
+	agent.calls[sessionId] = min(calls+1, len(agent.Responses)-1)
+	return agent.Responses[index], nil
+}
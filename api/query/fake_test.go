@@ -0,0 +1,110 @@
+package query
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFakeAgentReturnsScriptedResponsesInOrder(t *testing.T) {
+	agent := NewFakeAgent([]string{"first", "second"}, &mockCache{})
+
+	got := []string{}
+	for range 3 {
+		output, err := agent.QueryWithContext(context.Background(), "1", "query", systemPrompt)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got = append(got, output)
+	}
+
+	want := "first,second,second"
+	if strings.Join(got, ",") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+}
+
+func TestFakeAgentScriptIsPerSession(t *testing.T) {
+	agent := NewFakeAgent([]string{"first", "second"}, &mockCache{})
+
+	agent.QueryWithContext(context.Background(), "1", "query", systemPrompt)
+	got, _ := agent.QueryWithContext(context.Background(), "2", "query", systemPrompt)
+	if got != "first" {
+		t.Errorf("got %s, want first", got)
+	}
+}
+
+func TestFakeAgentForgetsSessionsOnceFull(t *testing.T) {
+	agent := NewFakeAgent([]string{"first", "second"}, &mockCache{})
+
+	for session := range maxFakeSessions + 1 {
+		agent.QueryWithContext(context.Background(), strconv.Itoa(session), "query", systemPrompt)
+	}
+	if len(agent.calls) != 1 {
+		t.Errorf("expected remembered sessions to be cleared but got %d", len(agent.calls))
+	}
+	got, _ := agent.QueryWithContext(context.Background(), "0", "query", systemPrompt)
+	if got != "first" {
+		t.Errorf("got %s, want first", got)
+	}
+}
+
+func TestFakeAgentAnswersWithoutScript(t *testing.T) {
+	agent := NewFakeAgent(nil, &mockCache{})
+	userQuery := defaultUserQuery("make it faster", "go", "func main() {\n}")
+
+	got, err := agent.QueryWithContext(context.Background(), "1", userQuery, systemPrompt)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != defaultFakeResponse {
+		t.Errorf("got %s, want default response", got)
+	}
+}
+
+func TestEchoFakeAgentReturnsSubmittedCode(t *testing.T) {
+	agent := NewEchoFakeAgent(&mockCache{})
+	userQuery := defaultUserQuery("make it faster", "go", "func main() {\n}")
+
+	got, err := agent.QueryWithContext(context.Background(), "1", userQuery, systemPrompt)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "func main() {\n}" {
+		t.Errorf("got %s, want submitted code", got)
+	}
+
+	if _, err := agent.QueryWithContext(context.Background(), "1", "code without tags", systemPrompt); err == nil {
+		t.Error("expected error for query without code in tags")
+	}
+}
+
+func TestFakeAgentStreamsWords(t *testing.T) {
+	agent := NewFakeAgent([]string{"foo bar baz"}, &mockCache{})
+
+	deltas := []string{}
+	got, err := agent.StreamWithContext(context.Background(), "1", "query", systemPrompt, func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "foo bar baz" || strings.Join(deltas, "") != got || len(deltas) != 3 {
+		t.Errorf("got %s with deltas %v", got, deltas)
+	}
+}
+
+func TestFakeAgentCachesTurns(t *testing.T) {
+	added := []string{}
+	agent := NewFakeAgent([]string{"answer"}, &mockCache{
+		AddFunc: func(sessionId, userInput, aiOutput string) {
+			added = append(added, userInput, aiOutput)
+		},
+	})
+
+	agent.QueryWithContext(context.Background(), "1", "query", systemPrompt)
+	if strings.Join(added, ",") != "query,answer" {
+		t.Errorf("expected turn to be cached but got %v", added)
+	}
+}
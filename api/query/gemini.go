@@ -3,69 +3,88 @@ package query
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	gemini "google.golang.org/genai"
 )
 
-type GeminiAgentWrapperInterface interface {
-	QueryWithContext(sessionId, userQuery, systemPrompt string) (string, error)
-}
-
 type GeminiAgentWrapper struct {
 	Agent GeminiInterface
 	Cache CacheInterface
 }
 
 type GeminiInterface interface {
-	Query(config *gemini.GenerateContentConfig, history []*gemini.Content, userQuery string) (string, error)
+	Query(ctx context.Context, config *gemini.GenerateContentConfig, history []*gemini.Content, userQuery string) (string, error)
+	Stream(ctx context.Context, config *gemini.GenerateContentConfig, history []*gemini.Content, userQuery string, onDelta func(string)) (string, error)
 }
 
 type Gemini struct {
 	Client *gemini.Client
 	Model  string
-	Ctx    context.Context
 }
 
-func NewGeminiAgentWrapper(client *gemini.Client, model string, cache CacheInterface, ctx context.Context) *GeminiAgentWrapper {
+func NewGeminiAgentWrapper(client *gemini.Client, model string, cache CacheInterface) *GeminiAgentWrapper {
 	return &GeminiAgentWrapper{
 		Agent: &Gemini{
 			Client: client,
 			Model:  model,
-			Ctx:    ctx,
 		},
 		Cache: cache,
 	}
 }
 
-func (wrapper *GeminiAgentWrapper) QueryWithContext(sessionId, userQuery, systemPrompt string) (string, error) {
+func (wrapper *GeminiAgentWrapper) QueryWithContext(ctx context.Context, sessionId, userQuery, systemPrompt string) (string, error) {
+	config, history, err := wrapper.buildRequest(sessionId, userQuery, systemPrompt)
+	if err != nil {
+		return "", err
+	}
+
+	output, err := wrapper.Agent.Query(ctx, config, history, userQuery)
+	if err != nil {
+		return "", fmt.Errorf("could not query gemini agent: %w", err)
+	}
+	wrapper.Cache.Add(sessionId, userQuery, output)
+	return output, nil
+}
+
+// onDelta is called with every received piece of the response, full response is cached once the stream ends
+func (wrapper *GeminiAgentWrapper) StreamWithContext(ctx context.Context, sessionId, userQuery, systemPrompt string, onDelta func(string)) (string, error) {
+	config, history, err := wrapper.buildRequest(sessionId, userQuery, systemPrompt)
+	if err != nil {
+		return "", err
+	}
+
+	output, err := wrapper.Agent.Stream(ctx, config, history, userQuery, onDelta)
+	if err != nil {
+		return "", fmt.Errorf("could not stream gemini agent: %w", err)
+	}
+	wrapper.Cache.Add(sessionId, userQuery, output)
+	return output, nil
+}
+
+func (wrapper *GeminiAgentWrapper) buildRequest(sessionId, userQuery, systemPrompt string) (*gemini.GenerateContentConfig, []*gemini.Content, error) {
 	config := &gemini.GenerateContentConfig{
 		SystemInstruction: gemini.NewContentFromText(systemPrompt, gemini.RoleUser),
 	}
 
-	previousContext := wrapper.Cache.Get(sessionId)
+	previousContext := previousContextWithinBudget(wrapper.Cache, sessionId, userQuery, systemPrompt)
 	history := make([]*gemini.Content, 0)
 	for _, context := range previousContext {
 		role, err := getGeminiRole(context.Role)
 		if err != nil {
-			return "", fmt.Errorf("error building previous context for gemini request: %w", err)
+			return nil, nil, fmt.Errorf("error building previous context for gemini request: %w", err)
 		}
 		history = append(history, gemini.NewContentFromText(context.Content, role))
 	}
-
-	output, err := wrapper.Agent.Query(config, history, userQuery)
-	if err != nil {
-		return "", fmt.Errorf("could not query gemini agent: %w", err)
-	}
-	wrapper.Cache.Add(sessionId, userQuery, output)
-	return output, nil
+	return config, history, nil
 }
 
-func (agent *Gemini) Query(config *gemini.GenerateContentConfig, history []*gemini.Content, userQuery string) (string, error) {
-	chat, err := agent.Client.Chats.Create(agent.Ctx, agent.Model, config, history)
+func (agent *Gemini) Query(ctx context.Context, config *gemini.GenerateContentConfig, history []*gemini.Content, userQuery string) (string, error) {
+	chat, err := agent.Client.Chats.Create(ctx, agent.Model, config, history)
 	if err != nil {
 		return "", fmt.Errorf("failed to initialize new gemini chat session: %w", err)
 	}
-	res, err := chat.SendMessage(agent.Ctx, gemini.Part{Text: userQuery})
+	res, err := chat.SendMessage(ctx, gemini.Part{Text: userQuery})
 	if err != nil {
 		return "", fmt.Errorf("failed to send new message to gemini: %w", err)
 	}
@@ -76,6 +95,31 @@ func (agent *Gemini) Query(config *gemini.GenerateContentConfig, history []*gemi
 	return res.Candidates[0].Content.Parts[0].Text, nil
 }
 
+func (agent *Gemini) Stream(ctx context.Context, config *gemini.GenerateContentConfig, history []*gemini.Content, userQuery string, onDelta func(string)) (string, error) {
+	chat, err := agent.Client.Chats.Create(ctx, agent.Model, config, history)
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize new gemini chat session: %w", err)
+	}
+
+	var output strings.Builder
+	for res, err := range chat.SendMessageStream(ctx, gemini.Part{Text: userQuery}) {
+		if err != nil {
+			return "", fmt.Errorf("failed to receive gemini stream response: %w", err)
+		}
+		delta := res.Text()
+		if delta == "" {
+			continue
+		}
+		output.WriteString(delta)
+		onDelta(delta)
+	}
+
+	if output.Len() == 0 {
+		return "", fmt.Errorf("no response was received from gemini stream")
+	}
+	return output.String(), nil
+}
+
 func getGeminiRole(role string) (gemini.Role, error) {
 	switch role {
 	case RoleUser:
@@ -1,6 +1,7 @@
 package query
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"testing"
@@ -9,24 +10,21 @@ import (
 	gemini "google.golang.org/genai"
 )
 
-type mockGeminiAgentWrapper struct {
-	QueryWithContextFunc func(sessionId, userQuery, systemPrompt string) (string, error)
+type mockGemini struct {
+	QueryFunc  func(config *gemini.GenerateContentConfig, history []*gemini.Content, userQuery string) (string, error)
+	StreamFunc func(config *gemini.GenerateContentConfig, history []*gemini.Content, userQuery string, onDelta func(string)) (string, error)
 }
 
-func (mockWrapper *mockGeminiAgentWrapper) QueryWithContext(sessionId, userQuery, systemPrompt string) (string, error) {
-	if mockWrapper.QueryWithContextFunc != nil {
-		return mockWrapper.QueryWithContextFunc(sessionId, userQuery, systemPrompt)
+func (mockGemini *mockGemini) Query(ctx context.Context, config *gemini.GenerateContentConfig, history []*gemini.Content, userQuery string) (string, error) {
+	if mockGemini.QueryFunc != nil {
+		return mockGemini.QueryFunc(config, history, userQuery)
 	}
 	return "", nil
 }
 
-type mockGemini struct {
-	QueryFunc func(config *gemini.GenerateContentConfig, history []*gemini.Content, userQuery string) (string, error)
-}
-
-func (mockGemini *mockGemini) Query(config *gemini.GenerateContentConfig, history []*gemini.Content, userQuery string) (string, error) {
-	if mockGemini.QueryFunc != nil {
-		return mockGemini.QueryFunc(config, history, userQuery)
+func (mockGemini *mockGemini) Stream(ctx context.Context, config *gemini.GenerateContentConfig, history []*gemini.Content, userQuery string, onDelta func(string)) (string, error) {
+	if mockGemini.StreamFunc != nil {
+		return mockGemini.StreamFunc(config, history, userQuery, onDelta)
 	}
 	return "", nil
 }
@@ -41,20 +39,18 @@ func TestGeminiShouldAddSystemPromptToQuery(t *testing.T) {
 		Cache: &mockCache{},
 	}
 
-	queryHandler := NewQueryHandler(AIAgents{
-		Chatgpt: &mockChatgptAgentWrapper{},
-		Gemini:  geminiAgentWrapper,
-	})
+	queryHandler := NewQueryHandler(newTestRegistry(&mockAgent{}, geminiAgentWrapper))
 
-	got, err := queryHandler.QueryAgent("1", Request{
+	response, err := queryHandler.QueryAgent(context.Background(), "1", Request{
 		Input:    "input",
 		Code:     "code",
 		Language: "lang",
 		Agent:    GEMINI,
 	})
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Fatalf("unexpected error: %v", err)
 	}
+	got := response.Response
 	if got != fmt.Sprintf("%s||%s", systemPrompt, string(gemini.RoleUser)) {
 		t.Errorf("got %s, want %s", got, systemPrompt)
 	}
@@ -98,20 +94,18 @@ func TestGeminiShouldAddHistory(t *testing.T) {
 		},
 	}
 
-	queryHandler := NewQueryHandler(AIAgents{
-		Chatgpt: &mockChatgptAgentWrapper{},
-		Gemini:  geminiAgentWrapper,
-	})
+	queryHandler := NewQueryHandler(newTestRegistry(&mockAgent{}, geminiAgentWrapper))
 
-	got, err := queryHandler.QueryAgent("1", Request{
+	response, err := queryHandler.QueryAgent(context.Background(), "1", Request{
 		Input:    "input",
 		Code:     "code",
 		Language: "lang",
 		Agent:    GEMINI,
 	})
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Fatalf("unexpected error: %v", err)
 	}
+	got := response.Response
 	if got != contextToString(history) {
 		t.Errorf("got %s, want %s", got, contextToString(history))
 	}
@@ -121,29 +115,28 @@ func TestGeminiShouldAddUserQuery(t *testing.T) {
 	wantInput := "foo bar baz"
 	wantCode := "func int main"
 	wantLanguage := "golang"
-	want := fmt.Sprintf(userPromptTemplate, wantInput, wantLanguage, wantCode)
+	want := defaultUserQuery(wantInput, wantLanguage, wantCode)
+	var got string
 	geminiAgentWrapper := &GeminiAgentWrapper{
 		Agent: &mockGemini{
 			QueryFunc: func(config *gemini.GenerateContentConfig, history []*gemini.Content, userQuery string) (string, error) {
-				return userQuery, nil
+				got = userQuery
+				return "func answer() {}", nil
 			},
 		},
 		Cache: &mockCache{},
 	}
 
-	queryHandler := NewQueryHandler(AIAgents{
-		Chatgpt: &mockChatgptAgentWrapper{},
-		Gemini:  geminiAgentWrapper,
-	})
+	queryHandler := NewQueryHandler(newTestRegistry(&mockAgent{}, geminiAgentWrapper))
 
-	got, err := queryHandler.QueryAgent("1", Request{
+	_, err := queryHandler.QueryAgent(context.Background(), "1", Request{
 		Input:    wantInput,
 		Code:     wantCode,
 		Language: wantLanguage,
 		Agent:    GEMINI,
 	})
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Fatalf("unexpected error: %v", err)
 	}
 	if got != want {
 		t.Errorf("got %s, want %s", got, want)
@@ -151,7 +144,7 @@ func TestGeminiShouldAddUserQuery(t *testing.T) {
 }
 
 func TestGeminiHistoryShouldSavePreviousRequestsConversation(t *testing.T) {
-	inputs := []string{fmt.Sprintf(userPromptTemplate, "input1", "lang1", "code1"), fmt.Sprintf(userPromptTemplate, "input2", "lang2", "code2")}
+	inputs := []string{defaultUserQuery("input1", "lang1", "code1"), defaultUserQuery("input2", "lang2", "code2")}
 	outputs := []string{"agent response 1", "agent response 2"}
 	requestResponseIndex := 0
 	sessionId := "1"
@@ -165,13 +158,10 @@ func TestGeminiHistoryShouldSavePreviousRequestsConversation(t *testing.T) {
 		Cache: cache,
 	}
 
-	queryHandler := NewQueryHandler(AIAgents{
-		Chatgpt: &mockChatgptAgentWrapper{},
-		Gemini:  geminiAgentWrapper,
-	})
+	queryHandler := NewQueryHandler(newTestRegistry(&mockAgent{}, geminiAgentWrapper))
 
 	// send first message. request/response should be cached
-	_, err := queryHandler.QueryAgent(sessionId, Request{
+	_, err := queryHandler.QueryAgent(context.Background(), sessionId, Request{
 		Input:    "input1",
 		Code:     "code1",
 		Language: "lang1",
@@ -183,7 +173,7 @@ func TestGeminiHistoryShouldSavePreviousRequestsConversation(t *testing.T) {
 
 	// send second message. request/response should be cached
 	requestResponseIndex++
-	_, err = queryHandler.QueryAgent(sessionId, Request{
+	_, err = queryHandler.QueryAgent(context.Background(), sessionId, Request{
 		Input:    "input2",
 		Code:     "code2",
 		Language: "lang2",
@@ -232,3 +222,41 @@ func contextToString(context []Context) string {
 	}
 	return strings.Join(extractedStrings, "||")
 }
+
+func TestGeminiStreamShouldSendDeltasAndCacheFullResponse(t *testing.T) {
+	sessionId := "1"
+	cache, _ := NewContextCache(5, time.Minute, 2*time.Minute)
+	geminiAgentWrapper := &GeminiAgentWrapper{
+		Agent: &mockGemini{
+			StreamFunc: func(config *gemini.GenerateContentConfig, history []*gemini.Content, userQuery string, onDelta func(string)) (string, error) {
+				onDelta("foo ")
+				onDelta("bar")
+				return "foo bar", nil
+			},
+		},
+		Cache: cache,
+	}
+
+	queryHandler := NewQueryHandler(newTestRegistry(&mockAgent{}, geminiAgentWrapper))
+
+	streamed := ""
+	response, err := queryHandler.StreamAgent(context.Background(), sessionId, Request{
+		Input:    "input",
+		Code:     "code",
+		Language: "lang",
+		Agent:    GEMINI,
+	}, func(delta string) {
+		streamed += delta
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := response.Response
+
+	if got != "foo bar" || streamed != "foo bar" {
+		t.Errorf("got %s (streamed %s), want foo bar", got, streamed)
+	}
+	if history := cache.Get(sessionId); len(history) != 2 || history[1].Content != "foo bar" {
+		t.Errorf("expected full response to be cached but got %v", history)
+	}
+}
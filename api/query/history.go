@@ -0,0 +1,216 @@
+package query
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"serious-fin/api/common"
+	"time"
+)
+
+// conversation history stored in the database, so conversations survive restarts. the in-memory cache is used
+// in front of it and sessions dropped from memory are loaded back from the database on the next access,
+// together with the summary of their evicted turns
+type HistoryStore struct {
+	DB              common.DBInterface
+	Front           *ContextCache
+	maxSize         int
+	retention       time.Duration
+	cleanupInterval time.Duration
+	stopChan        chan struct{}
+	nowFunc         func() time.Time
+}
+
+// maxSize - number of most recent requests/responses to keep per session, same as for the front cache;
+// retention - duration after the last turn after which a stored conversation is deleted;
+// cleanupInterval - how often stored conversations past retention are deleted;
+func NewHistoryStore(db common.DBInterface, front *ContextCache, maxSize int, retention, cleanupInterval time.Duration) (*HistoryStore, error) {
+	return NewHistoryStoreWithTimeFunc(db, front, maxSize, retention, cleanupInterval, time.Now)
+}
+
+// maxSize - number of most recent requests/responses to keep per session, same as for the front cache;
+// retention - duration after the last turn after which a stored conversation is deleted;
+// cleanupInterval - how often stored conversations past retention are deleted;
+// nowFunc - function which gets current time. time.Now() by default but can be overwritten for tests
+func NewHistoryStoreWithTimeFunc(db common.DBInterface, front *ContextCache, maxSize int, retention, cleanupInterval time.Duration, nowFunc func() time.Time) (*HistoryStore, error) {
+	if maxSize < 0 {
+		return nil, fmt.Errorf("history size can not be negative. provided value: %d", maxSize)
+	}
+	if retention <= 0 {
+		return nil, fmt.Errorf("retention has to be positive. provided value: %v", retention)
+	}
+	if cleanupInterval <= 0 {
+		return nil, fmt.Errorf("cleanup interval has to be positive. provided value: %v", cleanupInterval)
+	}
+
+	store := &HistoryStore{
+		DB:              db,
+		Front:           front,
+		maxSize:         maxSize,
+		retention:       retention,
+		cleanupInterval: cleanupInterval,
+		stopChan:        make(chan struct{}),
+		nowFunc:         nowFunc,
+	}
+	front.SetSummaryStore(store)
+	return store, nil
+}
+
+// turn is kept in memory even if it could not be stored, so the ongoing conversation is not affected by database errors
+func (hs *HistoryStore) Add(sessionId, userInput, aiOutput string) {
+	hs.Get(sessionId)
+	hs.Front.Add(sessionId, userInput, aiOutput)
+
+	err := hs.storeTurn(sessionId, userInput, aiOutput)
+	if err != nil {
+		fmt.Printf("Session %s: could not store turn: %v\n", sessionId, err)
+	}
+}
+
+func (hs *HistoryStore) Get(sessionId string) []Context {
+	contexts := hs.Front.Get(sessionId)
+	if len(contexts) > 0 {
+		return contexts
+	}
+
+	contexts, summary, err := hs.loadSession(sessionId)
+	if err != nil {
+		fmt.Printf("Session %s: could not load stored history: %v\n", sessionId, err)
+		return nil
+	}
+	if len(contexts) == 0 {
+		return contexts
+	}
+	hs.Front.set(sessionId, contexts, summary)
+	return hs.Front.Get(sessionId)
+}
+
+func (hs *HistoryStore) GetWithSummary(sessionId string) ([]Context, string) {
+	hs.Get(sessionId)
+	return hs.Front.GetWithSummary(sessionId)
+}
+
+// stored turns are removed together with the evicted ones, so a loaded session does not repeat what its summary covers
+func (hs *HistoryStore) Evict(sessionId string, evicted []Context) bool {
+	if !hs.Front.Evict(sessionId, evicted) {
+		return false
+	}
+	_, err := hs.DB.Exec(
+		"DELETE FROM conversationTurns WHERE id IN (SELECT id FROM conversationTurns WHERE sessionId = ? ORDER BY id LIMIT ?)",
+		sessionId, len(evicted),
+	)
+	if err != nil {
+		fmt.Printf("Session %s: could not delete evicted turns: %v\n", sessionId, err)
+	}
+	return true
+}
+
+func (hs *HistoryStore) StoreSummary(sessionId, summary string) error {
+	_, err := hs.DB.Exec(
+		"INSERT INTO conversationSummaries (sessionId, summary, updatedAt) VALUES (?, ?, ?) ON CONFLICT (sessionId) DO UPDATE SET summary = excluded.summary, updatedAt = excluded.updatedAt",
+		sessionId, summary, hs.nowFunc().UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return fmt.Errorf("could not store conversation summary: %w", err)
+	}
+	return nil
+}
+
+func (hs *HistoryStore) StartCleanupRoutine() {
+	hs.Front.StartCleanupRoutine()
+
+	ticker := time.NewTicker(hs.cleanupInterval)
+	go func() {
+		defer ticker.Stop()
+		fmt.Printf("History cleanup routine started, running every %s, retention is %s.\n", hs.cleanupInterval, hs.retention)
+		for {
+			select {
+			case <-ticker.C:
+				err := hs.deleteExpiredSessions()
+				if err != nil {
+					fmt.Printf("History cleanup: %v\n", err)
+				}
+			case <-hs.stopChan:
+				fmt.Println("History cleanup routine stopped.")
+				return
+			}
+		}
+	}()
+}
+
+func (hs *HistoryStore) StopCleanupRoutine() {
+	hs.Front.StopCleanupRoutine()
+	close(hs.stopChan)
+}
+
+// only the last maxSize request/response pairs of the session are kept, like in the front cache
+func (hs *HistoryStore) storeTurn(sessionId, userInput, aiOutput string) error {
+	createdAt := hs.nowFunc().UTC().Format(time.RFC3339)
+	_, err := hs.DB.Exec(
+		"INSERT INTO conversationTurns (sessionId, role, content, createdAt) VALUES (?, ?, ?, ?), (?, ?, ?, ?)",
+		sessionId, RoleUser, userInput, createdAt,
+		sessionId, RoleAssistant, aiOutput, createdAt,
+	)
+	if err != nil {
+		return fmt.Errorf("could not insert conversation turn: %w", err)
+	}
+
+	_, err = hs.DB.Exec(
+		"DELETE FROM conversationTurns WHERE sessionId = ? AND id NOT IN (SELECT id FROM conversationTurns WHERE sessionId = ? ORDER BY id DESC LIMIT ?)",
+		sessionId, sessionId, hs.maxSize*2,
+	)
+	if err != nil {
+		return fmt.Errorf("could not trim conversation turns: %w", err)
+	}
+	return nil
+}
+
+// summary is empty when the session has none
+func (hs *HistoryStore) loadSession(sessionId string) ([]Context, string, error) {
+	rows, err := hs.DB.Query("SELECT role, content FROM conversationTurns WHERE sessionId = ? ORDER BY id", sessionId)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not query conversation turns: %w", err)
+	}
+	defer rows.Close()
+
+	contexts := []Context{}
+	for rows.Next() {
+		var context Context
+		err = rows.Scan(&context.Role, &context.Content)
+		if err != nil {
+			return nil, "", fmt.Errorf("could not scan conversation turn: %w", err)
+		}
+		contexts = append(contexts, context)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("error iterating conversation turns: %w", err)
+	}
+	if len(contexts) == 0 {
+		return contexts, "", nil
+	}
+
+	var summary string
+	err = hs.DB.QueryRow("SELECT summary FROM conversationSummaries WHERE sessionId = ?", sessionId).Scan(&summary)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, "", fmt.Errorf("could not query conversation summary: %w", err)
+	}
+	return contexts, summary, nil
+}
+
+// summaries are deleted together with the last turns of their conversation
+func (hs *HistoryStore) deleteExpiredSessions() error {
+	cutoff := hs.nowFunc().Add(-hs.retention).UTC().Format(time.RFC3339)
+	_, err := hs.DB.Exec(
+		"DELETE FROM conversationTurns WHERE sessionId IN (SELECT sessionId FROM conversationTurns GROUP BY sessionId HAVING MAX(createdAt) < ?)",
+		cutoff,
+	)
+	if err != nil {
+		return fmt.Errorf("could not delete expired conversations: %w", err)
+	}
+
+	_, err = hs.DB.Exec("DELETE FROM conversationSummaries WHERE sessionId NOT IN (SELECT sessionId FROM conversationTurns)")
+	if err != nil {
+		return fmt.Errorf("could not delete expired conversation summaries: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package query
+
+import (
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func newTestHistoryStore(t *testing.T, nowFunc func() time.Time) (*HistoryStore, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	front, err := NewContextCacheWithTimeFunc(2, time.Minute, time.Minute, nowFunc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	store, err := NewHistoryStoreWithTimeFunc(db, front, 2, 24*time.Hour, time.Hour, nowFunc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return store, mock
+}
+
+func TestHistoryStoreConstructorErrors(t *testing.T) {
+	front, _ := NewContextCache(2, time.Minute, time.Minute)
+	if _, err := NewHistoryStore(nil, front, -1, time.Hour, time.Hour); err == nil {
+		t.Error("expected error for negative size")
+	}
+	if _, err := NewHistoryStore(nil, front, 2, 0, time.Hour); err == nil {
+		t.Error("expected error for non-positive retention")
+	}
+	if _, err := NewHistoryStore(nil, front, 2, time.Hour, 0); err == nil {
+		t.Error("expected error for non-positive cleanup interval")
+	}
+}
+
+func TestHistoryStoreLoadsSessionFromDatabase(t *testing.T) {
+	store, mock := newTestHistoryStore(t, time.Now)
+	mock.ExpectQuery("SELECT role, content FROM conversationTurns WHERE sessionId = \\? ORDER BY id").WithArgs("1").WillReturnRows(
+		sqlmock.NewRows([]string{"role", "content"}).
+			AddRow(RoleUser, "use a heap").
+			AddRow(RoleAssistant, "code"),
+	)
+	mock.ExpectQuery("SELECT summary FROM conversationSummaries WHERE sessionId = \\?").WithArgs("1").WillReturnError(sql.ErrNoRows)
+
+	want := []Context{{Role: RoleUser, Content: "use a heap"}, {Role: RoleAssistant, Content: "code"}}
+	got := store.Get("1")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// second access is served from memory
+	got = store.Get("1")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestHistoryStoreAddStoresAndTrimsTurns(t *testing.T) {
+	store, mock := newTestHistoryStore(t, time.Now)
+	mock.ExpectQuery("SELECT role, content FROM conversationTurns").WithArgs("1").WillReturnRows(sqlmock.NewRows([]string{"role", "content"}))
+	mock.ExpectExec("INSERT INTO conversationTurns").WithArgs("1", RoleUser, "query", sqlmock.AnyArg(), "1", RoleAssistant, "answer", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(2, 2))
+	mock.ExpectExec("DELETE FROM conversationTurns WHERE sessionId = \\? AND id NOT IN").WithArgs("1", "1", 4).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	store.Add("1", "query", "answer")
+
+	want := []Context{{Role: RoleUser, Content: "query"}, {Role: RoleAssistant, Content: "answer"}}
+	if got := store.Front.Get("1"); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestHistoryStoreAddKeepsTurnInMemoryOnDatabaseError(t *testing.T) {
+	store, mock := newTestHistoryStore(t, time.Now)
+	mock.ExpectQuery("SELECT role, content FROM conversationTurns").WillReturnError(errors.New("db down"))
+	mock.ExpectExec("INSERT INTO conversationTurns").WillReturnError(errors.New("db down"))
+
+	store.Add("1", "query", "answer")
+
+	if got := store.Front.Get("1"); len(got) != 2 {
+		t.Errorf("expected turn to be kept in memory but got %v", got)
+	}
+}
+
+func TestHistoryStoreLoadedSessionIsTrimmed(t *testing.T) {
+	store, mock := newTestHistoryStore(t, time.Now)
+	rows := sqlmock.NewRows([]string{"role", "content"})
+	for i := range 3 {
+		rows.AddRow(RoleUser, string(rune('a'+i)))
+		rows.AddRow(RoleAssistant, string(rune('A'+i)))
+	}
+	mock.ExpectQuery("SELECT role, content FROM conversationTurns").WillReturnRows(rows)
+	mock.ExpectQuery("SELECT summary FROM conversationSummaries").WillReturnError(sql.ErrNoRows)
+
+	store.Get("1")
+	got := store.Front.Get("1")
+	if len(got) != 4 || got[0].Content != "b" {
+		t.Errorf("expected only two most recent pairs to be kept but got %v", got)
+	}
+}
+
+func TestHistoryStoreLoadsSummaryWithSession(t *testing.T) {
+	store, mock := newTestHistoryStore(t, time.Now)
+	mock.ExpectQuery("SELECT role, content FROM conversationTurns").WithArgs("1").WillReturnRows(
+		sqlmock.NewRows([]string{"role", "content"}).AddRow(RoleUser, "query").AddRow(RoleAssistant, "answer"),
+	)
+	mock.ExpectQuery("SELECT summary FROM conversationSummaries").WithArgs("1").WillReturnRows(sqlmock.NewRows([]string{"summary"}).AddRow("- use a heap"))
+
+	got := store.Get("1")
+	want := append(summaryContexts("- use a heap"), Context{Role: RoleUser, Content: "query"}, Context{Role: RoleAssistant, Content: "answer"})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestHistoryStoreStoresSummaries(t *testing.T) {
+	store, mock := newTestHistoryStore(t, time.Now)
+	mock.ExpectExec("INSERT INTO conversationSummaries").WithArgs("1", "- use a heap", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	if err := store.Front.summaryStore.StoreSummary("1", "- use a heap"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestHistoryStoreDeletesEvictedTurns(t *testing.T) {
+	store, mock := newTestHistoryStore(t, time.Now)
+	store.Front.SetSummariser(&mockSummariser{
+		SummariseFunc: func(previousSummary string, evicted []Context) (string, error) {
+			return "summary", nil
+		},
+	})
+	store.Front.set("1", []Context{
+		{Role: RoleUser, Content: "first"}, {Role: RoleAssistant, Content: "answer"},
+		{Role: RoleUser, Content: "second"}, {Role: RoleAssistant, Content: "answer"},
+	}, "")
+	mock.ExpectExec("DELETE FROM conversationTurns WHERE id IN \\(SELECT id FROM conversationTurns WHERE sessionId = \\? ORDER BY id LIMIT \\?\\)").
+		WithArgs("1", 2).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO conversationSummaries").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	contexts, _ := store.GetWithSummary("1")
+	if !store.Evict("1", contexts[:2]) {
+		t.Fatal("expected oldest pair to be evicted")
+	}
+	store.Front.waitForSummaries()
+	if contexts, summary := store.GetWithSummary("1"); len(contexts) != 2 || summary != "summary" {
+		t.Errorf("expected summary and most recent pair but got %v and %q", contexts, summary)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestHistoryStoreDeletesExpiredSessions(t *testing.T) {
+	timer := newMockTime()
+	store, mock := newTestHistoryStore(t, timer.Now)
+	cutoff := timer.Now().Add(-24 * time.Hour).UTC().Format(time.RFC3339)
+	mock.ExpectExec("DELETE FROM conversationTurns WHERE sessionId IN").WithArgs(cutoff).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("DELETE FROM conversationSummaries WHERE sessionId NOT IN").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := store.deleteExpiredSessions(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
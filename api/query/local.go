@@ -0,0 +1,13 @@
+package query
+
+import (
+	"github.com/sashabaranov/go-openai"
+)
+
+// agent for any server implementing the OpenAI chat completions API, e.g. llama.cpp server, Ollama or vLLM.
+// baseURL should include the API version path (e.g. http://localhost:11434/v1), apiKey can be empty
+func NewOpenAICompatibleAgent(baseURL, model, apiKey string, cache CacheInterface) *ChatgptAgentWrapper {
+	config := openai.DefaultConfig(apiKey)
+	config.BaseURL = baseURL
+	return NewChatgptClientWrapper(openai.NewClientWithConfig(config), model, cache)
+}
@@ -0,0 +1,133 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+type receivedRequest struct {
+	path          string
+	authorization string
+	body          openai.ChatCompletionRequest
+}
+
+func newOpenAICompatibleServer(t *testing.T, received *receivedRequest, respond func(w http.ResponseWriter, body openai.ChatCompletionRequest)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.path = r.URL.Path
+		received.authorization = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&received.body); err != nil {
+			t.Errorf("could not decode request body: %v", err)
+		}
+		respond(w, received.body)
+	}))
+}
+
+func TestOpenAICompatibleAgentQuery(t *testing.T) {
+	var received receivedRequest
+	server := newOpenAICompatibleServer(t, &received, func(w http.ResponseWriter, body openai.ChatCompletionRequest) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id": "1", "object": "chat.completion", "choices": [{"index": 0, "message": {"role": "assistant", "content": "local answer"}, "finish_reason": "stop"}]}`)
+	})
+	defer server.Close()
+
+	cache, _ := NewContextCache(5, time.Minute, 2*time.Minute)
+	cache.Add("1", "previous question", "previous answer")
+	agent := NewOpenAICompatibleAgent(server.URL+"/v1", "llama3", "", cache)
+
+	got, err := agent.QueryWithContext(context.Background(), "1", "question", "system")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got != "local answer" {
+		t.Errorf("got %s, want local answer", got)
+	}
+	if received.path != "/v1/chat/completions" {
+		t.Errorf("got request path %s, want /v1/chat/completions", received.path)
+	}
+	if received.authorization != "" {
+		t.Errorf("expected no authorization header without key but got %s", received.authorization)
+	}
+	if received.body.Model != "llama3" {
+		t.Errorf("got model %s, want llama3", received.body.Model)
+	}
+
+	wantRoles := []string{RoleSystem, RoleUser, RoleAssistant, RoleUser}
+	if len(received.body.Messages) != len(wantRoles) {
+		t.Fatalf("got %d messages, want %d", len(received.body.Messages), len(wantRoles))
+	}
+	for i, role := range wantRoles {
+		if received.body.Messages[i].Role != role {
+			t.Errorf("message %d: got role %s, want %s", i, received.body.Messages[i].Role, role)
+		}
+	}
+	if history := cache.Get("1"); len(history) != 4 {
+		t.Errorf("expected new turn to be cached but history has %d messages", len(history))
+	}
+}
+
+func TestOpenAICompatibleAgentSendsKey(t *testing.T) {
+	var received receivedRequest
+	server := newOpenAICompatibleServer(t, &received, func(w http.ResponseWriter, body openai.ChatCompletionRequest) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices": [{"message": {"role": "assistant", "content": "ok"}}]}`)
+	})
+	defer server.Close()
+
+	agent := NewOpenAICompatibleAgent(server.URL+"/v1", "model", "secret", &mockCache{})
+	if _, err := agent.QueryWithContext(context.Background(), "1", "question", "system"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if received.authorization != "Bearer secret" {
+		t.Errorf("got authorization header %s, want Bearer secret", received.authorization)
+	}
+}
+
+func TestOpenAICompatibleAgentStream(t *testing.T) {
+	var received receivedRequest
+	server := newOpenAICompatibleServer(t, &received, func(w http.ResponseWriter, body openai.ChatCompletionRequest) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\": [{\"index\": 0, \"delta\": {\"content\": \"foo \"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\": [{\"index\": 0, \"delta\": {\"content\": \"bar\"}}]}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	})
+	defer server.Close()
+
+	agent := NewOpenAICompatibleAgent(server.URL+"/v1", "model", "", &mockCache{})
+	deltas := []string{}
+	got, err := agent.StreamWithContext(context.Background(), "1", "question", "system", func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got != "foo bar" || len(deltas) != 2 {
+		t.Errorf("got %s with deltas %v, want foo bar in 2 deltas", got, deltas)
+	}
+	if !received.body.Stream {
+		t.Error("expected streaming request")
+	}
+}
+
+func TestOpenAICompatibleAgentServerError(t *testing.T) {
+	var received receivedRequest
+	server := newOpenAICompatibleServer(t, &received, func(w http.ResponseWriter, body openai.ChatCompletionRequest) {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"error": {"message": "model not loaded"}}`)
+	})
+	defer server.Close()
+
+	agent := NewOpenAICompatibleAgent(server.URL+"/v1", "model", "", &mockCache{})
+	if _, err := agent.QueryWithContext(context.Background(), "1", "question", "system"); err == nil {
+		t.Error("expected error when server fails")
+	}
+}
@@ -0,0 +1,358 @@
+package query
+
+import (
+	"cmp"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"serious-fin/api/common"
+	"slices"
+	"strings"
+)
+
+// replacement of source between two offsets, insertion when both are equal
+type sourceEdit struct {
+	Start int
+	End   int
+	Text  string
+}
+
+// replaces top-level declarations of the submitted code with the answer's declarations of the same name,
+// adds the new ones after the rest and keeps everything the answer left out. missing imports are added.
+// returns the merged code and names of declarations taken from the answer
+func mergeCode(submitted, answer string) (string, []string, error) {
+	current, currentFileSet, currentSource, err := parseGoSource(submitted)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not parse submitted code: %w", err)
+	}
+	updated, updatedFileSet, updatedSource, err := parseGoSource(answer)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not parse answer: %w", err)
+	}
+	currentCode := goSource{currentFileSet, currentSource}
+	updatedCode := goSource{updatedFileSet, updatedSource}
+
+	edits := []sourceEdit{}
+	appended := []string{}
+	touched := []string{}
+	// replaced declarations and specs of grouped declarations
+	replaced := map[ast.Node]bool{}
+	for _, decl := range updated.Decls {
+		names := declNames(decl)
+		if len(names) == 0 {
+			continue
+		}
+		touched = append(touched, names...)
+
+		matching := slices.DeleteFunc(slices.Clone(current.Decls), func(currentDecl ast.Decl) bool {
+			return replaced[currentDecl] || !sharesName(remainingNames(currentDecl, replaced), names)
+		})
+		// specs of a group which the answer does not mention are kept, only the named ones are replaced
+		whole := []ast.Decl{}
+		specs := valueSpecs(decl)
+		remaining := specs
+		for _, currentDecl := range matching {
+			group, ok := currentDecl.(*ast.GenDecl)
+			if !ok || !group.Lparen.IsValid() || !hasReplacedSpec(group, replaced) && isSubset(declNames(group), names) {
+				whole = append(whole, currentDecl)
+				continue
+			}
+			var groupEdits []sourceEdit
+			groupEdits, remaining = mergeIntoGroup(group, names, remaining, decl, currentCode, updatedCode, replaced)
+			edits = append(edits, groupEdits...)
+		}
+
+		text := updatedCode.declText(decl)
+		if len(remaining) < len(specs) {
+			text = updatedCode.specsText(decl.(*ast.GenDecl), remaining)
+		}
+		if len(whole) == 0 {
+			if text != "" {
+				appended = append(appended, text)
+			}
+			continue
+		}
+		// a grouped declaration may replace several single ones, it takes the place of the first
+		for index, currentDecl := range whole {
+			replaced[currentDecl] = true
+			start, end := declRange(currentFileSet, currentDecl)
+			edit := sourceEdit{Start: start, End: end}
+			if index == 0 {
+				edit.Text = text
+			}
+			edits = append(edits, edit)
+		}
+	}
+
+	missing := missingImports(current, updated, updatedFileSet, updatedSource)
+	if len(missing) > 0 {
+		edits = append(edits, importEdit(current, currentFileSet, currentSource, missing))
+	}
+
+	merged := applyEdits(currentSource, edits)
+	if len(appended) > 0 {
+		merged = strings.TrimRight(merged, " \t\n") + "\n\n" + strings.Join(appended, "\n\n") + "\n"
+	}
+	if !common.HasPackageClause(submitted) {
+		merged, _ = strings.CutPrefix(merged, common.PackageClausePrefix)
+	}
+	return strings.TrimSpace(merged), touched, nil
+}
+
+// parsed code together with its source, positions of the file set point into the source
+type goSource struct {
+	fileSet *token.FileSet
+	source  string
+}
+
+func (code goSource) text(start, end token.Pos) string {
+	return code.source[code.fileSet.Position(start).Offset:code.fileSet.Position(end).Offset]
+}
+
+func (code goSource) declText(decl ast.Decl) string {
+	start, end := declRange(code.fileSet, decl)
+	return code.source[start:end]
+}
+
+// doc comment of a single declaration is kept with its only spec
+func (code goSource) specText(decl *ast.GenDecl, spec ast.Spec) string {
+	start, end := specRange(spec)
+	text := code.text(start, end)
+	if !decl.Lparen.IsValid() && decl.Doc != nil {
+		text = code.text(decl.Doc.Pos(), decl.Doc.End()) + "\n" + text
+	}
+	return text
+}
+
+// declaration of the given specs, empty when there are none
+func (code goSource) specsText(decl *ast.GenDecl, specs []ast.Spec) string {
+	texts := []string{}
+	for _, spec := range specs {
+		texts = append(texts, code.specText(decl, spec))
+	}
+	switch len(texts) {
+	case 0:
+		return ""
+	case 1:
+		return decl.Tok.String() + " " + texts[0]
+	}
+	return decl.Tok.String() + " (\n\t" + strings.Join(texts, "\n\t") + "\n)"
+}
+
+// replaces specs of the group declaring any of the names with the answer's specs of the same kind declaring them,
+// specs without a replacement are removed. returns the answer's specs which were not placed into the group
+func mergeIntoGroup(group *ast.GenDecl, names []string, answerSpecs []ast.Spec, answerDecl ast.Decl, currentCode, updatedCode goSource, replaced map[ast.Node]bool) ([]sourceEdit, []ast.Spec) {
+	answerGroup, sameKind := answerDecl.(*ast.GenDecl)
+	sameKind = sameKind && answerGroup.Tok == group.Tok
+
+	edits := []sourceEdit{}
+	for _, spec := range group.Specs {
+		if replaced[spec] || !sharesName(specNames(spec), names) {
+			continue
+		}
+		replaced[spec] = true
+		texts := []string{}
+		if sameKind {
+			answerSpecs = slices.DeleteFunc(answerSpecs, func(answerSpec ast.Spec) bool {
+				if !sharesName(specNames(answerSpec), specNames(spec)) {
+					return false
+				}
+				texts = append(texts, updatedCode.specText(answerGroup, answerSpec))
+				return true
+			})
+		}
+		start, end := specRange(spec)
+		edits = append(edits, sourceEdit{
+			Start: currentCode.fileSet.Position(start).Offset,
+			End:   currentCode.fileSet.Position(end).Offset,
+			Text:  strings.Join(texts, "\n\t"),
+		})
+	}
+	return edits, answerSpecs
+}
+
+// range covers the doc and line comments, so they move together with the spec
+func specRange(spec ast.Spec) (token.Pos, token.Pos) {
+	start, end := spec.Pos(), spec.End()
+	var doc, comment *ast.CommentGroup
+	switch spec := spec.(type) {
+	case *ast.ValueSpec:
+		doc, comment = spec.Doc, spec.Comment
+	case *ast.TypeSpec:
+		doc, comment = spec.Doc, spec.Comment
+	}
+	if doc != nil {
+		start = doc.Pos()
+	}
+	if comment != nil {
+		end = comment.End()
+	}
+	return start, end
+}
+
+// specs of const, var and type declarations, nil for other declarations
+func valueSpecs(decl ast.Decl) []ast.Spec {
+	genDecl, ok := decl.(*ast.GenDecl)
+	if !ok || genDecl.Tok == token.IMPORT {
+		return nil
+	}
+	return slices.Clone(genDecl.Specs)
+}
+
+// names of the declaration without those of its replaced specs
+func remainingNames(decl ast.Decl, replaced map[ast.Node]bool) []string {
+	genDecl, ok := decl.(*ast.GenDecl)
+	if !ok {
+		return declNames(decl)
+	}
+	names := []string{}
+	for _, spec := range genDecl.Specs {
+		if !replaced[spec] {
+			names = append(names, specNames(spec)...)
+		}
+	}
+	return names
+}
+
+func hasReplacedSpec(group *ast.GenDecl, replaced map[ast.Node]bool) bool {
+	return slices.ContainsFunc(group.Specs, func(spec ast.Spec) bool {
+		return replaced[spec]
+	})
+}
+
+func sharesName(names, others []string) bool {
+	return slices.ContainsFunc(names, func(name string) bool {
+		return slices.Contains(others, name)
+	})
+}
+
+func isSubset(names, others []string) bool {
+	return !slices.ContainsFunc(names, func(name string) bool {
+		return !slices.Contains(others, name)
+	})
+}
+
+// methods are named after their receiver type, e.g. Heap.Push. imports and blank identifiers have no name
+func declNames(decl ast.Decl) []string {
+	names := []string{}
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		if decl.Recv == nil || len(decl.Recv.List) == 0 {
+			return append(names, decl.Name.Name)
+		}
+		return append(names, receiverTypeName(decl.Recv.List[0].Type)+"."+decl.Name.Name)
+	case *ast.GenDecl:
+		for _, spec := range decl.Specs {
+			names = append(names, specNames(spec)...)
+		}
+	}
+	return names
+}
+
+func specNames(spec ast.Spec) []string {
+	names := []string{}
+	switch spec := spec.(type) {
+	case *ast.TypeSpec:
+		names = append(names, spec.Name.Name)
+	case *ast.ValueSpec:
+		for _, name := range spec.Names {
+			if name.Name != "_" {
+				names = append(names, name.Name)
+			}
+		}
+	}
+	return names
+}
+
+func receiverTypeName(expr ast.Expr) string {
+	switch expr := expr.(type) {
+	case *ast.StarExpr:
+		return receiverTypeName(expr.X)
+	case *ast.IndexExpr:
+		return receiverTypeName(expr.X)
+	case *ast.IndexListExpr:
+		return receiverTypeName(expr.X)
+	case *ast.Ident:
+		return expr.Name
+	}
+	return ""
+}
+
+// range covers the doc comment, so it moves together with the declaration
+func declRange(fileSet *token.FileSet, decl ast.Decl) (int, int) {
+	start := decl.Pos()
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		if decl.Doc != nil {
+			start = decl.Doc.Pos()
+		}
+	case *ast.GenDecl:
+		if decl.Doc != nil {
+			start = decl.Doc.Pos()
+		}
+	}
+	return fileSet.Position(start).Offset, fileSet.Position(decl.End()).Offset
+}
+
+// returns import specs of the answer, as written there, which the submitted code does not have
+func missingImports(current, updated *ast.File, updatedFileSet *token.FileSet, updatedSource string) []string {
+	missing := []string{}
+	for _, spec := range updated.Imports {
+		exists := slices.ContainsFunc(current.Imports, func(currentSpec *ast.ImportSpec) bool {
+			return currentSpec.Path.Value == spec.Path.Value
+		})
+		if !exists {
+			start := updatedFileSet.Position(spec.Pos()).Offset
+			end := updatedFileSet.Position(spec.End()).Offset
+			missing = append(missing, updatedSource[start:end])
+		}
+	}
+	return missing
+}
+
+// imports are added to the first import declaration or in a new one before the first declaration
+func importEdit(current *ast.File, fileSet *token.FileSet, source string, missing []string) sourceEdit {
+	for _, decl := range current.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.IMPORT {
+			continue
+		}
+		if genDecl.Lparen.IsValid() {
+			offset := fileSet.Position(genDecl.Rparen).Offset
+			text := "\t" + strings.Join(missing, "\n\t") + "\n"
+			if !strings.HasSuffix(strings.TrimRight(source[:offset], " \t"), "\n") {
+				text = "\n" + text
+			}
+			return sourceEdit{Start: offset, End: offset, Text: text}
+		}
+		offset := fileSet.Position(genDecl.End()).Offset
+		return sourceEdit{Start: offset, End: offset, Text: "\nimport " + strings.Join(missing, "\nimport ")}
+	}
+
+	offset := len(source)
+	if len(current.Decls) > 0 {
+		offset, _ = declRange(fileSet, current.Decls[0])
+	}
+	// comments between the package clause and the first declaration stay below the imports
+	for _, comment := range current.Comments {
+		if comment.Pos() > current.Name.End() {
+			offset = min(offset, fileSet.Position(comment.Pos()).Offset)
+			break
+		}
+	}
+	return sourceEdit{Start: offset, End: offset, Text: "import (\n\t" + strings.Join(missing, "\n\t") + "\n)\n\n"}
+}
+
+// edits are applied from the end so offsets stay valid, an insertion lands before a replacement at the same offset
+func applyEdits(source string, edits []sourceEdit) string {
+	slices.SortStableFunc(edits, func(a, b sourceEdit) int {
+		if a.Start != b.Start {
+			return cmp.Compare(b.Start, a.Start)
+		}
+		return cmp.Compare(b.End, a.End)
+	})
+	for _, edit := range edits {
+		source = source[:edit.Start] + edit.Text + source[edit.End:]
+	}
+	return source
+}
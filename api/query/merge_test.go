@@ -0,0 +1,186 @@
+package query
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestMergeCodeReplacesAndAddsDeclarations(t *testing.T) {
+	submitted := `// Heap keeps the smallest value on top
+type Heap []int
+
+func (h *Heap) Push(x int) {
+	*h = append(*h, x)
+}
+
+// helper the answer does not mention
+func helper() int {
+	return 1
+}
+
+func solve(nums []int) int {
+	return 0
+}`
+	answer := `import "sort"
+
+func solve(nums []int) int {
+	sort.Ints(nums)
+	return nums[0] + helper() + limit
+}
+
+const limit = 10`
+
+	got, touched, err := mergeCode(submitted, answer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `import (
+	"sort"
+)
+
+// Heap keeps the smallest value on top
+type Heap []int
+
+func (h *Heap) Push(x int) {
+	*h = append(*h, x)
+}
+
+// helper the answer does not mention
+func helper() int {
+	return 1
+}
+
+func solve(nums []int) int {
+	sort.Ints(nums)
+	return nums[0] + helper() + limit
+}
+
+const limit = 10`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if !slices.Equal(touched, []string{"solve", "limit"}) {
+		t.Errorf("got touched declarations %v", touched)
+	}
+}
+
+func TestMergeCodeKeepsPackageClauseAndImports(t *testing.T) {
+	submitted := "package main\n\nimport (\n\t\"fmt\"\n)\n\nfunc main() {\n\tfmt.Println(1)\n}\n"
+	answer := "import \"strings\"\n\n// Print writes the value\nfunc (p *Printer[T]) Print() {}\n\nfunc main() {\n\tfmt.Println(strings.ToUpper(\"a\"))\n}"
+
+	got, touched, err := mergeCode(submitted, answer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "package main\n\nimport (\n\t\"fmt\"\n\t\"strings\"\n)\n\nfunc main() {\n\tfmt.Println(strings.ToUpper(\"a\"))\n}\n\n// Print writes the value\nfunc (p *Printer[T]) Print() {}"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+	if !slices.Equal(touched, []string{"Printer.Print", "main"}) {
+		t.Errorf("got touched declarations %v", touched)
+	}
+}
+
+func TestMergeCodeReplacesSingleDeclarationsWithGroup(t *testing.T) {
+	got, _, err := mergeCode("const a = 1\n\nconst b = 2\n\nfunc f() {}", "const (\n\ta = 3\n\tb = 4\n)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "const (\n\ta = 3\n\tb = 4\n)\n\n\n\nfunc f() {}"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMergeCodeReplacesSpecsOfGroup(t *testing.T) {
+	tests := []struct {
+		name      string
+		submitted string
+		answer    string
+		want      string
+	}{
+		{"single declaration", "const (\n\tsmall = 1\n\tlarge = 2\n)", "const small = 5", "const (\n\tsmall = 5\n\tlarge = 2\n)"},
+		{"comments move with spec", "var (\n\t// first value\n\ta = 1 // old\n\tb = 2\n)", "// new value\nvar a = 3", "var (\n\t// new value\na = 3\n\tb = 2\n)"},
+		{"smaller group", "type (\n\tA int\n\tB string\n\tC bool\n)", "type (\n\tA uint\n\tC byte\n\tD rune\n)", "type (\n\tA uint\n\tB string\n\tC byte\n)\n\ntype D rune"},
+		{"several declarations", "const (\n\tsmall = 1\n\tlarge = 2\n\thuge = 3\n)", "const small = 5\n\nconst large = 6", "const (\n\tsmall = 5\n\tlarge = 6\n\thuge = 3\n)"},
+		{"other kind", "var (\n\tsmall = 1\n\tlarge = 2\n)", "const small = 5", "var (\n\t\n\tlarge = 2\n)\n\nconst small = 5"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, _, err := mergeCode(test.submitted, test.answer)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+			if _, err := parseGoCode(got); err != nil {
+				t.Errorf("expected merged code to parse but got %v", err)
+			}
+		})
+	}
+}
+
+func TestMergeCodeReplacesDocumentationOfFirstDeclaration(t *testing.T) {
+	got, _, err := mergeCode("// old doc\nfunc f() {}\n\nfunc g() {}", "// new doc\n// second line\nfunc f() { g() }")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "// new doc\n// second line\nfunc f() { g() }\n\nfunc g() {}"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMergeCodeAddsImportsToSingleLineGroup(t *testing.T) {
+	got, _, err := mergeCode("import (\"fmt\")\n\nfunc f() { fmt.Println() }", "import \"os\"\n\nfunc g() { os.Exit(0) }")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := parseGoCode(got); err != nil {
+		t.Errorf("expected merged code to parse but got %v for\n%s", err, got)
+	}
+}
+
+func TestMergeCodeFailsOnInvalidCode(t *testing.T) {
+	if _, _, err := mergeCode("func f() {", "func f() {}"); err == nil {
+		t.Error("expected error for submitted code which does not parse")
+	}
+	if _, _, err := mergeCode("func f() {}", "func f() {"); err == nil {
+		t.Error("expected error for answer which does not parse")
+	}
+}
+
+func TestQueryAgentMergesOnlyWhenRequested(t *testing.T) {
+	agent := &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			return "func solve() int {\n\treturn helper()\n}", nil
+		},
+	}
+	queryHandler := NewQueryHandler(newTestRegistry(&mockAgent{}, agent))
+	request := Request{
+		Code:     "func helper() int {\n\treturn 1\n}\n\nfunc solve() int {\n\treturn 0\n}",
+		Language: "go",
+		Agent:    GEMINI,
+	}
+
+	got, err := queryHandler.QueryAgent(context.Background(), "1", request)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Response != "func solve() int {\n\treturn helper()\n}" || got.MergedDeclarations != nil {
+		t.Errorf("expected answer to replace the code without merge mode but got %+v", got)
+	}
+
+	request.Merge = true
+	got, err = queryHandler.QueryAgent(context.Background(), "1", request)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "func helper() int {\n\treturn 1\n}\n\nfunc solve() int {\n\treturn helper()\n}"
+	if got.Response != want || !slices.Equal(got.MergedDeclarations, []string{"solve"}) {
+		t.Errorf("expected merged code but got %+v", got)
+	}
+}
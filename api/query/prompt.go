@@ -0,0 +1,203 @@
+package query
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"serious-fin/api/common"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var ErrInvalidPromptTemplate = errors.New("invalid prompt template")
+
+// prompt versions are kept per scope: a problem, a language, both or neither (global).
+// only one version of a scope is active at a time
+type PromptTemplate struct {
+	Id           int    `json:"id"`
+	Version      int    `json:"version"`
+	SystemPrompt string `json:"systemPrompt"`
+	UserTemplate string `json:"userTemplate"`
+	ProblemId    int    `json:"problemId,omitempty"`
+	Language     string `json:"language,omitempty"`
+	IsActive     bool   `json:"isActive"`
+	CreatedAt    string `json:"createdAt,omitempty"`
+}
+
+type CreatePromptRequest struct {
+	SystemPrompt string `form:"systemPrompt"`
+	UserTemplate string `form:"userTemplate"`
+	ProblemId    int    `form:"problemId"`
+	Language     string `form:"language"`
+}
+
+type PromptProvider interface {
+	GetActivePrompt(problemId int, language string) (*PromptTemplate, error)
+	RecordPromptUsage(sessionId, agent string, problemId int, prompt *PromptTemplate) error
+}
+
+type PromptStore struct {
+	DB common.DBInterface
+}
+
+// values available in user templates, e.g. {{.Description}}
+type promptData struct {
+	Description string
+	Language    string
+	Code        string
+}
+
+// built-in prompt used when no version is active for the request, it has id and version 0
+var defaultPrompt = PromptTemplate{
+	SystemPrompt: systemPrompt,
+	UserTemplate: userPromptTemplate,
+}
+
+const promptColumns = "id, version, systemPrompt, userTemplate, problemId, language, isActive, createdAt"
+
+func NewPromptStore(db common.DBInterface) *PromptStore {
+	return &PromptStore{DB: db}
+}
+
+// problem specific versions take precedence over language specific ones, global versions are used last.
+// returns nil without error when no version is active
+func (store *PromptStore) GetActivePrompt(problemId int, language string) (*PromptTemplate, error) {
+	row := store.DB.QueryRow(
+		"SELECT "+promptColumns+" FROM promptTemplates WHERE isActive = true AND (problemId = ? OR problemId IS NULL) AND (language = ? OR language IS NULL) ORDER BY problemId IS NULL, language IS NULL LIMIT 1",
+		problemId, language,
+	)
+	prompt, err := scanPromptTemplate(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get active prompt (problem id %d, language %s): %w", problemId, language, err)
+	}
+	return prompt, nil
+}
+
+func (store *PromptStore) ListPromptVersions() ([]PromptTemplate, error) {
+	rows, err := store.DB.Query("SELECT " + promptColumns + " FROM promptTemplates ORDER BY problemId, language, version")
+	if err != nil {
+		return nil, fmt.Errorf("could not query prompt templates: %w", err)
+	}
+	defer rows.Close()
+
+	prompts := []PromptTemplate{}
+	for rows.Next() {
+		prompt, err := scanPromptTemplate(rows)
+		if err != nil {
+			return nil, fmt.Errorf("could not scan prompt template: %w", err)
+		}
+		prompts = append(prompts, *prompt)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating prompt templates: %w", err)
+	}
+	return prompts, nil
+}
+
+// new version is numbered after the latest version of the same scope and is inactive until activated
+func (store *PromptStore) CreatePromptVersion(request CreatePromptRequest) (*PromptTemplate, error) {
+	err := checkPromptTemplate(request.SystemPrompt, request.UserTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	problemId := sql.NullInt64{Int64: int64(request.ProblemId), Valid: request.ProblemId != 0}
+	language := sql.NullString{String: request.Language, Valid: request.Language != ""}
+	createdAt := time.Now().UTC().Format(time.RFC3339)
+	row := store.DB.QueryRow(
+		"INSERT INTO promptTemplates (version, systemPrompt, userTemplate, problemId, language, isActive, createdAt) VALUES ((SELECT COALESCE(MAX(version), 0) + 1 FROM promptTemplates WHERE problemId IS ? AND language IS ?), ?, ?, ?, ?, false, ?) RETURNING "+promptColumns,
+		problemId, language, request.SystemPrompt, request.UserTemplate, problemId, language, createdAt,
+	)
+	prompt, err := scanPromptTemplate(row)
+	if err != nil {
+		return nil, fmt.Errorf("could not insert prompt template: %w", err)
+	}
+	return prompt, nil
+}
+
+// deactivates all other versions of the same scope
+func (store *PromptStore) ActivatePromptVersion(promptId int) (*PromptTemplate, error) {
+	result, err := store.DB.Exec(
+		"UPDATE promptTemplates SET isActive = (id = ?) WHERE problemId IS (SELECT problemId FROM promptTemplates WHERE id = ?) AND language IS (SELECT language FROM promptTemplates WHERE id = ?)",
+		promptId, promptId, promptId,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not activate prompt template (id %d): %w", promptId, err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("could not check activated prompt template (id %d): %w", promptId, err)
+	}
+	if updated == 0 {
+		return nil, fmt.Errorf("prompt template with id %d does not exist: %w", promptId, sql.ErrNoRows)
+	}
+
+	row := store.DB.QueryRow("SELECT "+promptColumns+" FROM promptTemplates WHERE id = ?", promptId)
+	prompt, err := scanPromptTemplate(row)
+	if err != nil {
+		return nil, fmt.Errorf("could not get activated prompt template (id %d): %w", promptId, err)
+	}
+	return prompt, nil
+}
+
+// built-in prompt is recorded with empty prompt id
+func (store *PromptStore) RecordPromptUsage(sessionId, agent string, problemId int, prompt *PromptTemplate) error {
+	promptId := sql.NullInt64{Int64: int64(prompt.Id), Valid: prompt.Id != 0}
+	_, err := store.DB.Exec(
+		"INSERT INTO promptUsages (sessionId, agent, problemId, promptId, createdAt) VALUES (?, ?, ?, ?, ?)",
+		sessionId, agent, problemId, promptId, time.Now().UTC().Format(time.RFC3339),
+	)
+	if err != nil {
+		return fmt.Errorf("could not record prompt usage (session id %s): %w", sessionId, err)
+	}
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPromptTemplate(row rowScanner) (*PromptTemplate, error) {
+	var prompt PromptTemplate
+	var problemId sql.NullInt64
+	var language sql.NullString
+	err := row.Scan(&prompt.Id, &prompt.Version, &prompt.SystemPrompt, &prompt.UserTemplate, &problemId, &language, &prompt.IsActive, &prompt.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	prompt.ProblemId = int(problemId.Int64)
+	prompt.Language = language.String
+	return &prompt, nil
+}
+
+func checkPromptTemplate(systemPrompt, userTemplate string) error {
+	if strings.TrimSpace(systemPrompt) == "" {
+		return fmt.Errorf("system prompt can not be empty: %w", ErrInvalidPromptTemplate)
+	}
+	if !strings.Contains(userTemplate, "{{.Code}}") {
+		return fmt.Errorf("user template has to include {{.Code}}: %w", ErrInvalidPromptTemplate)
+	}
+	_, err := renderUserQuery(userTemplate, promptData{})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidPromptTemplate, err)
+	}
+	return nil
+}
+
+func renderUserQuery(userTemplate string, data promptData) (string, error) {
+	tmpl, err := template.New("userPrompt").Option("missingkey=error").Parse(userTemplate)
+	if err != nil {
+		return "", fmt.Errorf("could not parse user template: %w", err)
+	}
+
+	var builder strings.Builder
+	err = tmpl.Execute(&builder, data)
+	if err != nil {
+		return "", fmt.Errorf("could not execute user template: %w", err)
+	}
+	return builder.String(), nil
+}
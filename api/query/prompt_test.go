@@ -0,0 +1,247 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var promptRowColumns = []string{"id", "version", "systemPrompt", "userTemplate", "problemId", "language", "isActive", "createdAt"}
+
+type mockPromptProvider struct {
+	GetActivePromptFunc   func(problemId int, language string) (*PromptTemplate, error)
+	RecordPromptUsageFunc func(sessionId, agent string, problemId int, prompt *PromptTemplate) error
+}
+
+func (mockPromptProvider *mockPromptProvider) GetActivePrompt(problemId int, language string) (*PromptTemplate, error) {
+	if mockPromptProvider.GetActivePromptFunc != nil {
+		return mockPromptProvider.GetActivePromptFunc(problemId, language)
+	}
+	return nil, nil
+}
+
+func (mockPromptProvider *mockPromptProvider) RecordPromptUsage(sessionId, agent string, problemId int, prompt *PromptTemplate) error {
+	if mockPromptProvider.RecordPromptUsageFunc != nil {
+		return mockPromptProvider.RecordPromptUsageFunc(sessionId, agent, problemId, prompt)
+	}
+	return nil
+}
+
+func TestGetActivePromptReturnsMostSpecificVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT .* FROM promptTemplates WHERE isActive = true .* ORDER BY problemId IS NULL, language IS NULL LIMIT 1").WithArgs(3, "go").WillReturnRows(
+		sqlmock.NewRows(promptRowColumns).AddRow(7, 2, "system", "{{.Code}}", 3, nil, true, "2026-01-01T00:00:00Z"),
+	)
+
+	got, err := NewPromptStore(db).GetActivePrompt(3, "go")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &PromptTemplate{Id: 7, Version: 2, SystemPrompt: "system", UserTemplate: "{{.Code}}", ProblemId: 3, IsActive: true, CreatedAt: "2026-01-01T00:00:00Z"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestGetActivePromptWithoutActiveVersionReturnsNil(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT .* FROM promptTemplates").WillReturnRows(sqlmock.NewRows(promptRowColumns))
+
+	got, err := NewPromptStore(db).GetActivePrompt(3, "go")
+	if err != nil || got != nil {
+		t.Errorf("expected no prompt and no error but got %v, %v", got, err)
+	}
+}
+
+func TestCreatePromptVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	language := sql.NullString{String: "go", Valid: true}
+	noProblem := sql.NullInt64{}
+	mock.ExpectQuery("INSERT INTO promptTemplates .* RETURNING").
+		WithArgs(noProblem, language, "system", "{{.Code}}", noProblem, language, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(promptRowColumns).AddRow(4, 3, "system", "{{.Code}}", nil, "go", false, "2026-01-01T00:00:00Z"))
+
+	got, err := NewPromptStore(db).CreatePromptVersion(CreatePromptRequest{SystemPrompt: "system", UserTemplate: "{{.Code}}", Language: "go"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Id != 4 || got.Version != 3 || got.Language != "go" || got.IsActive {
+		t.Errorf("unexpected created prompt %+v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestCreatePromptVersionRejectsInvalidTemplates(t *testing.T) {
+	store := NewPromptStore(nil)
+	requests := []CreatePromptRequest{
+		{SystemPrompt: " ", UserTemplate: "{{.Code}}"},
+		{SystemPrompt: "system", UserTemplate: "no code"},
+		{SystemPrompt: "system", UserTemplate: "{{.Code}} {{.Unknown}}"},
+		{SystemPrompt: "system", UserTemplate: "{{.Code}} {{if}}"},
+	}
+	for _, request := range requests {
+		if _, err := store.CreatePromptVersion(request); !errors.Is(err, ErrInvalidPromptTemplate) {
+			t.Errorf("expected invalid template error for %+v but got %v", request, err)
+		}
+	}
+}
+
+func TestActivatePromptVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("UPDATE promptTemplates SET isActive = \\(id = \\?\\)").WithArgs(4, 4, 4).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery("SELECT .* FROM promptTemplates WHERE id = ?").WithArgs(4).WillReturnRows(
+		sqlmock.NewRows(promptRowColumns).AddRow(4, 3, "system", "{{.Code}}", nil, "go", true, "2026-01-01T00:00:00Z"),
+	)
+
+	got, err := NewPromptStore(db).ActivatePromptVersion(4)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !got.IsActive {
+		t.Errorf("expected activated prompt but got %+v", got)
+	}
+}
+
+func TestActivateMissingPromptVersion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectExec("UPDATE promptTemplates").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if _, err := NewPromptStore(db).ActivatePromptVersion(4); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected no rows error but got %v", err)
+	}
+}
+
+func TestQueryUsesActivePromptAndRecordsIt(t *testing.T) {
+	prompt := &PromptTemplate{Id: 5, Version: 2, SystemPrompt: "custom system", UserTemplate: "solve in {{.Language}}: {{.Code}}"}
+	var gotQuery, gotSystemPrompt string
+	agent := &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			gotQuery, gotSystemPrompt = userQuery, systemPrompt
+			return "func code() {}", nil
+		},
+	}
+	var recorded *PromptTemplate
+	prompts := &mockPromptProvider{
+		GetActivePromptFunc: func(problemId int, language string) (*PromptTemplate, error) {
+			if problemId != 3 || language != "go" {
+				t.Errorf("unexpected prompt selection for problem %d and language %s", problemId, language)
+			}
+			return prompt, nil
+		},
+		RecordPromptUsageFunc: func(sessionId, agentName string, problemId int, usedPrompt *PromptTemplate) error {
+			recorded = usedPrompt
+			return nil
+		},
+	}
+
+	handler := NewQueryHandlerWithPrompts(newTestRegistry(agent, &mockAgent{}), prompts)
+	_, err := handler.QueryAgent(context.Background(), "1", Request{Code: "func f() {}", Language: "go", Agent: CHATGPT, ProblemId: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if gotSystemPrompt != "custom system" || gotQuery != "solve in go: func f() {}" {
+		t.Errorf("expected active prompt to be used but got %q and %q", gotSystemPrompt, gotQuery)
+	}
+	if recorded != prompt {
+		t.Errorf("expected used prompt to be recorded but got %+v", recorded)
+	}
+}
+
+func TestQueryFallsBackToBuiltInPrompt(t *testing.T) {
+	var gotSystemPrompt string
+	agent := &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			gotSystemPrompt = systemPrompt
+			return "func code() {}", nil
+		},
+	}
+	var recorded *PromptTemplate
+	prompts := &mockPromptProvider{
+		RecordPromptUsageFunc: func(sessionId, agentName string, problemId int, usedPrompt *PromptTemplate) error {
+			recorded = usedPrompt
+			return errors.New("recording failed")
+		},
+	}
+
+	handler := NewQueryHandlerWithPrompts(newTestRegistry(agent, &mockAgent{}), prompts)
+	if _, err := handler.QueryAgent(context.Background(), "1", Request{Agent: CHATGPT}); err != nil {
+		t.Fatalf("expected failed recording not to fail the query but got %v", err)
+	}
+	if gotSystemPrompt != systemPrompt || recorded == nil || recorded.Id != 0 {
+		t.Errorf("expected built-in prompt to be used and recorded")
+	}
+}
+
+func TestQueryRecordsAgentWhichAnswered(t *testing.T) {
+	registry := newTestRegistry(&mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			return "", ErrAgentUnavailable
+		},
+	}, &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			return "fallback code", nil
+		},
+	})
+	registry.SetFallback(CHATGPT, GEMINI)
+	var recordedAgent string
+	prompts := &mockPromptProvider{
+		RecordPromptUsageFunc: func(sessionId, agentName string, problemId int, usedPrompt *PromptTemplate) error {
+			recordedAgent = agentName
+			return nil
+		},
+	}
+
+	handler := NewQueryHandlerWithPrompts(registry, prompts)
+	if _, err := handler.QueryAgent(context.Background(), "1", Request{Agent: CHATGPT}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if recordedAgent != GEMINI {
+		t.Errorf("expected fallback agent to be recorded but got %q", recordedAgent)
+	}
+}
+
+func TestQueryPromptSelectionError(t *testing.T) {
+	prompts := &mockPromptProvider{
+		GetActivePromptFunc: func(problemId int, language string) (*PromptTemplate, error) {
+			return nil, errors.New("db down")
+		},
+	}
+
+	handler := NewQueryHandlerWithPrompts(newTestRegistry(&mockAgent{}, &mockAgent{}), prompts)
+	if _, err := handler.QueryAgent(context.Background(), "1", Request{Agent: CHATGPT}); err == nil || !strings.Contains(err.Error(), "db down") {
+		t.Errorf("expected prompt selection error but got %v", err)
+	}
+}
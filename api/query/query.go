@@ -1,36 +1,87 @@
 package query
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"serious-fin/api/common"
+	"serious-fin/api/validator"
 	"strings"
 )
 
 type Request struct {
-	Input    string `form:"input"`
-	Code     string `form:"code"`
-	Language string `form:"language"`
-	Agent    string `form:"agent"`
+	Input     string              `form:"input"`
+	Code      string              `form:"code"`
+	Files     []common.SourceFile `form:"files"`
+	Language  string              `form:"language"`
+	Agent     string              `form:"agent"`
+	ProblemId int                 `form:"problemId"`
+	// merges declarations from the answer into the submitted code instead of replacing it, go only
+	Merge bool `form:"merge"`
+	// number of independent answers to pick the best one from, only used by QueryAgent with a problem
+	Candidates int `form:"candidates"`
+	// corrections go to the requested agent only, set for comparisons where another agent's answer would mislead
+	withoutFallback bool
 }
 
+// agent is the name of the agent which answered, it differs from the requested one after a failover.
+// corrections counts follow-up queries sent because the code did not pass verification,
+// verificationIssue describes what is still wrong once no corrections are left.
+// diff is a unified diff from the submitted code to the response, empty when the agent changed nothing.
+// mergedDeclarations names declarations taken from the answer in merge mode.
+// candidates summarises every generated candidate when the best of several was selected
 type Response struct {
-	Response string `json:"response"`
+	Response           string             `json:"response"`
+	Agent              string             `json:"agent,omitempty"`
+	Corrections        int                `json:"corrections"`
+	VerificationIssue  string             `json:"verificationIssue,omitempty"`
+	Diff               string             `json:"diff,omitempty"`
+	MergedDeclarations []string           `json:"mergedDeclarations,omitempty"`
+	Candidates         []CandidateSummary `json:"candidates,omitempty"`
+	// estimated tokens of correction queries and their answers, the first round is not included
+	correctionTokens tokenUsage
 }
 
-type AIAgents struct {
-	Chatgpt ChatgptAgentWrapperInterface
-	Gemini  GeminiAgentWrapperInterface
+type tokenUsage struct {
+	prompt   int
+	response int
+}
+
+type StreamDelta struct {
+	Text string `json:"text"`
 }
 
 type QueryHandler struct {
-	Agents AIAgents
+	Agents      *AgentRegistry
+	Prompts     PromptProvider
+	Experiments ExperimentProvider
+	Verifier    *CodeVerifier
+	Selector    *CandidateSelector
 }
 
-func NewQueryHandler(agents AIAgents) *QueryHandler {
+func NewQueryHandler(agents *AgentRegistry) *QueryHandler {
 	return &QueryHandler{
 		Agents: agents,
 	}
 }
 
+// prompts are selected from the provider per problem and language, built-in prompt is used without it
+func NewQueryHandlerWithPrompts(agents *AgentRegistry, prompts PromptProvider) *QueryHandler {
+	return &QueryHandler{
+		Agents:  agents,
+		Prompts: prompts,
+	}
+}
+
+// sessions taking part in an active experiment get the prompt of their variant instead of the active prompt
+func NewQueryHandlerWithExperiments(agents *AgentRegistry, prompts PromptProvider, experiments ExperimentProvider) *QueryHandler {
+	return &QueryHandler{
+		Agents:      agents,
+		Prompts:     prompts,
+		Experiments: experiments,
+	}
+}
+
 var systemPrompt = `<systemPrompt>
 You are an expert programmer. I need you to code solutions to programming problems. I will provide three inputs: programming language, 
 current code and my own description. Description is written by me and should guide your actions. Respond only with code: no explanations, 
@@ -39,13 +90,13 @@ external modules or packages.
 </systemPrompt>`
 
 var userPromptTemplate = `<description>
-%s
+{{.Description}}
 </description>
 <programmingLanguage>
-%s
+{{.Language}}
 </programmingLanguage>
 <code>
-%s
+{{.Code}}
 </code>`
 
 const (
@@ -53,51 +104,301 @@ const (
 	CHATGPT = "chatgpt"
 )
 
-func (handler *QueryHandler) QueryAgent(sessionId string, requestBody Request) (string, error) {
-	userQuery := fmt.Sprintf(userPromptTemplate, requestBody.Input, requestBody.Language, requestBody.Code)
-	response, err := handler.dispatchToAgent(requestBody.Agent, sessionId, userQuery)
+func (handler *QueryHandler) QueryAgent(ctx context.Context, sessionId string, requestBody Request) (*Response, error) {
+	prompt, assignment, err := handler.selectPrompt(sessionId, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	userQuery, err := buildUserQuery(prompt, requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	if requestBody.Candidates > 1 && handler.Selector != nil {
+		response, err := handler.queryCandidates(ctx, sessionId, requestBody, prompt, userQuery)
+		if err != nil {
+			return nil, err
+		}
+		handler.recordQuery(sessionId, response.Agent, requestBody, prompt, assignment)
+		return response, nil
+	}
+
+	response, agentName, err := handler.dispatchToAgent(ctx, requestBody.Agent, sessionId, userQuery, prompt.SystemPrompt)
+	if err != nil {
+		return nil, fmt.Errorf("error querying agent: %w", err)
+	}
+	handler.recordQuery(sessionId, agentName, requestBody, prompt, assignment)
+	return handler.buildResponse(ctx, sessionId, requestBody, prompt, response, agentName)
+}
+
+// onDelta receives raw pieces of the agent response as they arrive, returned code is post-processed
+func (handler *QueryHandler) StreamAgent(ctx context.Context, sessionId string, requestBody Request, onDelta func(string)) (*Response, error) {
+	prompt, assignment, err := handler.selectPrompt(sessionId, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	userQuery, err := buildUserQuery(prompt, requestBody)
+	if err != nil {
+		return nil, err
+	}
+
+	response, agentName, err := handler.dispatchStreamToAgent(ctx, requestBody.Agent, sessionId, userQuery, prompt.SystemPrompt, onDelta)
 	if err != nil {
-		return "", fmt.Errorf("error querying agent: %w", err)
+		return nil, fmt.Errorf("error streaming agent: %w", err)
 	}
-	return postProcessResponse(response), nil
+	handler.recordQuery(sessionId, agentName, requestBody, prompt, assignment)
+	return handler.buildResponse(ctx, sessionId, requestBody, prompt, response, agentName)
+}
+
+// answers are verified when a verifier is set
+func (handler *QueryHandler) SetVerifier(verifier *CodeVerifier) {
+	handler.Verifier = verifier
+}
+
+// requests with several candidates are answered with the best of them when a selector is set
+func (handler *QueryHandler) SetCandidateSelector(selector *CandidateSelector) {
+	handler.Selector = selector
 }
 
-func (handler *QueryHandler) dispatchToAgent(agent, sessionId, userQuery string) (string, error) {
-	switch agent {
-	case CHATGPT:
-		return handler.Agents.Chatgpt.QueryWithContext(sessionId, userQuery, systemPrompt)
-	case GEMINI:
-		return handler.Agents.Gemini.QueryWithContext(sessionId, userQuery, systemPrompt)
-	default:
-		return "", fmt.Errorf("agent of type %s does not exist", agent)
+// returned assignment is nil when the session is not part of an experiment
+func (handler *QueryHandler) selectPrompt(sessionId string, requestBody Request) (*PromptTemplate, *ExperimentAssignment, error) {
+	if handler.Experiments != nil {
+		assignment, err := handler.Experiments.AssignVariant(sessionId)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error assigning experiment variant: %w", err)
+		}
+		if assignment != nil {
+			return assignment.Prompt, assignment, nil
+		}
 	}
+	prompt, err := handler.activePrompt(requestBody)
+	return prompt, nil, err
 }
 
-func postProcessResponse(aiOutput string) string {
-	if isGoMarkdownFormat(aiOutput) {
-		aiOutput, _ = strings.CutPrefix(aiOutput, "```go")
-		aiOutput, _ = strings.CutSuffix(aiOutput, "```")
+func (handler *QueryHandler) activePrompt(requestBody Request) (*PromptTemplate, error) {
+	if handler.Prompts == nil {
+		return &defaultPrompt, nil
 	}
-	if isCppMarkdownFormat(aiOutput) {
-		aiOutput, _ = strings.CutPrefix(aiOutput, "```cpp")
-		aiOutput, _ = strings.CutSuffix(aiOutput, "```")
+
+	prompt, err := handler.Prompts.GetActivePrompt(requestBody.ProblemId, requestBody.Language)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting prompt: %w", err)
 	}
-	if isCodeXML(aiOutput) {
-		aiOutput, _ = strings.CutPrefix(aiOutput, "<code>")
-		aiOutput, _ = strings.CutSuffix(aiOutput, "</code>")
+	if prompt == nil {
+		return &defaultPrompt, nil
 	}
-	aiOutput = strings.TrimSpace(aiOutput)
-	return aiOutput
+	return prompt, nil
 }
 
-func isCodeXML(str string) bool {
-	return strings.HasPrefix(str, "<code>") && strings.HasSuffix(str, "</code>")
+// answer was already received, so a failed recording is only logged. agent is the one which answered,
+// so usage after a failover is recorded for the fallback agent
+func (handler *QueryHandler) recordQuery(sessionId, agentName string, requestBody Request, prompt *PromptTemplate, assignment *ExperimentAssignment) {
+	if handler.Prompts != nil {
+		err := handler.Prompts.RecordPromptUsage(sessionId, agentName, requestBody.ProblemId, prompt)
+		if err != nil {
+			fmt.Printf("Session %s: %v\n", sessionId, err)
+		}
+	}
+	if assignment != nil {
+		err := handler.Experiments.RecordExperimentQuery(sessionId, requestBody.ProblemId, assignment)
+		if err != nil {
+			fmt.Printf("Session %s: %v\n", sessionId, err)
+		}
+	}
 }
 
-func isGoMarkdownFormat(str string) bool {
-	return strings.HasPrefix(str, "```go") && strings.HasSuffix(str, "```")
+// marks the problem as solved in the active experiment when every test passed
+func (handler *QueryHandler) RecordOutcome(sessionId string, problemId int, validation *validator.Response) error {
+	if handler.Experiments == nil || sessionId == "" || !isSolved(validation) {
+		return nil
+	}
+	assignment, err := handler.Experiments.AssignVariant(sessionId)
+	if err != nil {
+		return fmt.Errorf("error recording outcome: %w", err)
+	}
+	if assignment == nil {
+		return nil
+	}
+	err = handler.Experiments.RecordSolved(sessionId, problemId, assignment)
+	if err != nil {
+		return fmt.Errorf("error recording outcome: %w", err)
+	}
+	return nil
 }
 
-func isCppMarkdownFormat(str string) bool {
-	return strings.HasPrefix(str, "```cpp") && strings.HasSuffix(str, "```")
+func (handler *QueryHandler) buildResponse(ctx context.Context, sessionId string, requestBody Request, prompt *PromptTemplate, output, agentName string) (*Response, error) {
+	code, merged, err := prepareCode(sessionId, output, requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("agent %s: %w", agentName, err)
+	}
+	response := &Response{
+		Response:           code,
+		Agent:              agentName,
+		MergedDeclarations: merged,
+	}
+	if handler.Verifier != nil {
+		response, err = handler.correctCode(ctx, sessionId, requestBody, prompt, response)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	response.Response = formatCode(response.Response, requestBody.Language)
+	response.Diff = unifiedDiff(requestBody.Code, response.Response)
+	return response, nil
+}
+
+// the session history holds the rejected code, so the agent is only told what is wrong with it.
+// corrections go to the agent which answered, since it wrote the code
+func (handler *QueryHandler) correctCode(ctx context.Context, sessionId string, requestBody Request, prompt *PromptTemplate, response *Response) (*Response, error) {
+	for {
+		issue, err := handler.Verifier.Verify(response.Response, requestBody.Language, requestBody.ProblemId)
+		if err != nil {
+			// answer may still be fine, so it is returned unverified
+			fmt.Printf("Session %s: %v\n", sessionId, err)
+			return response, nil
+		}
+		if issue == "" {
+			response.VerificationIssue = ""
+			return response, nil
+		}
+		response.VerificationIssue = issue
+		if response.Corrections >= handler.Verifier.MaxCorrections {
+			fmt.Printf("Session %s: code still not valid after %d corrections: %s\n", sessionId, response.Corrections, issue)
+			return response, nil
+		}
+
+		response.Corrections++
+		correctionQuery := fmt.Sprintf(correctionTemplate, issue)
+		response.correctionTokens.prompt += estimateTokens(prompt.SystemPrompt) + estimateTokens(correctionQuery)
+		dispatch := handler.dispatchToAgent
+		if requestBody.withoutFallback {
+			dispatch = handler.queryNamedAgent
+		}
+		output, agentName, err := dispatch(ctx, response.Agent, sessionId, correctionQuery, prompt.SystemPrompt)
+		if err != nil {
+			return failedCorrection(ctx, sessionId, response, fmt.Errorf("error querying agent for correction %d: %w", response.Corrections, err))
+		}
+		response.correctionTokens.response += estimateTokens(output)
+		code, merged, err := prepareCode(sessionId, output, requestBody)
+		if err != nil {
+			return failedCorrection(ctx, sessionId, response, fmt.Errorf("agent %s correction %d: %w", agentName, response.Corrections, err))
+		}
+		response.Response = code
+		response.Agent = agentName
+		response.MergedDeclarations = merged
+	}
+}
+
+// the last answer is still useful with its issue, unless the client is gone
+func failedCorrection(ctx context.Context, sessionId string, response *Response, err error) (*Response, error) {
+	if ctx.Err() != nil {
+		return nil, err
+	}
+	fmt.Printf("Session %s: %v\n", sessionId, err)
+	return response, nil
+}
+
+// in merge mode the answer is merged into the submitted code, so declarations the agent left out are kept
+func prepareCode(sessionId, output string, requestBody Request) (string, []string, error) {
+	code, err := extractCode(output, requestBody.Language)
+	if err != nil {
+		return "", nil, err
+	}
+	if !requestBody.Merge || !isGoLanguage(requestBody.Language) || strings.TrimSpace(requestBody.Code) == "" {
+		return code, nil, nil
+	}
+
+	merged, declarations, err := mergeCode(requestBody.Code, code)
+	if err != nil {
+		// code which does not parse is left to verification, so the answer is used as it is
+		fmt.Printf("Session %s: %v\n", sessionId, err)
+		return code, nil, nil
+	}
+	return merged, declarations, nil
+}
+
+func buildUserQuery(prompt *PromptTemplate, requestBody Request) (string, error) {
+	userQuery, err := renderUserQuery(prompt.UserTemplate, promptData{
+		Description: requestBody.Input,
+		Language:    requestBody.Language,
+		Code:        buildCodeContext(requestBody.Code, requestBody.Files),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error building query with prompt %d version %d: %w", prompt.Id, prompt.Version, err)
+	}
+	return userQuery, nil
+}
+
+// the fallback agent is queried when the requested one is unavailable, returns name of the agent which answered
+func (handler *QueryHandler) dispatchToAgent(ctx context.Context, agentName, sessionId, userQuery, systemPrompt string) (string, string, error) {
+	agent, err := handler.Agents.Get(agentName)
+	if err != nil {
+		return "", "", err
+	}
+	output, err := agent.QueryWithContext(ctx, sessionId, userQuery, systemPrompt)
+
+	fallbackName := handler.Agents.Fallback(agentName)
+	if err == nil || fallbackName == "" || !errors.Is(err, ErrAgentUnavailable) {
+		return output, agentName, err
+	}
+	fallback, err := handler.failover(agentName, fallbackName, err)
+	if err != nil {
+		return "", "", err
+	}
+	output, err = fallback.QueryWithContext(ctx, sessionId, userQuery, systemPrompt)
+	return output, fallbackName, err
+}
+
+// same as dispatchToAgent without failing over, so the answer always comes from the named agent
+func (handler *QueryHandler) queryNamedAgent(ctx context.Context, agentName, sessionId, userQuery, systemPrompt string) (string, string, error) {
+	agent, err := handler.Agents.Get(agentName)
+	if err != nil {
+		return "", "", err
+	}
+	output, err := agent.QueryWithContext(ctx, sessionId, userQuery, systemPrompt)
+	return output, agentName, err
+}
+
+// stream only fails over when no piece of the response was sent yet
+func (handler *QueryHandler) dispatchStreamToAgent(ctx context.Context, agentName, sessionId, userQuery, systemPrompt string, onDelta func(string)) (string, string, error) {
+	agent, err := handler.Agents.Get(agentName)
+	if err != nil {
+		return "", "", err
+	}
+	started := false
+	output, err := agent.StreamWithContext(ctx, sessionId, userQuery, systemPrompt, func(delta string) {
+		started = true
+		onDelta(delta)
+	})
+
+	fallbackName := handler.Agents.Fallback(agentName)
+	if err == nil || fallbackName == "" || started || !errors.Is(err, ErrAgentUnavailable) {
+		return output, agentName, err
+	}
+	fallback, err := handler.failover(agentName, fallbackName, err)
+	if err != nil {
+		return "", "", err
+	}
+	output, err = fallback.StreamWithContext(ctx, sessionId, userQuery, systemPrompt, onDelta)
+	return output, fallbackName, err
+}
+
+func (handler *QueryHandler) failover(agentName, fallbackName string, agentErr error) (Agent, error) {
+	fmt.Printf("Agent %s is unavailable, falling back to %s: %v\n", agentName, fallbackName, agentErr)
+	fallback, err := handler.Agents.Get(fallbackName)
+	if err != nil {
+		return nil, fmt.Errorf("could not get fallback agent: %w", err)
+	}
+	return fallback, nil
+}
+
+// additional files are sent after the current code so the agent can use them as context
+func buildCodeContext(code string, files []common.SourceFile) string {
+	var builder strings.Builder
+	builder.WriteString(code)
+	for _, file := range files {
+		fmt.Fprintf(&builder, "\n<file name=\"%s\">\n%s\n</file>", file.Name, file.Code)
+	}
+	return builder.String()
 }
@@ -1,31 +1,64 @@
 package query
 
 import (
+	"context"
+	"errors"
+	"serious-fin/api/common"
 	"testing"
 )
 
+type mockAgent struct {
+	QueryWithContextFunc  func(sessionId, userQuery, systemPrompt string) (string, error)
+	StreamWithContextFunc func(sessionId, userQuery, systemPrompt string, onDelta func(string)) (string, error)
+}
+
+func (mockAgent *mockAgent) QueryWithContext(ctx context.Context, sessionId, userQuery, systemPrompt string) (string, error) {
+	if mockAgent.QueryWithContextFunc != nil {
+		return mockAgent.QueryWithContextFunc(sessionId, userQuery, systemPrompt)
+	}
+	return "", nil
+}
+
+func (mockAgent *mockAgent) StreamWithContext(ctx context.Context, sessionId, userQuery, systemPrompt string, onDelta func(string)) (string, error) {
+	if mockAgent.StreamWithContextFunc != nil {
+		return mockAgent.StreamWithContextFunc(sessionId, userQuery, systemPrompt, onDelta)
+	}
+	return "", nil
+}
+
+func newTestRegistry(chatgpt, gemini Agent) *AgentRegistry {
+	registry := NewAgentRegistry()
+	registry.Register(AgentInfo{Name: CHATGPT}, chatgpt)
+	registry.Register(AgentInfo{Name: GEMINI}, gemini)
+	return registry
+}
+
+// user query built with the built-in prompt
+func defaultUserQuery(description, language, code string) string {
+	userQuery, _ := renderUserQuery(userPromptTemplate, promptData{Description: description, Language: language, Code: code})
+	return userQuery
+}
+
 func TestShouldInvokeChatgpt(t *testing.T) {
 	want := "test code"
-	mockChatgptClient := &mockChatgptAgentWrapper{
+	mockChatgptClient := &mockAgent{
 		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
 			return want, nil
 		},
 	}
 
-	queryHandler := NewQueryHandler(AIAgents{
-		Chatgpt: mockChatgptClient,
-		Gemini:  &mockGeminiAgentWrapper{},
-	})
+	queryHandler := NewQueryHandler(newTestRegistry(mockChatgptClient, &mockAgent{}))
 
-	got, err := queryHandler.QueryAgent("1", Request{
+	response, err := queryHandler.QueryAgent(context.Background(), "1", Request{
 		Input:    "input",
 		Code:     "code",
 		Language: "lang",
 		Agent:    CHATGPT,
 	})
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Fatalf("unexpected error: %v", err)
 	}
+	got := response.Response
 	if got != want {
 		t.Errorf("got %s, want %s", got, want)
 	}
@@ -33,43 +66,79 @@ func TestShouldInvokeChatgpt(t *testing.T) {
 
 func TestShouldInvokeGemini(t *testing.T) {
 	want := "test code"
-	mockGeminiClient := &mockGeminiAgentWrapper{
+	mockGeminiClient := &mockAgent{
 		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
 			return want, nil
 		},
 	}
 
-	queryHandler := NewQueryHandler(AIAgents{
-		Chatgpt: &mockChatgptAgentWrapper{},
-		Gemini:  mockGeminiClient,
-	})
+	queryHandler := NewQueryHandler(newTestRegistry(&mockAgent{}, mockGeminiClient))
 
-	got, err := queryHandler.QueryAgent("1", Request{
+	response, err := queryHandler.QueryAgent(context.Background(), "1", Request{
 		Input:    "input",
 		Code:     "code",
 		Language: "lang",
 		Agent:    GEMINI,
 	})
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Fatalf("unexpected error: %v", err)
 	}
+	got := response.Response
 	if got != want {
 		t.Errorf("got %s, want %s", got, want)
 	}
 }
 
 func TestShouldThrowOnUnrecognizedAgent(t *testing.T) {
-	queryHandler := NewQueryHandler(AIAgents{
-		Chatgpt: &mockChatgptAgentWrapper{},
-		Gemini:  &mockGeminiAgentWrapper{},
-	})
+	queryHandler := NewQueryHandler(newTestRegistry(&mockAgent{}, &mockAgent{}))
 
-	_, err := queryHandler.QueryAgent("1", Request{
+	_, err := queryHandler.QueryAgent(context.Background(), "1", Request{
 		Input:    "input",
 		Code:     "code",
 		Language: "lang",
 		Agent:    "unknown",
 	})
+	if !errors.Is(err, ErrUnknownAgent) {
+		t.Errorf("got error %v, want %v", err, ErrUnknownAgent)
+	}
+}
+
+func TestShouldSendAdditionalFilesAsContext(t *testing.T) {
+	var gotQuery string
+	mockGeminiClient := &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			gotQuery = userQuery
+			return "answer", nil
+		},
+	}
+
+	queryHandler := NewQueryHandler(newTestRegistry(&mockAgent{}, mockGeminiClient))
+
+	_, err := queryHandler.QueryAgent(context.Background(), "1", Request{
+		Input: "input",
+		Code:  "code",
+		Files: []common.SourceFile{
+			{Name: "heap.go", Code: "heap code"},
+			{Name: "types.go", Code: "types code"},
+		},
+		Language: "lang",
+		Agent:    GEMINI,
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	wantCode := "code\n<file name=\"heap.go\">\nheap code\n</file>\n<file name=\"types.go\">\ntypes code\n</file>"
+	want := defaultUserQuery("input", "lang", wantCode)
+	if gotQuery != want {
+		t.Errorf("got %s, want %s", gotQuery, want)
+	}
+}
+
+func TestShouldThrowOnUnrecognizedAgentWhenStreaming(t *testing.T) {
+	queryHandler := NewQueryHandler(newTestRegistry(&mockAgent{}, &mockAgent{}))
+
+	_, err := queryHandler.StreamAgent(context.Background(), "1", Request{Agent: "unknown"}, func(string) {})
 	if err == nil {
 		t.Error("expected to get error, but did not get any")
 	}
@@ -101,27 +170,44 @@ func TestShouldTrimSpace(t *testing.T) {
 	ExecuteAndExpectText(t, aiOutput, want)
 }
 
+func TestShouldExtractCodeFromProse(t *testing.T) {
+	aiOutput := "Sure, here you go:\n```golang\ntest func\n```\nLet me know if it works."
+	want := "test func"
+	ExecuteAndExpectText(t, aiOutput, want)
+}
+
+func TestShouldFailWhenResponseHasNoCode(t *testing.T) {
+	queryHandler := NewQueryHandler(newTestRegistry(&mockAgent{}, &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			return "```go\n```", nil
+		},
+	}))
+
+	_, err := queryHandler.QueryAgent(context.Background(), "1", Request{Language: "go", Agent: GEMINI})
+	if !errors.Is(err, ErrNoCodeInResponse) {
+		t.Errorf("got error %v, want %v", err, ErrNoCodeInResponse)
+	}
+}
+
 func ExecuteAndExpectText(t *testing.T, aiOutput, want string) {
-	mockGeminiClient := &mockGeminiAgentWrapper{
+	mockGeminiClient := &mockAgent{
 		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
 			return aiOutput, nil
 		},
 	}
 
-	queryHandler := NewQueryHandler(AIAgents{
-		Chatgpt: &mockChatgptAgentWrapper{},
-		Gemini:  mockGeminiClient,
-	})
+	queryHandler := NewQueryHandler(newTestRegistry(&mockAgent{}, mockGeminiClient))
 
-	got, err := queryHandler.QueryAgent("1", Request{
+	response, err := queryHandler.QueryAgent(context.Background(), "1", Request{
 		Input:    "input",
 		Code:     "code",
 		Language: "lang",
 		Agent:    GEMINI,
 	})
 	if err != nil {
-		t.Errorf("unexpected error: %v", err)
+		t.Fatalf("unexpected error: %v", err)
 	}
+	got := response.Response
 	if got != want {
 		t.Errorf("got %s, want %s", got, want)
 	}
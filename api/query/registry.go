@@ -0,0 +1,287 @@
+package query
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	gemini "google.golang.org/genai"
+)
+
+var ErrUnknownAgent = errors.New("unknown agent")
+
+type Agent interface {
+	QueryWithContext(ctx context.Context, sessionId, userQuery, systemPrompt string) (string, error)
+	StreamWithContext(ctx context.Context, sessionId, userQuery, systemPrompt string, onDelta func(string)) (string, error)
+}
+
+type AgentInfo struct {
+	Name     string `json:"name"`
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+}
+
+// single agent entry of the configuration file. apiKeyEnv is the name of environment variable holding the key,
+// responses are only used by the fake provider. contextTokens overrides the known context window of the model.
+// fallback is the name of an agent answering instead when this one is unavailable
+type AgentConfig struct {
+	Name          string       `json:"name"`
+	Provider      string       `json:"provider"`
+	Model         string       `json:"model"`
+	APIKeyEnv     string       `json:"apiKeyEnv"`
+	BaseURL       string       `json:"baseUrl,omitempty"`
+	Responses     []string     `json:"responses,omitempty"`
+	ContextTokens int          `json:"contextTokens,omitempty"`
+	Retry         *RetryPolicy `json:"retry,omitempty"`
+	Fallback      string       `json:"fallback,omitempty"`
+	// limit for a whole call including retries, default is used when not set
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+}
+
+// history is the cache shared by agents created from configuration, nil for registries built by hand.
+// candidates are sessions whose answers are kept out of that history
+type AgentRegistry struct {
+	agents     map[string]Agent
+	infos      map[string]AgentInfo
+	fallbacks  map[string]string
+	history    CacheInterface
+	candidates *candidateSessions
+}
+
+const (
+	ProviderOpenAI           = "openai"
+	ProviderOpenAICompatible = "openai-compatible"
+	ProviderGemini           = "gemini"
+	ProviderAnthropic        = "anthropic"
+	ProviderFake             = "fake"
+)
+
+// used when no configuration file is provided
+var DefaultAgentConfigs = []AgentConfig{
+	{
+		Name:      CHATGPT,
+		Provider:  ProviderOpenAI,
+		Model:     openai.GPT3Dot5Turbo,
+		APIKeyEnv: "CHATGPT_KEY",
+	},
+	{
+		Name:      GEMINI,
+		Provider:  ProviderGemini,
+		Model:     "gemini-2.5-flash",
+		APIKeyEnv: "GEMINI_KEY",
+	},
+}
+
+// used in development mode when no configuration file is provided, keeps the default agent names
+// so the frontend works unchanged without any API keys
+var DevAgentConfigs = []AgentConfig{
+	{
+		Name:     CHATGPT,
+		Provider: ProviderFake,
+		Model:    "script",
+	},
+	{
+		Name:     GEMINI,
+		Provider: ProviderFake,
+		Model:    "script",
+	},
+}
+
+func NewAgentRegistry() *AgentRegistry {
+	return &AgentRegistry{
+		agents:     make(map[string]Agent),
+		infos:      make(map[string]AgentInfo),
+		fallbacks:  make(map[string]string),
+		candidates: newCandidateSessions(),
+	}
+}
+
+func (registry *AgentRegistry) Register(info AgentInfo, agent Agent) error {
+	if info.Name == "" {
+		return fmt.Errorf("agent name can not be empty")
+	}
+	if _, exists := registry.agents[info.Name]; exists {
+		return fmt.Errorf("agent with name %s is already registered", info.Name)
+	}
+	registry.agents[info.Name] = agent
+	registry.infos[info.Name] = info
+	return nil
+}
+
+func (registry *AgentRegistry) Get(name string) (Agent, error) {
+	agent, exists := registry.agents[name]
+	if !exists {
+		return nil, fmt.Errorf("agent of type %s does not exist: %w", name, ErrUnknownAgent)
+	}
+	return agent, nil
+}
+
+// both agents have to be registered already
+func (registry *AgentRegistry) SetFallback(name, fallback string) error {
+	if name == fallback {
+		return fmt.Errorf("agent %s can not be its own fallback", name)
+	}
+	if _, exists := registry.agents[name]; !exists {
+		return fmt.Errorf("agent %s is not registered", name)
+	}
+	if _, exists := registry.agents[fallback]; !exists {
+		return fmt.Errorf("fallback agent %s of agent %s is not registered", fallback, name)
+	}
+	registry.fallbacks[name] = fallback
+	return nil
+}
+
+// returns empty string when the agent has no fallback
+func (registry *AgentRegistry) Fallback(name string) string {
+	return registry.fallbacks[name]
+}
+
+// agents are sorted by name so the listing is stable
+func (registry *AgentRegistry) List() []AgentInfo {
+	infos := make([]AgentInfo, 0, len(registry.infos))
+	for _, info := range registry.infos {
+		infos = append(infos, info)
+	}
+	slices.SortFunc(infos, func(a, b AgentInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return infos
+}
+
+func LoadAgentConfigs(filePath string) ([]AgentConfig, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not read agent configuration file \"%s\": %w", filePath, err)
+	}
+
+	var configs []AgentConfig
+	err = json.Unmarshal(content, &configs)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal agent configuration: %w", err)
+	}
+	return configs, nil
+}
+
+// hosted providers always need a key. key of other providers is only checked when apiKeyEnv is set
+func CheckAgentCredentials(configs []AgentConfig) error {
+	for _, config := range configs {
+		requiresKey := config.Provider == ProviderOpenAI || config.Provider == ProviderGemini || config.Provider == ProviderAnthropic
+		if !requiresKey && config.APIKeyEnv == "" {
+			continue
+		}
+		if config.APIKeyEnv == "" {
+			return fmt.Errorf("agent %s of provider %s requires apiKeyEnv to be set", config.Name, config.Provider)
+		}
+		if os.Getenv(config.APIKeyEnv) == "" {
+			return fmt.Errorf("%s environment variable required by agent %s is not set", config.APIKeyEnv, config.Name)
+		}
+	}
+	return nil
+}
+
+func NewAgentRegistryFromConfig(configs []AgentConfig, cache CacheInterface, ctx context.Context) (*AgentRegistry, error) {
+	registry := NewAgentRegistry()
+	registry.history = cache
+	for _, config := range configs {
+		agent, err := newAgentFromConfig(config, newAgentCache(cache, config, registry.candidates), ctx)
+		if err != nil {
+			return nil, fmt.Errorf("could not create agent %s: %w", config.Name, err)
+		}
+
+		err = registry.Register(AgentInfo{
+			Name:     config.Name,
+			Provider: config.Provider,
+			Model:    config.Model,
+		}, newResilientAgent(agent, config))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := setFallbacks(registry, configs)
+	if err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// fallbacks are set once all agents are registered, so an agent can fall back to one defined after it
+func setFallbacks(registry *AgentRegistry, configs []AgentConfig) error {
+	for _, config := range configs {
+		if config.Fallback == "" {
+			continue
+		}
+		err := registry.SetFallback(config.Name, config.Fallback)
+		if err != nil {
+			return fmt.Errorf("could not set fallback: %w", err)
+		}
+	}
+	return nil
+}
+
+func newResilientAgent(agent Agent, config AgentConfig) *ResilientAgent {
+	return NewResilientAgentWithTimeout(agent, retryPolicy(config), NewCircuitBreaker(defaultBreakerThreshold, defaultBreakerCooldown), agentTimeout(config))
+}
+
+func retryPolicy(config AgentConfig) RetryPolicy {
+	if config.Retry == nil {
+		return DefaultRetryPolicy
+	}
+	return *config.Retry
+}
+
+func newAgentCache(cache CacheInterface, config AgentConfig, candidates *candidateSessions) CacheInterface {
+	return NewTokenBudgetCache(candidateCache{cache, candidates}, contextTokenLimit(config))
+}
+
+func agentTimeout(config AgentConfig) time.Duration {
+	if config.TimeoutSeconds <= 0 {
+		return defaultAgentTimeout
+	}
+	return time.Duration(config.TimeoutSeconds) * time.Second
+}
+
+func newAgentFromConfig(config AgentConfig, cache CacheInterface, ctx context.Context) (Agent, error) {
+	apiKey := os.Getenv(config.APIKeyEnv)
+	switch config.Provider {
+	case ProviderOpenAI:
+		return NewChatgptClientWrapper(openai.NewClient(apiKey), config.Model, cache), nil
+	case ProviderOpenAICompatible:
+		if config.BaseURL == "" {
+			return nil, fmt.Errorf("base url is required for provider %s", config.Provider)
+		}
+		return NewOpenAICompatibleAgent(config.BaseURL, config.Model, apiKey, cache), nil
+	case ProviderGemini:
+		client, err := gemini.NewClient(ctx, &gemini.ClientConfig{
+			APIKey:  apiKey,
+			Backend: gemini.BackendGeminiAPI,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error creating gemini client: %w", err)
+		}
+		return NewGeminiAgentWrapper(client, config.Model, cache), nil
+	case ProviderAnthropic:
+		baseURL := config.BaseURL
+		if baseURL == "" {
+			baseURL = AnthropicDefaultBaseURL
+		}
+		return NewAnthropicAgentWrapper(&http.Client{}, baseURL, apiKey, config.Model, cache), nil
+	case ProviderFake:
+		if config.Model != FakeModelEcho {
+			return NewFakeAgent(config.Responses, cache), nil
+		}
+		if len(config.Responses) > 0 {
+			return nil, fmt.Errorf("responses can not be used with the %s model", FakeModelEcho)
+		}
+		return NewEchoFakeAgent(cache), nil
+	default:
+		return nil, fmt.Errorf("unknown provider %s", config.Provider)
+	}
+}
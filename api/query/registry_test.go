@@ -0,0 +1,179 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRegistryGetRegisteredAgent(t *testing.T) {
+	agent := &mockAgent{}
+	registry := NewAgentRegistry()
+	if err := registry.Register(AgentInfo{Name: "foo"}, agent); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := registry.Get("foo")
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if got != agent {
+		t.Errorf("got %v, want %v", got, agent)
+	}
+}
+
+func TestRegistryGetUnknownAgent(t *testing.T) {
+	registry := NewAgentRegistry()
+	_, err := registry.Get("foo")
+	if !errors.Is(err, ErrUnknownAgent) {
+		t.Errorf("got error %v, want %v", err, ErrUnknownAgent)
+	}
+}
+
+func TestRegistryRejectsDuplicateNames(t *testing.T) {
+	registry := NewAgentRegistry()
+	registry.Register(AgentInfo{Name: "foo"}, &mockAgent{})
+	if err := registry.Register(AgentInfo{Name: "foo"}, &mockAgent{}); err == nil {
+		t.Error("expected error when registering agent with duplicate name")
+	}
+}
+
+func TestRegistryRejectsEmptyName(t *testing.T) {
+	registry := NewAgentRegistry()
+	if err := registry.Register(AgentInfo{}, &mockAgent{}); err == nil {
+		t.Error("expected error when registering agent without name")
+	}
+}
+
+func TestRegistryListIsSortedByName(t *testing.T) {
+	registry := NewAgentRegistry()
+	registry.Register(AgentInfo{Name: "b", Provider: ProviderGemini, Model: "model-b"}, &mockAgent{})
+	registry.Register(AgentInfo{Name: "a", Provider: ProviderOpenAI, Model: "model-a"}, &mockAgent{})
+
+	want := []AgentInfo{
+		{Name: "a", Provider: ProviderOpenAI, Model: "model-a"},
+		{Name: "b", Provider: ProviderGemini, Model: "model-b"},
+	}
+	if got := registry.List(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLoadAgentConfigs(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "agents.json")
+	content := `[{"name": "gpt4", "provider": "openai", "model": "gpt-4o", "apiKeyEnv": "CHATGPT_KEY"}]`
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		t.Fatalf("could not write config file: %v", err)
+	}
+
+	got, err := LoadAgentConfigs(filePath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []AgentConfig{{Name: "gpt4", Provider: ProviderOpenAI, Model: "gpt-4o", APIKeyEnv: "CHATGPT_KEY"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLoadAgentConfigsMissingFile(t *testing.T) {
+	if _, err := LoadAgentConfigs(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("expected error when configuration file does not exist")
+	}
+}
+
+func TestRegistryFromConfig(t *testing.T) {
+	configs := []AgentConfig{{Name: "gpt4", Provider: ProviderOpenAI, Model: "gpt-4o"}}
+	registry, err := NewAgentRegistryFromConfig(configs, &mockCache{}, context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := registry.Get("gpt4"); err != nil {
+		t.Errorf("expected configured agent to be registered: %v", err)
+	}
+}
+
+func TestRegistryFromConfigUnknownProvider(t *testing.T) {
+	configs := []AgentConfig{{Name: "foo", Provider: "unknown"}}
+	if _, err := NewAgentRegistryFromConfig(configs, &mockCache{}, context.Background()); err == nil {
+		t.Error("expected error for unknown provider")
+	}
+}
+
+func TestRegistryFromConfigOpenAICompatibleRequiresBaseURL(t *testing.T) {
+	configs := []AgentConfig{{Name: "local", Provider: ProviderOpenAICompatible, Model: "llama3"}}
+	if _, err := NewAgentRegistryFromConfig(configs, &mockCache{}, context.Background()); err == nil {
+		t.Error("expected error when base url is missing")
+	}
+}
+
+func TestRegistryFromConfigEchoesOnlyWithEchoModel(t *testing.T) {
+	registry, err := NewAgentRegistryFromConfig([]AgentConfig{
+		{Name: "echo", Provider: ProviderFake, Model: FakeModelEcho},
+		{Name: "scripted", Provider: ProviderFake, Model: "script"},
+	}, &mockCache{}, context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for name, wantEcho := range map[string]bool{"echo": true, "scripted": false} {
+		agent, _ := registry.Get(name)
+		if fake := agent.(*ResilientAgent).Agent.(*FakeAgent); fake.Echo != wantEcho {
+			t.Errorf("got echo %v for agent %s, want %v", fake.Echo, name, wantEcho)
+		}
+	}
+
+	configs := []AgentConfig{{Name: "echo", Provider: ProviderFake, Model: FakeModelEcho, Responses: []string{"a"}}}
+	if _, err := NewAgentRegistryFromConfig(configs, &mockCache{}, context.Background()); err == nil {
+		t.Error("expected error for echo agent with responses")
+	}
+}
+
+func TestRegistryFromDevConfigNeedsNoKeys(t *testing.T) {
+	registry, err := NewAgentRegistryFromConfig(DevAgentConfigs, &mockCache{}, context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, name := range []string{CHATGPT, GEMINI} {
+		agent, err := registry.Get(name)
+		if err != nil {
+			t.Fatalf("expected dev agent %s to be registered: %v", name, err)
+		}
+		if _, ok := agent.(*ResilientAgent).Agent.(*FakeAgent); !ok {
+			t.Errorf("expected dev agent %s to be fake but got %T", name, agent)
+		}
+	}
+	if err := CheckAgentCredentials(DevAgentConfigs); err != nil {
+		t.Errorf("expected dev agents to need no credentials: %v", err)
+	}
+}
+
+func TestCheckAgentCredentials(t *testing.T) {
+	t.Setenv("TEST_SET_KEY", "secret")
+	t.Setenv("TEST_EMPTY_KEY", "")
+
+	tests := []struct {
+		name    string
+		config  AgentConfig
+		wantErr bool
+	}{
+		{"hosted provider with key", AgentConfig{Name: "a", Provider: ProviderOpenAI, APIKeyEnv: "TEST_SET_KEY"}, false},
+		{"hosted provider with missing key", AgentConfig{Name: "a", Provider: ProviderGemini, APIKeyEnv: "TEST_EMPTY_KEY"}, true},
+		{"hosted provider without key variable", AgentConfig{Name: "a", Provider: ProviderAnthropic}, true},
+		{"local server without key", AgentConfig{Name: "a", Provider: ProviderOpenAICompatible}, false},
+		{"local server with missing key", AgentConfig{Name: "a", Provider: ProviderOpenAICompatible, APIKeyEnv: "TEST_EMPTY_KEY"}, true},
+		{"fake agent", AgentConfig{Name: "a", Provider: ProviderFake}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckAgentCredentials([]AgentConfig{tt.config})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	gemini "google.golang.org/genai"
+)
+
+var ErrAgentUnavailable = errors.New("agent is unavailable")
+
+// delays are in milliseconds, so the policy can be written in the agent configuration file
+type RetryPolicy struct {
+	MaxAttempts int `json:"maxAttempts"`
+	BaseDelayMs int `json:"baseDelayMs"`
+	MaxDelayMs  int `json:"maxDelayMs"`
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelayMs: 500,
+	MaxDelayMs:  8000,
+}
+
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+	defaultAgentTimeout     = 120 * time.Second
+)
+
+// retries calls failing with errors which are likely to go away (rate limits, server errors, network errors)
+// and stops calling the agent for a while once it keeps failing.
+// timeout covers the whole call including retries, zero means the call is limited only by the request context
+type ResilientAgent struct {
+	Agent     Agent
+	Retry     RetryPolicy
+	Breaker   *CircuitBreaker
+	Timeout   time.Duration
+	sleepFunc func(context.Context, time.Duration) error
+}
+
+// opens after threshold consecutive failures. once cooldown passes a single trial call is let through,
+// which closes the breaker again if it succeeds
+type CircuitBreaker struct {
+	mu            sync.Mutex
+	threshold     int
+	cooldown      time.Duration
+	failures      int
+	openedAt      time.Time
+	trialInFlight bool
+	nowFunc       func() time.Time
+}
+
+func NewResilientAgent(agent Agent, retry RetryPolicy, breaker *CircuitBreaker) *ResilientAgent {
+	return NewResilientAgentWithTimeout(agent, retry, breaker, 0)
+}
+
+func NewResilientAgentWithTimeout(agent Agent, retry RetryPolicy, breaker *CircuitBreaker, timeout time.Duration) *ResilientAgent {
+	return &ResilientAgent{
+		Agent:     agent,
+		Retry:     retry,
+		Breaker:   breaker,
+		Timeout:   timeout,
+		sleepFunc: sleepWithContext,
+	}
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return NewCircuitBreakerWithTimeFunc(threshold, cooldown, time.Now)
+}
+
+// nowFunc - function which gets current time. time.Now() by default but can be overwritten for tests
+func NewCircuitBreakerWithTimeFunc(threshold int, cooldown time.Duration, nowFunc func() time.Time) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		nowFunc:   nowFunc,
+	}
+}
+
+func (agent *ResilientAgent) QueryWithContext(ctx context.Context, sessionId, userQuery, systemPrompt string) (string, error) {
+	return agent.call(ctx, func(ctx context.Context) (string, error) {
+		return agent.Agent.QueryWithContext(ctx, sessionId, userQuery, systemPrompt)
+	}, func() bool { return true })
+}
+
+// stream is only retried when nothing was passed to onDelta yet
+func (agent *ResilientAgent) StreamWithContext(ctx context.Context, sessionId, userQuery, systemPrompt string, onDelta func(string)) (string, error) {
+	started := false
+	return agent.call(ctx, func(ctx context.Context) (string, error) {
+		return agent.Agent.StreamWithContext(ctx, sessionId, userQuery, systemPrompt, func(delta string) {
+			started = true
+			onDelta(delta)
+		})
+	}, func() bool { return !started })
+}
+
+func (agent *ResilientAgent) call(parent context.Context, attempt func(context.Context) (string, error), canRetry func() bool) (string, error) {
+	ctx := parent
+	if agent.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, agent.Timeout)
+		defer cancel()
+	}
+
+	maxAttempts := max(agent.Retry.MaxAttempts, 1)
+	var err error
+	for attemptNumber := 1; attemptNumber <= maxAttempts; attemptNumber++ {
+		if !agent.Breaker.Allow() {
+			return "", fmt.Errorf("circuit breaker is open: %w", ErrAgentUnavailable)
+		}
+
+		var output string
+		output, err = attempt(ctx)
+		if err != nil && ctx.Err() != nil {
+			// the caller giving up says nothing about the agent's health, running out of the agent's own time does.
+			// clients do not always wrap the context error, so it is added here
+			if parent.Err() != nil {
+				agent.Breaker.RecordCancel()
+			} else {
+				agent.Breaker.RecordFailure()
+			}
+			return "", contextError(ctx, err)
+		}
+		if err == nil || !isRetryableError(err) {
+			// agent answered, even if with an error which will not go away by retrying
+			agent.Breaker.RecordSuccess()
+			return output, err
+		}
+		agent.Breaker.RecordFailure()
+		if !canRetry() {
+			return "", err
+		}
+		if attemptNumber < maxAttempts {
+			if sleepErr := agent.sleepFunc(ctx, agent.Retry.backoff(attemptNumber)); sleepErr != nil {
+				return "", contextError(ctx, err)
+			}
+		}
+	}
+	return "", fmt.Errorf("%w after %d attempts: %w", ErrAgentUnavailable, maxAttempts, err)
+}
+
+func contextError(ctx context.Context, err error) error {
+	if err == nil || errors.Is(err, ctx.Err()) {
+		return fmt.Errorf("agent call stopped: %w", ctx.Err())
+	}
+	return fmt.Errorf("agent call stopped: %w: %w", ctx.Err(), err)
+}
+
+func sleepWithContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// exponential backoff with jitter, delay is picked between half and full exponential delay
+func (policy RetryPolicy) backoff(attemptNumber int) time.Duration {
+	delay := time.Duration(policy.BaseDelayMs) * time.Millisecond << (attemptNumber - 1)
+	maxDelay := time.Duration(policy.MaxDelayMs) * time.Millisecond
+	if delay > maxDelay || delay <= 0 {
+		delay = maxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func (breaker *CircuitBreaker) Allow() bool {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	if breaker.failures < breaker.threshold {
+		return true
+	}
+	if breaker.trialInFlight || breaker.nowFunc().Sub(breaker.openedAt) < breaker.cooldown {
+		return false
+	}
+	breaker.trialInFlight = true
+	return true
+}
+
+func (breaker *CircuitBreaker) RecordSuccess() {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	breaker.failures = 0
+	breaker.trialInFlight = false
+}
+
+// lets another trial call through without changing the failure count
+func (breaker *CircuitBreaker) RecordCancel() {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	breaker.trialInFlight = false
+}
+
+func (breaker *CircuitBreaker) RecordFailure() {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	breaker.failures++
+	breaker.trialInFlight = false
+	if breaker.failures >= breaker.threshold {
+		breaker.openedAt = breaker.nowFunc()
+	}
+}
+
+// rate limits, server errors and network failures are retryable, cancelled or expired requests are not
+func isRetryableError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var openaiAPIError *openai.APIError
+	if errors.As(err, &openaiAPIError) {
+		return isRetryableStatus(openaiAPIError.HTTPStatusCode)
+	}
+	var openaiRequestError *openai.RequestError
+	if errors.As(err, &openaiRequestError) {
+		return isRetryableStatus(openaiRequestError.HTTPStatusCode)
+	}
+	var geminiAPIError gemini.APIError
+	if errors.As(err, &geminiAPIError) {
+		return isRetryableStatus(geminiAPIError.Code)
+	}
+	var anthropicAPIError *AnthropicAPIError
+	if errors.As(err, &anthropicAPIError) {
+		if anthropicAPIError.StatusCode == 0 {
+			return anthropicAPIError.Type == "overloaded_error" || anthropicAPIError.Type == "rate_limit_error" || anthropicAPIError.Type == "api_error"
+		}
+		return isRetryableStatus(anthropicAPIError.StatusCode)
+	}
+	var netError net.Error
+	return errors.As(err, &netError)
+}
+
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode == http.StatusRequestTimeout || statusCode >= http.StatusInternalServerError
+}
@@ -0,0 +1,365 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+	gemini "google.golang.org/genai"
+)
+
+func newTestResilientAgent(agent Agent, maxAttempts int) (*ResilientAgent, *[]time.Duration) {
+	sleeps := []time.Duration{}
+	resilient := NewResilientAgent(agent, RetryPolicy{MaxAttempts: maxAttempts, BaseDelayMs: 100, MaxDelayMs: 1000}, NewCircuitBreaker(10, time.Minute))
+	resilient.sleepFunc = func(ctx context.Context, duration time.Duration) error {
+		sleeps = append(sleeps, duration)
+		return ctx.Err()
+	}
+	return resilient, &sleeps
+}
+
+func TestResilientAgentRetriesRetryableErrors(t *testing.T) {
+	calls := 0
+	agent, sleeps := newTestResilientAgent(&mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			calls++
+			if calls < 3 {
+				return "", &openai.APIError{HTTPStatusCode: 429}
+			}
+			return "answer", nil
+		},
+	}, 3)
+
+	got, err := agent.QueryWithContext(context.Background(), "1", "query", systemPrompt)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "answer" || calls != 3 || len(*sleeps) != 2 {
+		t.Errorf("expected answer after 3 calls and 2 waits but got %s after %d calls and %v", got, calls, *sleeps)
+	}
+}
+
+func TestResilientAgentGivesUpAfterMaxAttempts(t *testing.T) {
+	calls := 0
+	agent, _ := newTestResilientAgent(&mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			calls++
+			return "", gemini.APIError{Code: 503}
+		},
+	}, 2)
+
+	_, err := agent.QueryWithContext(context.Background(), "1", "query", systemPrompt)
+	if !errors.Is(err, ErrAgentUnavailable) || calls != 2 {
+		t.Errorf("expected unavailable error after 2 calls but got %v after %d calls", err, calls)
+	}
+}
+
+func TestResilientAgentDoesNotRetryOtherErrors(t *testing.T) {
+	calls := 0
+	agent, _ := newTestResilientAgent(&mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			calls++
+			return "", &openai.APIError{HTTPStatusCode: 400}
+		},
+	}, 3)
+
+	_, err := agent.QueryWithContext(context.Background(), "1", "query", systemPrompt)
+	if err == nil || errors.Is(err, ErrAgentUnavailable) || calls != 1 {
+		t.Errorf("expected single call with original error but got %v after %d calls", err, calls)
+	}
+}
+
+func TestResilientAgentDoesNotRetryStartedStream(t *testing.T) {
+	calls := 0
+	agent, _ := newTestResilientAgent(&mockAgent{
+		StreamWithContextFunc: func(sessionId, userQuery, systemPrompt string, onDelta func(string)) (string, error) {
+			calls++
+			onDelta("partial")
+			return "", &AnthropicAPIError{Type: "overloaded_error"}
+		},
+	}, 3)
+
+	_, err := agent.StreamWithContext(context.Background(), "1", "query", systemPrompt, func(string) {})
+	if err == nil || errors.Is(err, ErrAgentUnavailable) || calls != 1 {
+		t.Errorf("expected started stream not to be retried but got %v after %d calls", err, calls)
+	}
+}
+
+// blocks until the context passed to the agent is done
+type blockingAgent struct {
+	mockAgent
+}
+
+func (agent *blockingAgent) QueryWithContext(ctx context.Context, sessionId, userQuery, systemPrompt string) (string, error) {
+	<-ctx.Done()
+	return "", fmt.Errorf("request failed: %w", &openai.RequestError{HTTPStatusCode: 0, Err: errors.New("connection closed")})
+}
+
+func TestResilientAgentTimesOut(t *testing.T) {
+	agent := NewResilientAgentWithTimeout(&blockingAgent{}, DefaultRetryPolicy, NewCircuitBreaker(1, time.Minute), 10*time.Millisecond)
+
+	_, err := agent.QueryWithContext(context.Background(), "1", "query", systemPrompt)
+	if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrAgentUnavailable) {
+		t.Errorf("expected deadline exceeded error but got %v", err)
+	}
+	if agent.Breaker.Allow() {
+		t.Error("expected agent timeout to count as a failure and open the breaker")
+	}
+}
+
+func TestResilientAgentStopsRetryingWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	agent, _ := newTestResilientAgent(&mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			calls++
+			cancel()
+			return "", &openai.APIError{HTTPStatusCode: 503}
+		},
+	}, 3)
+
+	_, err := agent.QueryWithContext(ctx, "1", "query", systemPrompt)
+	if !errors.Is(err, context.Canceled) || calls != 1 {
+		t.Errorf("expected cancelled error after 1 call but got %v after %d calls", err, calls)
+	}
+	if agent.Breaker.failures != 0 {
+		t.Errorf("expected cancellation not to count as a failure but got %d failures", agent.Breaker.failures)
+	}
+}
+
+func TestCircuitBreakerCancelledTrialKeepsFailures(t *testing.T) {
+	timer := newMockTime()
+	breaker := NewCircuitBreakerWithTimeFunc(1, time.Minute, timer.Now)
+	breaker.RecordFailure()
+	timer.Advance(time.Minute)
+	if !breaker.Allow() {
+		t.Fatal("expected trial call after cooldown")
+	}
+
+	breaker.RecordCancel()
+	if breaker.failures != 1 {
+		t.Errorf("expected failures to be kept but got %d", breaker.failures)
+	}
+	if !breaker.Allow() {
+		t.Error("expected another trial call after cancelled trial")
+	}
+	if breaker.Allow() {
+		t.Error("expected breaker to stay half-open")
+	}
+}
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	timer := newMockTime()
+	breaker := NewCircuitBreakerWithTimeFunc(2, time.Minute, timer.Now)
+
+	breaker.RecordFailure()
+	if !breaker.Allow() {
+		t.Fatal("expected breaker to stay closed below threshold")
+	}
+	breaker.RecordFailure()
+	if breaker.Allow() {
+		t.Fatal("expected breaker to open at threshold")
+	}
+
+	timer.Advance(time.Minute)
+	if !breaker.Allow() {
+		t.Fatal("expected trial call after cooldown")
+	}
+	if breaker.Allow() {
+		t.Fatal("expected only a single trial call")
+	}
+
+	breaker.RecordSuccess()
+	if !breaker.Allow() {
+		t.Error("expected breaker to close after successful trial")
+	}
+}
+
+func TestCircuitBreakerReopensAfterFailedTrial(t *testing.T) {
+	timer := newMockTime()
+	breaker := NewCircuitBreakerWithTimeFunc(1, time.Minute, timer.Now)
+	breaker.RecordFailure()
+
+	timer.Advance(time.Minute)
+	breaker.Allow()
+	breaker.RecordFailure()
+	if breaker.Allow() {
+		t.Error("expected breaker to open again after failed trial")
+	}
+}
+
+func TestResilientAgentStopsCallingWhenBreakerIsOpen(t *testing.T) {
+	calls := 0
+	agent := NewResilientAgent(&mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			calls++
+			return "", &openai.RequestError{HTTPStatusCode: 500}
+		},
+	}, RetryPolicy{MaxAttempts: 1}, NewCircuitBreaker(2, time.Minute))
+
+	for range 4 {
+		agent.QueryWithContext(context.Background(), "1", "query", systemPrompt)
+	}
+	if calls != 2 {
+		t.Errorf("expected agent to be called until breaker opened but was called %d times", calls)
+	}
+}
+
+func TestBackoffIsExponentialWithJitter(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelayMs: 100, MaxDelayMs: 300}
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 150 * time.Millisecond, 300 * time.Millisecond},
+		{10, 150 * time.Millisecond, 300 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		for range 20 {
+			got := policy.backoff(tt.attempt)
+			if got < tt.min || got > tt.max {
+				t.Errorf("attempt %d: got %v, want between %v and %v", tt.attempt, got, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&openai.APIError{HTTPStatusCode: 429}, true},
+		{&openai.APIError{HTTPStatusCode: 401}, false},
+		{fmt.Errorf("wrapped: %w", &openai.RequestError{HTTPStatusCode: 502}), true},
+		{gemini.APIError{Code: 500}, true},
+		{gemini.APIError{Code: 400}, false},
+		{&AnthropicAPIError{StatusCode: 529}, true},
+		{&AnthropicAPIError{Type: "overloaded_error"}, true},
+		{&AnthropicAPIError{Type: "invalid_request_error"}, false},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, false},
+		{errors.New("other"), false},
+	}
+	for _, tt := range tests {
+		if got := isRetryableError(tt.err); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestQueryFailsOverToFallbackAgent(t *testing.T) {
+	registry := newTestRegistry(&mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			return "", fmt.Errorf("rate limited: %w", ErrAgentUnavailable)
+		},
+	}, &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			return "fallback code", nil
+		},
+	})
+	if err := registry.SetFallback(CHATGPT, GEMINI); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got, err := NewQueryHandler(registry).QueryAgent(context.Background(), "1", Request{Agent: CHATGPT})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Response != "fallback code" || got.Agent != GEMINI {
+		t.Errorf("expected fallback agent to answer but got %+v", got)
+	}
+}
+
+func TestQueryDoesNotFailOverOtherErrors(t *testing.T) {
+	registry := newTestRegistry(&mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			return "", errors.New("bad request")
+		},
+	}, &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			t.Error("expected fallback agent not to be called")
+			return "", nil
+		},
+	})
+	registry.SetFallback(CHATGPT, GEMINI)
+
+	if _, err := NewQueryHandler(registry).QueryAgent(context.Background(), "1", Request{Agent: CHATGPT}); err == nil {
+		t.Error("expected error of requested agent")
+	}
+}
+
+func TestStreamFailsOverOnlyBeforeFirstDelta(t *testing.T) {
+	unavailable := &mockAgent{
+		StreamWithContextFunc: func(sessionId, userQuery, systemPrompt string, onDelta func(string)) (string, error) {
+			return "", ErrAgentUnavailable
+		},
+	}
+	fallback := &mockAgent{
+		StreamWithContextFunc: func(sessionId, userQuery, systemPrompt string, onDelta func(string)) (string, error) {
+			onDelta("code")
+			return "code", nil
+		},
+	}
+	registry := newTestRegistry(unavailable, fallback)
+	registry.SetFallback(CHATGPT, GEMINI)
+
+	got, err := NewQueryHandler(registry).StreamAgent(context.Background(), "1", Request{Agent: CHATGPT}, func(string) {})
+	if err != nil || got.Agent != GEMINI {
+		t.Fatalf("expected fallback agent to stream but got %+v, %v", got, err)
+	}
+
+	unavailable.StreamWithContextFunc = func(sessionId, userQuery, systemPrompt string, onDelta func(string)) (string, error) {
+		onDelta("partial")
+		return "", ErrAgentUnavailable
+	}
+	if _, err := NewQueryHandler(registry).StreamAgent(context.Background(), "1", Request{Agent: CHATGPT}, func(string) {}); err == nil {
+		t.Error("expected started stream not to fail over")
+	}
+}
+
+func TestRegistryFallbackValidation(t *testing.T) {
+	configs := []AgentConfig{
+		{Name: "primary", Provider: ProviderFake, Fallback: "secondary"},
+		{Name: "secondary", Provider: ProviderFake},
+	}
+	registry, err := NewAgentRegistryFromConfig(configs, &mockCache{}, context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if registry.Fallback("primary") != "secondary" || registry.Fallback("secondary") != "" {
+		t.Errorf("unexpected fallbacks")
+	}
+
+	configs[1].Fallback = "missing"
+	if _, err := NewAgentRegistryFromConfig(configs, &mockCache{}, context.Background()); err == nil {
+		t.Error("expected error for unknown fallback")
+	}
+	configs[1].Fallback = "secondary"
+	if _, err := NewAgentRegistryFromConfig(configs, &mockCache{}, context.Background()); err == nil {
+		t.Error("expected error for agent falling back to itself")
+	}
+}
+
+func TestRegistryAgentTimeout(t *testing.T) {
+	configs := []AgentConfig{
+		{Name: "default", Provider: ProviderFake},
+		{Name: "configured", Provider: ProviderFake, TimeoutSeconds: 5},
+	}
+	registry, err := NewAgentRegistryFromConfig(configs, &mockCache{}, context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, expected := range map[string]time.Duration{"default": defaultAgentTimeout, "configured": 5 * time.Second} {
+		agent, _ := registry.Get(name)
+		if got := agent.(*ResilientAgent).Timeout; got != expected {
+			t.Errorf("expected %s timeout %v but got %v", name, expected, got)
+		}
+	}
+}
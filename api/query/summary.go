@@ -0,0 +1,97 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Summariser interface {
+	// returns a summary covering both the previous summary and evicted contexts
+	Summarise(previousSummary string, evicted []Context) (string, error)
+}
+
+// summarises conversations with an agent, ideally a cheap one since it runs whenever a session is full
+type AgentSummariser struct {
+	Agent Agent
+}
+
+// summaries are not part of any conversation, so the agent must not store them in the shared history
+type noHistoryCache struct{}
+
+const (
+	summarySessionId = "summary"
+	// summaries run outside of any request, so they are limited by their own timeout
+	summaryTimeout = 60 * time.Second
+)
+
+var summarySystemPrompt = `You condense conversations between a user and a coding assistant.
+Keep every instruction and requirement the user gave (algorithms, data structures, constraints, style) and decisions already made.
+Leave out code unless a short fragment is essential. Answer only with the summary as a short list of facts.`
+
+var summaryPromptTemplate = `<previousSummary>
+%s
+</previousSummary>
+<conversation>
+%s
+</conversation>`
+
+var summaryContextTemplate = `Summary of our earlier conversation:
+%s`
+
+const summaryAcknowledgement = "Understood, I will take this into account."
+
+func NewAgentSummariser(agent Agent) *AgentSummariser {
+	return &AgentSummariser{
+		Agent: agent,
+	}
+}
+
+// agent is created from its configuration with its own history disabled. it retries and stops calling
+// a failing agent like registry agents, the timeout is set by Summarise
+func NewAgentSummariserFromConfig(config AgentConfig, ctx context.Context) (*AgentSummariser, error) {
+	agent, err := newAgentFromConfig(config, noHistoryCache{}, ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not create summary agent %s: %w", config.Name, err)
+	}
+	return NewAgentSummariser(NewResilientAgent(agent, retryPolicy(config), NewCircuitBreaker(defaultBreakerThreshold, defaultBreakerCooldown))), nil
+}
+
+func (summariser *AgentSummariser) Summarise(previousSummary string, evicted []Context) (string, error) {
+	var conversation strings.Builder
+	for _, context := range evicted {
+		fmt.Fprintf(&conversation, "<%s>\n%s\n</%s>\n", context.Role, context.Content, context.Role)
+	}
+	userQuery := fmt.Sprintf(summaryPromptTemplate, previousSummary, strings.TrimSpace(conversation.String()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), summaryTimeout)
+	defer cancel()
+	summary, err := summariser.Agent.QueryWithContext(ctx, summarySessionId, userQuery, summarySystemPrompt)
+	if err != nil {
+		return "", fmt.Errorf("could not query summary agent: %w", err)
+	}
+	summary = strings.TrimSpace(summary)
+	if summary == "" {
+		return "", fmt.Errorf("summary agent returned empty summary")
+	}
+	return summary, nil
+}
+
+// summary is sent as a request/response pair, so user and assistant messages keep alternating
+func summaryContexts(summary string) []Context {
+	return []Context{
+		{Role: RoleUser, Content: fmt.Sprintf(summaryContextTemplate, summary)},
+		{Role: RoleAssistant, Content: summaryAcknowledgement},
+	}
+}
+
+func (noHistoryCache) Add(sessionId, userInput, aiOutput string) {}
+
+func (noHistoryCache) Get(sessionId string) []Context {
+	return nil
+}
+
+func (noHistoryCache) StartCleanupRoutine() {}
+
+func (noHistoryCache) StopCleanupRoutine() {}
@@ -0,0 +1,234 @@
+package query
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type mockSummariser struct {
+	SummariseFunc func(previousSummary string, evicted []Context) (string, error)
+}
+
+func (mockSummariser *mockSummariser) Summarise(previousSummary string, evicted []Context) (string, error) {
+	if mockSummariser.SummariseFunc != nil {
+		return mockSummariser.SummariseFunc(previousSummary, evicted)
+	}
+	return "", nil
+}
+
+func TestAgentSummariserSendsPreviousSummaryAndEvictedContexts(t *testing.T) {
+	var gotQuery, gotSystemPrompt string
+	summariser := NewAgentSummariser(&mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			gotQuery, gotSystemPrompt = userQuery, systemPrompt
+			return "  - use a heap\n", nil
+		},
+	})
+
+	got, err := summariser.Summarise("- language is go", []Context{
+		{Role: RoleUser, Content: "use a heap"},
+		{Role: RoleAssistant, Content: "ok"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "- use a heap" {
+		t.Errorf("got %q, want trimmed summary", got)
+	}
+	for _, want := range []string{"- language is go", "<user>\nuse a heap\n</user>", "<assistant>\nok\n</assistant>"} {
+		if !strings.Contains(gotQuery, want) {
+			t.Errorf("expected query to contain %q but got %s", want, gotQuery)
+		}
+	}
+	if gotSystemPrompt != summarySystemPrompt {
+		t.Errorf("expected summary system prompt but got %s", gotSystemPrompt)
+	}
+}
+
+func TestAgentSummariserErrors(t *testing.T) {
+	failing := NewAgentSummariser(&mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			return "", errors.New("agent down")
+		},
+	})
+	if _, err := failing.Summarise("", []Context{{Role: RoleUser, Content: "a"}}); err == nil {
+		t.Error("expected agent error to be returned")
+	}
+
+	empty := NewAgentSummariser(&mockAgent{})
+	if _, err := empty.Summarise("", []Context{{Role: RoleUser, Content: "a"}}); err == nil {
+		t.Error("expected error for empty summary")
+	}
+}
+
+func TestSummaryAgentFromConfigKeepsNoHistory(t *testing.T) {
+	summariser, err := NewAgentSummariserFromConfig(AgentConfig{Name: "cheap", Provider: ProviderFake, Responses: []string{"summary"}}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	summariser.Summarise("", []Context{{Role: RoleUser, Content: "a"}})
+
+	resilient, ok := summariser.Agent.(*ResilientAgent)
+	if !ok {
+		t.Fatalf("expected summary agent to be resilient but got %T", summariser.Agent)
+	}
+	fake := resilient.Agent.(*FakeAgent)
+	if _, ok := fake.Cache.(noHistoryCache); !ok {
+		t.Errorf("expected summary agent to use no history but got %T", fake.Cache)
+	}
+}
+
+func TestCacheSummarisesEvictedContexts(t *testing.T) {
+	cache, _ := NewContextCache(1, time.Minute, time.Minute)
+	var gotPrevious string
+	var gotEvicted []Context
+	cache.SetSummariser(&mockSummariser{
+		SummariseFunc: func(previousSummary string, evicted []Context) (string, error) {
+			gotPrevious, gotEvicted = previousSummary, evicted
+			return "summary " + evicted[0].Content, nil
+		},
+	})
+
+	cache.Add("1", "first", "answer")
+	cache.Add("1", "second", "answer")
+	cache.waitForSummaries()
+	if len(gotEvicted) != 2 || gotEvicted[0].Content != "first" {
+		t.Fatalf("expected first pair to be summarised but got %v", gotEvicted)
+	}
+
+	cache.Add("1", "third", "answer")
+	cache.waitForSummaries()
+	if gotPrevious != "summary first" {
+		t.Errorf("expected previous summary to be passed but got %s", gotPrevious)
+	}
+
+	got := cache.Get("1")
+	if len(got) != 4 {
+		t.Fatalf("expected summary pair and most recent pair but got %v", got)
+	}
+	if got[0].Role != RoleUser || !strings.Contains(got[0].Content, "summary second") || got[1].Role != RoleAssistant {
+		t.Errorf("expected summary to be prepended but got %v", got[:2])
+	}
+	if got[2].Content != "third" {
+		t.Errorf("expected most recent pair after summary but got %v", got[2:])
+	}
+}
+
+func TestCacheKeepsPreviousSummaryOnError(t *testing.T) {
+	cache, _ := NewContextCache(1, time.Minute, time.Minute)
+	calls := 0
+	cache.SetSummariser(&mockSummariser{
+		SummariseFunc: func(previousSummary string, evicted []Context) (string, error) {
+			calls++
+			if calls > 1 {
+				return "", errors.New("agent down")
+			}
+			return "first summary", nil
+		},
+	})
+
+	cache.Add("1", "first", "answer")
+	cache.Add("1", "second", "answer")
+	cache.waitForSummaries()
+	cache.Add("1", "third", "answer")
+	cache.waitForSummaries()
+
+	got := cache.Get("1")
+	if len(got) != 4 || !strings.Contains(got[0].Content, "first summary") {
+		t.Errorf("expected previous summary to be kept but got %v", got)
+	}
+}
+
+func TestCacheWithoutSummariserDropsEvictedContexts(t *testing.T) {
+	cache, _ := NewContextCache(1, time.Minute, time.Minute)
+	cache.Add("1", "first", "answer")
+	cache.Add("1", "second", "answer")
+
+	got := cache.Get("1")
+	if len(got) != 2 || got[0].Content != "second" {
+		t.Errorf("expected only most recent pair but got %v", got)
+	}
+}
+
+func TestCacheCleanupRemovesSummary(t *testing.T) {
+	timer := newMockTime()
+	cache, _ := NewContextCacheWithTimeFunc(1, time.Minute, time.Minute, timer.Now)
+	cache.SetSummariser(&mockSummariser{
+		SummariseFunc: func(previousSummary string, evicted []Context) (string, error) {
+			return "summary", nil
+		},
+	})
+	cache.Add("1", "first", "answer")
+	cache.Add("1", "second", "answer")
+	cache.waitForSummaries()
+
+	timer.Advance(2 * time.Minute)
+	cache.cleanupStaleSessions()
+
+	if got := cache.Get("1"); len(got) != 0 {
+		t.Errorf("expected stale session and its summary to be removed but got %v", got)
+	}
+}
+
+// blocks until released, so the test can check the cache while a summary is running
+type blockingSummariser struct {
+	release chan struct{}
+	calls   chan []Context
+}
+
+func (summariser *blockingSummariser) Summarise(previousSummary string, evicted []Context) (string, error) {
+	summariser.calls <- evicted
+	<-summariser.release
+	return previousSummary + "+" + evicted[0].Content, nil
+}
+
+func TestCacheSummarisesInBackground(t *testing.T) {
+	cache, _ := NewContextCache(1, time.Minute, time.Minute)
+	summariser := &blockingSummariser{release: make(chan struct{}), calls: make(chan []Context, 2)}
+	cache.SetSummariser(summariser)
+
+	cache.Add("1", "first", "answer")
+	cache.Add("1", "second", "answer")
+	<-summariser.calls
+	// evicted while the first summary is running, summarised after it with the first summary as previous
+	cache.Add("1", "third", "answer")
+	if got := cache.Get("1"); len(got) != 2 || got[0].Content != "third" {
+		t.Errorf("expected add not to wait for the summary but got %v", got)
+	}
+
+	close(summariser.release)
+	cache.waitForSummaries()
+	if got := cache.Get("1"); len(got) != 4 || !strings.Contains(got[0].Content, "+first+second") {
+		t.Errorf("expected summaries to build on each other but got %v", got)
+	}
+}
+
+type mockSummaryStore struct {
+	summaries map[string]string
+}
+
+func (store *mockSummaryStore) StoreSummary(sessionId, summary string) error {
+	store.summaries[sessionId] = summary
+	return nil
+}
+
+func TestCacheStoresSummary(t *testing.T) {
+	cache, _ := NewContextCache(1, time.Minute, time.Minute)
+	store := &mockSummaryStore{summaries: make(map[string]string)}
+	cache.SetSummaryStore(store)
+	cache.SetSummariser(&mockSummariser{
+		SummariseFunc: func(previousSummary string, evicted []Context) (string, error) {
+			return "summary", nil
+		},
+	})
+
+	cache.Add("1", "first", "answer")
+	cache.Add("1", "second", "answer")
+	cache.waitForSummaries()
+
+	if store.summaries["1"] != "summary" {
+		t.Errorf("expected summary to be stored but got %v", store.summaries)
+	}
+}
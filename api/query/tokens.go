@@ -0,0 +1,120 @@
+package query
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+const (
+	defaultContextTokens = 8192
+	// room left for the model answer when fitting the request into the context window
+	responseTokenReserve = 1024
+	// rough per message cost of role and formatting tokens
+	messageTokenOverhead = 4
+)
+
+// context window sizes of known models, other models use defaultContextTokens unless configured
+var modelContextTokens = map[string]int{
+	"gpt-3.5-turbo":     16385,
+	"gpt-4o":            128000,
+	"gpt-4o-mini":       128000,
+	"gemini-2.5-flash":  1048576,
+	"gemini-2.5-pro":    1048576,
+	"claude-sonnet-4-5": 200000,
+}
+
+// implemented by caches which can trim the returned history, so it fits the model together with the current request
+type BudgetedCache interface {
+	GetWithinBudget(sessionId string, reservedTokens int) []Context
+}
+
+// limits history returned to agents of a single model. the most recent request/response pair is always kept
+type TokenBudgetCache struct {
+	CacheInterface
+	MaxTokens int
+}
+
+func NewTokenBudgetCache(cache CacheInterface, maxTokens int) *TokenBudgetCache {
+	return &TokenBudgetCache{
+		CacheInterface: cache,
+		MaxTokens:      maxTokens,
+	}
+}
+
+func (tbc *TokenBudgetCache) Get(sessionId string) []Context {
+	return tbc.GetWithinBudget(sessionId, 0)
+}
+
+// reservedTokens - tokens already used by the system prompt and the current query.
+// summary of a summarising cache is always kept and the contexts dropped to fit the budget are summarised
+func (tbc *TokenBudgetCache) GetWithinBudget(sessionId string, reservedTokens int) []Context {
+	summarising, ok := tbc.CacheInterface.(SummarisingCache)
+	if !ok {
+		contexts := tbc.CacheInterface.Get(sessionId)
+		return tbc.trim(sessionId, reservedTokens, contexts)
+	}
+
+	contexts, summary := summarising.GetWithSummary(sessionId)
+	if summary == "" {
+		trimmed := tbc.trim(sessionId, reservedTokens, contexts)
+		summarising.Evict(sessionId, contexts[:len(contexts)-len(trimmed)])
+		return trimmed
+	}
+	summaryPair := summaryContexts(summary)
+	trimmed := tbc.trim(sessionId, reservedTokens+contextTokens(summaryPair), contexts)
+	summarising.Evict(sessionId, contexts[:len(contexts)-len(trimmed)])
+	return append(summaryPair, trimmed...)
+}
+
+func (tbc *TokenBudgetCache) trim(sessionId string, reservedTokens int, contexts []Context) []Context {
+	budget := tbc.MaxTokens - responseTokenReserve - reservedTokens
+	trimmed, historyTokens := trimToTokenBudget(contexts, budget)
+	fmt.Printf("Session %s: estimated %d history tokens and %d request tokens of %d, kept %d of %d contexts.\n",
+		sessionId, historyTokens, reservedTokens, tbc.MaxTokens, len(trimmed), len(contexts))
+	return trimmed
+}
+
+// history is read through the token budget when the cache supports it, so the system prompt and the query
+// with the current code are never trimmed
+func previousContextWithinBudget(cache CacheInterface, sessionId, userQuery, systemPrompt string) []Context {
+	budgeted, ok := cache.(BudgetedCache)
+	if !ok {
+		return cache.Get(sessionId)
+	}
+	return budgeted.GetWithinBudget(sessionId, estimateTokens(userQuery)+estimateTokens(systemPrompt))
+}
+
+// drops oldest request/response pairs until history fits the budget, returns kept contexts and their token estimate
+func trimToTokenBudget(contexts []Context, budget int) ([]Context, int) {
+	total := contextTokens(contexts)
+
+	start := 0
+	for total > budget && len(contexts)-start > 2 {
+		total -= estimateTokens(contexts[start].Content) + estimateTokens(contexts[start+1].Content)
+		start += 2
+	}
+	return contexts[start:], total
+}
+
+func contextTokens(contexts []Context) int {
+	total := 0
+	for _, context := range contexts {
+		total += estimateTokens(context.Content)
+	}
+	return total
+}
+
+// approximation of roughly four characters per token, good enough for both code and text
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text)+3)/4 + messageTokenOverhead
+}
+
+func contextTokenLimit(config AgentConfig) int {
+	if config.ContextTokens > 0 {
+		return config.ContextTokens
+	}
+	if limit, ok := modelContextTokens[config.Model]; ok {
+		return limit
+	}
+	return defaultContextTokens
+}
@@ -0,0 +1,150 @@
+package query
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	if got := estimateTokens(""); got != messageTokenOverhead {
+		t.Errorf("got %d, want %d for empty text", got, messageTokenOverhead)
+	}
+	if got := estimateTokens(strings.Repeat("a", 40)); got != 10+messageTokenOverhead {
+		t.Errorf("got %d, want %d", got, 10+messageTokenOverhead)
+	}
+	if got := estimateTokens("ąčę"); got != 1+messageTokenOverhead {
+		t.Errorf("expected runes to be counted instead of bytes but got %d", got)
+	}
+}
+
+func TestTrimToTokenBudgetDropsOldestPairs(t *testing.T) {
+	contexts := []Context{
+		{Role: RoleUser, Content: strings.Repeat("a", 400)},
+		{Role: RoleAssistant, Content: strings.Repeat("b", 400)},
+		{Role: RoleUser, Content: "short"},
+		{Role: RoleAssistant, Content: "answer"},
+	}
+
+	got, tokens := trimToTokenBudget(contexts, 50)
+	if !reflect.DeepEqual(got, contexts[2:]) {
+		t.Errorf("got %v, want most recent pair", got)
+	}
+	if tokens != estimateTokens("short")+estimateTokens("answer") {
+		t.Errorf("unexpected token estimate %d", tokens)
+	}
+}
+
+func TestTrimToTokenBudgetKeepsMostRecentPair(t *testing.T) {
+	contexts := []Context{
+		{Role: RoleUser, Content: "old"},
+		{Role: RoleAssistant, Content: "old answer"},
+		{Role: RoleUser, Content: strings.Repeat("a", 4000)},
+		{Role: RoleAssistant, Content: strings.Repeat("b", 4000)},
+	}
+
+	got, _ := trimToTokenBudget(contexts, 10)
+	if !reflect.DeepEqual(got, contexts[2:]) {
+		t.Errorf("expected most recent pair to be kept even over budget but got %d contexts", len(got))
+	}
+}
+
+func TestTrimToTokenBudgetKeepsHistoryWithinBudget(t *testing.T) {
+	contexts := []Context{
+		{Role: RoleUser, Content: "a"},
+		{Role: RoleAssistant, Content: "b"},
+		{Role: RoleUser, Content: "c"},
+		{Role: RoleAssistant, Content: "d"},
+	}
+
+	got, _ := trimToTokenBudget(contexts, 1000)
+	if !reflect.DeepEqual(got, contexts) {
+		t.Errorf("expected nothing to be trimmed but got %v", got)
+	}
+}
+
+func TestTokenBudgetCacheReservesQueryTokens(t *testing.T) {
+	contexts := []Context{
+		{Role: RoleUser, Content: strings.Repeat("a", 400)},
+		{Role: RoleAssistant, Content: strings.Repeat("b", 400)},
+		{Role: RoleUser, Content: "short"},
+		{Role: RoleAssistant, Content: "answer"},
+	}
+	cache := NewTokenBudgetCache(&mockCache{
+		GetFunc: func(sessionId string) []Context { return contexts },
+	}, responseTokenReserve+300)
+
+	if got := previousContextWithinBudget(cache, "1", "query", "system"); len(got) != 4 {
+		t.Errorf("expected full history to fit but got %d contexts", len(got))
+	}
+	if got := previousContextWithinBudget(cache, "1", strings.Repeat("c", 800), "system"); len(got) != 2 {
+		t.Errorf("expected large query to trim history but got %d contexts", len(got))
+	}
+}
+
+func TestTokenBudgetCacheKeepsSummaryAndSummarisesTrimmedContexts(t *testing.T) {
+	cache, _ := NewContextCache(100, time.Minute, time.Minute)
+	var gotEvicted []Context
+	cache.SetSummariser(&mockSummariser{
+		SummariseFunc: func(previousSummary string, evicted []Context) (string, error) {
+			gotEvicted = append(gotEvicted, evicted...)
+			return "summary", nil
+		},
+	})
+	for range 10 {
+		cache.Add("1", strings.Repeat("q", 8000), strings.Repeat("a", 8000))
+	}
+	budgetCache := NewTokenBudgetCache(cache, 8192)
+
+	if got := budgetCache.GetWithinBudget("1", 0); len(got) != 2 {
+		t.Fatalf("expected only the most recent pair to fit but got %d contexts", len(got))
+	}
+	cache.waitForSummaries()
+	if len(gotEvicted) != 18 {
+		t.Errorf("expected 9 trimmed pairs to be summarised but got %d contexts", len(gotEvicted))
+	}
+
+	cache.Add("1", strings.Repeat("q", 8000), strings.Repeat("a", 8000))
+	got := budgetCache.GetWithinBudget("1", 0)
+	if len(got) != 4 || !strings.Contains(got[0].Content, "summary") {
+		t.Errorf("expected summary pair in front of the most recent pair but got %d contexts", len(got))
+	}
+	cache.waitForSummaries()
+	if len(gotEvicted) != 20 {
+		t.Errorf("expected next trimmed pair to be summarised but got %d contexts", len(gotEvicted))
+	}
+}
+
+func TestTokenBudgetCacheKeepsContextsWithoutSummariser(t *testing.T) {
+	cache, _ := NewContextCache(100, time.Minute, time.Minute)
+	for range 3 {
+		cache.Add("1", strings.Repeat("q", 8000), strings.Repeat("a", 8000))
+	}
+
+	NewTokenBudgetCache(cache, 8192).GetWithinBudget("1", 0)
+	if got := cache.Get("1"); len(got) != 6 {
+		t.Errorf("expected trimmed contexts to stay in the cache but got %d contexts", len(got))
+	}
+}
+
+func TestPreviousContextWithoutBudget(t *testing.T) {
+	contexts := []Context{{Role: RoleUser, Content: "a"}, {Role: RoleAssistant, Content: "b"}}
+	cache := &mockCache{GetFunc: func(sessionId string) []Context { return contexts }}
+
+	if got := previousContextWithinBudget(cache, "1", strings.Repeat("c", 100000), "system"); !reflect.DeepEqual(got, contexts) {
+		t.Errorf("expected plain cache history to be returned unchanged but got %v", got)
+	}
+}
+
+func TestContextTokenLimit(t *testing.T) {
+	if got := contextTokenLimit(AgentConfig{Model: "gpt-4o", ContextTokens: 1000}); got != 1000 {
+		t.Errorf("expected configured limit but got %d", got)
+	}
+	if got := contextTokenLimit(AgentConfig{Model: "gpt-3.5-turbo"}); got != 16385 {
+		t.Errorf("expected known model limit but got %d", got)
+	}
+	if got := contextTokenLimit(AgentConfig{Model: "llama3"}); got != defaultContextTokens {
+		t.Errorf("expected default limit but got %d", got)
+	}
+}
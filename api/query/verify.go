@@ -0,0 +1,197 @@
+package query
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"serious-fin/api/common"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type CodeTemplateProvider interface {
+	// returns starting code of the problem, it declares every function the tests call
+	GetMainFuncGo(problemId string) (string, error)
+}
+
+// checks that go answers compile and keep the functions declared by the problem template,
+// so the harness can call them. other languages are not checked
+type CodeVerifier struct {
+	Templates      CodeTemplateProvider
+	MaxCorrections int
+}
+
+var correctionTemplate = `Your code can not be used: %s
+Fix it and respond only with the complete corrected code.`
+
+func NewCodeVerifier(templates CodeTemplateProvider, maxCorrections int) *CodeVerifier {
+	return &CodeVerifier{
+		Templates:      templates,
+		MaxCorrections: maxCorrections,
+	}
+}
+
+// returns description of the problem found in the code, empty when the code can be used
+func (verifier *CodeVerifier) Verify(code, language string, problemId int) (string, error) {
+	if !isGoLanguage(language) {
+		return "", nil
+	}
+
+	file, err := parseGoCode(code)
+	if err != nil {
+		return fmt.Sprintf("it does not compile: %v", err), nil
+	}
+
+	expected, err := verifier.expectedFunctions(problemId)
+	if err != nil {
+		return "", err
+	}
+	return checkFunctions(file, expected), nil
+}
+
+// no template (or no problem) means only the syntax is checked
+func (verifier *CodeVerifier) expectedFunctions(problemId int) ([]*ast.FuncDecl, error) {
+	if verifier.Templates == nil || problemId == 0 {
+		return nil, nil
+	}
+	template, err := verifier.Templates.GetMainFuncGo(strconv.Itoa(problemId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not get template for verification: %w", err)
+	}
+
+	file, err := parseGoCode(template)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse template of problem %d: %w", problemId, err)
+	}
+	return topLevelFunctions(file), nil
+}
+
+func parseGoCode(code string) (*ast.File, error) {
+	file, _, _, err := parseGoSource(code)
+	return file, err
+}
+
+// answers are usually written without package clause like the templates, so it is added in front of the first line
+// and reported columns of that line are shifted back to match the answer. returns the parsed source, positions point into it
+func parseGoSource(code string) (*ast.File, *token.FileSet, string, error) {
+	addedClause := !common.HasPackageClause(code)
+	code = common.WithPackageClause(code)
+	fileSet := token.NewFileSet()
+	file, err := parser.ParseFile(fileSet, "solution.go", code, parser.ParseComments|parser.SkipObjectResolution)
+
+	if addedClause && file != nil {
+		attachLeadingComment(file, fileSet)
+	}
+	var errorList scanner.ErrorList
+	if addedClause && errors.As(err, &errorList) {
+		for _, parseError := range errorList {
+			if parseError.Pos.Line == 1 {
+				parseError.Pos.Column -= len(common.PackageClausePrefix)
+			}
+		}
+	}
+	return file, fileSet, code, err
+}
+
+// a comment starting the code is on the line of the added package clause, so the parser does not take it as
+// documentation of the first declaration. it is given back, joined with the rest of the documentation
+func attachLeadingComment(file *ast.File, fileSet *token.FileSet) {
+	if len(file.Comments) == 0 || len(file.Decls) == 0 {
+		return
+	}
+	leading := file.Comments[0]
+	if fileSet.Position(leading.Pos()).Line != 1 {
+		return
+	}
+	var doc **ast.CommentGroup
+	switch decl := file.Decls[0].(type) {
+	case *ast.FuncDecl:
+		doc = &decl.Doc
+	case *ast.GenDecl:
+		doc = &decl.Doc
+	default:
+		return
+	}
+
+	documented := file.Decls[0].Pos()
+	if *doc != nil {
+		documented = (*doc).Pos()
+	}
+	if fileSet.Position(leading.End()).Line+1 != fileSet.Position(documented).Line {
+		return
+	}
+	if *doc == nil {
+		*doc = leading
+		return
+	}
+	*doc = &ast.CommentGroup{List: slices.Concat(leading.List, (*doc).List)}
+}
+
+func topLevelFunctions(file *ast.File) []*ast.FuncDecl {
+	functions := []*ast.FuncDecl{}
+	for _, decl := range file.Decls {
+		function, ok := decl.(*ast.FuncDecl)
+		if ok && function.Recv == nil {
+			functions = append(functions, function)
+		}
+	}
+	return functions
+}
+
+func checkFunctions(file *ast.File, expected []*ast.FuncDecl) string {
+	declared := topLevelFunctions(file)
+	for _, want := range expected {
+		index := slices.IndexFunc(declared, func(function *ast.FuncDecl) bool {
+			return function.Name.Name == want.Name.Name
+		})
+		if index == -1 {
+			return fmt.Sprintf("function %s is missing, tests call it with exactly this signature", signature(want))
+		}
+		if got := signature(declared[index]); got != signature(want) {
+			return fmt.Sprintf("function %s is declared as %s, tests call it with exactly this signature", signature(want), got)
+		}
+	}
+	return ""
+}
+
+// parameter names do not matter to the caller, so only types are compared
+func signature(function *ast.FuncDecl) string {
+	var builder strings.Builder
+	builder.WriteString(function.Name.Name)
+	if function.Type.TypeParams != nil {
+		fmt.Fprintf(&builder, "[%s]", fieldTypes(function.Type.TypeParams))
+	}
+	fmt.Fprintf(&builder, "(%s)", fieldTypes(function.Type.Params))
+
+	results := fieldTypes(function.Type.Results)
+	if function.Type.Results != nil && function.Type.Results.NumFields() > 1 {
+		results = "(" + results + ")"
+	}
+	if results != "" {
+		builder.WriteString(" " + results)
+	}
+	return builder.String()
+}
+
+func fieldTypes(fields *ast.FieldList) string {
+	if fields == nil {
+		return ""
+	}
+	fieldTypes := []string{}
+	for _, field := range fields.List {
+		fieldType := types.ExprString(field.Type)
+		for range max(len(field.Names), 1) {
+			fieldTypes = append(fieldTypes, fieldType)
+		}
+	}
+	return strings.Join(fieldTypes, ", ")
+}
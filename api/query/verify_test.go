@@ -0,0 +1,144 @@
+package query
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type mockTemplates struct {
+	GetMainFuncGoFunc func(problemId string) (string, error)
+}
+
+func (mockTemplates *mockTemplates) GetMainFuncGo(problemId string) (string, error) {
+	if mockTemplates.GetMainFuncGoFunc != nil {
+		return mockTemplates.GetMainFuncGoFunc(problemId)
+	}
+	return "", sql.ErrNoRows
+}
+
+func newTestVerifier(template string) *CodeVerifier {
+	return NewCodeVerifier(&mockTemplates{
+		GetMainFuncGoFunc: func(problemId string) (string, error) {
+			return template, nil
+		},
+	}, 2)
+}
+
+func TestVerifyCode(t *testing.T) {
+	template := "func solve(nums []int, k int) (int, error) {\n\n}"
+	tests := []struct {
+		name  string
+		code  string
+		issue string
+	}{
+		{"valid code", "func solve(values []int, limit int) (int, error) {\n\treturn 0, nil\n}", ""},
+		{"helpers and package clause", "package main\n\nimport \"sort\"\n\nfunc helper() {}\n\nfunc solve(a []int, b int) (int, error) {\n\tsort.Ints(a)\n\treturn b, nil\n}", ""},
+		{"syntax error", "func solve(nums []int, k int) (int, error) {\n\treturn 0, nil\n", "it does not compile: solution.go:2"},
+		{"error on first line", "func solve(nums []int, k int) (int, error) { return 0 nil }", "it does not compile: solution.go:1:55"},
+		{"renamed function", "func Solve(nums []int, k int) (int, error) {\n\treturn 0, nil\n}", "function solve([]int, int) (int, error) is missing"},
+		{"changed signature", "func solve(nums []int64, k int) int {\n\treturn 0\n}", "is declared as solve([]int64, int) int"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			issue, err := newTestVerifier(template).Verify(test.code, "go", 1)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.issue == "" && issue != "" || !strings.Contains(issue, test.issue) {
+				t.Errorf("got issue %q, want %q", issue, test.issue)
+			}
+		})
+	}
+}
+
+func TestVerifySkipsOtherLanguagesAndMissingTemplates(t *testing.T) {
+	verifier := NewCodeVerifier(&mockTemplates{}, 2)
+	if issue, err := verifier.Verify("int main() {", "cpp", 1); issue != "" || err != nil {
+		t.Errorf("expected cpp code not to be verified but got %q, %v", issue, err)
+	}
+	if issue, err := verifier.Verify("func anything() {}", "go", 1); issue != "" || err != nil {
+		t.Errorf("expected only syntax check without template but got %q, %v", issue, err)
+	}
+}
+
+func TestVerifyReturnsTemplateErrors(t *testing.T) {
+	verifier := NewCodeVerifier(&mockTemplates{
+		GetMainFuncGoFunc: func(problemId string) (string, error) {
+			return "", errors.New("db down")
+		},
+	}, 2)
+	if _, err := verifier.Verify("func solve() {}", "go", 1); err == nil {
+		t.Error("expected template error")
+	}
+}
+
+func TestQueryAgentAsksForCorrections(t *testing.T) {
+	answers := []string{"func Double(x int) int {\n\treturn x * 2\n}", "```go\nfunc double(x int) int {\n\treturn x * 2\n}\n```"}
+	userQueries := []string{}
+	agent := &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			userQueries = append(userQueries, userQuery)
+			return answers[len(userQueries)-1], nil
+		},
+	}
+	queryHandler := NewQueryHandler(newTestRegistry(&mockAgent{}, agent))
+	queryHandler.SetVerifier(newTestVerifier("func double(x int) int {\n\n}"))
+
+	got, err := queryHandler.QueryAgent(context.Background(), "1", Request{Language: "go", Agent: GEMINI, ProblemId: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Corrections != 1 || got.VerificationIssue != "" || !strings.HasPrefix(got.Response, "func double") {
+		t.Errorf("expected corrected code after one correction but got %+v", got)
+	}
+	if len(userQueries) != 2 || !strings.Contains(userQueries[1], "function double(int) int is missing") {
+		t.Errorf("expected correction describing the issue but got %v", userQueries)
+	}
+}
+
+func TestQueryAgentKeepsAnswerWhenCorrectionFails(t *testing.T) {
+	queries := 0
+	agent := &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			queries++
+			if queries > 1 {
+				return "", errors.New("agent failed")
+			}
+			return "func Double(x int) int {\n\treturn x * 2\n}", nil
+		},
+	}
+	queryHandler := NewQueryHandler(newTestRegistry(&mockAgent{}, agent))
+	queryHandler.SetVerifier(newTestVerifier("func double(x int) int {\n\n}"))
+
+	got, err := queryHandler.QueryAgent(context.Background(), "1", Request{Language: "go", Agent: GEMINI, ProblemId: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(got.Response, "func Double") || !strings.Contains(got.VerificationIssue, "function double(int) int is missing") {
+		t.Errorf("expected first answer with its issue but got %+v", got)
+	}
+}
+
+func TestQueryAgentStopsCorrectingAfterLimit(t *testing.T) {
+	queries := 0
+	agent := &mockAgent{
+		QueryWithContextFunc: func(sessionId, userQuery, systemPrompt string) (string, error) {
+			queries++
+			return "```go\nfunc double(x int) int {\n```", nil
+		},
+	}
+	queryHandler := NewQueryHandler(newTestRegistry(&mockAgent{}, agent))
+	queryHandler.SetVerifier(newTestVerifier("func double(x int) int {\n\n}"))
+
+	got, err := queryHandler.QueryAgent(context.Background(), "1", Request{Language: "go", Agent: GEMINI, ProblemId: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if queries != 3 || got.Corrections != 2 || !strings.HasPrefix(got.VerificationIssue, "it does not compile") {
+		t.Errorf("expected unverified code after 2 corrections but got %+v after %d queries", got, queries)
+	}
+}
@@ -0,0 +1,175 @@
+package validator
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"serious-fin/api/common"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ResultCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	maxSize int
+	ttl     time.Duration
+	hits    int
+	misses  int
+	nowFunc func() time.Time
+}
+
+type CacheStats struct {
+	Size     int     `json:"size"`
+	Hits     int     `json:"hits"`
+	Misses   int     `json:"misses"`
+	HitRatio float64 `json:"hitRatio"`
+}
+
+type cacheEntry struct {
+	key       string
+	response  Response
+	expiresAt time.Time
+}
+
+// maxSize - maximum number of validation results kept, least recently used results are evicted first;
+// ttl - duration after which a cached result is no longer returned;
+func NewResultCache(maxSize int, ttl time.Duration) (*ResultCache, error) {
+	return NewResultCacheWithTimeFunc(maxSize, ttl, time.Now)
+}
+
+// maxSize - maximum number of validation results kept, least recently used results are evicted first;
+// ttl - duration after which a cached result is no longer returned;
+// nowFunc - function which gets current time. time.Now() by default but can be overwritten for tests
+func NewResultCacheWithTimeFunc(maxSize int, ttl time.Duration, nowFunc func() time.Time) (*ResultCache, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("cache size has to be positive. provided value: %d", maxSize)
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("ttl has to be positive. provided value: %v", ttl)
+	}
+
+	return &ResultCache{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		maxSize: maxSize,
+		ttl:     ttl,
+		nowFunc: nowFunc,
+	}, nil
+}
+
+func (rc *ResultCache) Get(key string) (*Response, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	response, found := rc.lookup(key)
+	if found {
+		rc.hits++
+	} else {
+		rc.misses++
+	}
+	return response, found
+}
+
+// same as Get but not counted in stats, for checking again a key which was already looked up
+func (rc *ResultCache) recheck(key string) (*Response, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.lookup(key)
+}
+
+func (rc *ResultCache) lookup(key string) (*Response, bool) {
+	element, ok := rc.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if !rc.nowFunc().Before(entry.expiresAt) {
+		rc.order.Remove(element)
+		delete(rc.entries, key)
+		return nil, false
+	}
+
+	rc.order.MoveToFront(element)
+	response := entry.response
+	response.Cached = true
+	return &response, true
+}
+
+func (rc *ResultCache) Add(key string, response Response) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	response.Cached = false
+	expiresAt := rc.nowFunc().Add(rc.ttl)
+	if element, ok := rc.entries[key]; ok {
+		element.Value = &cacheEntry{key: key, response: response, expiresAt: expiresAt}
+		rc.order.MoveToFront(element)
+		return
+	}
+
+	rc.entries[key] = rc.order.PushFront(&cacheEntry{key: key, response: response, expiresAt: expiresAt})
+	for rc.order.Len() > rc.maxSize {
+		oldest := rc.order.Back()
+		rc.order.Remove(oldest)
+		delete(rc.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+func (rc *ResultCache) Stats() CacheStats {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	stats := CacheStats{
+		Size:   rc.order.Len(),
+		Hits:   rc.hits,
+		Misses: rc.misses,
+	}
+	if lookups := rc.hits + rc.misses; lookups > 0 {
+		stats.HitRatio = float64(rc.hits) / float64(lookups)
+	}
+	return stats
+}
+
+// test set version changes whenever anything used to build or judge the tests changes,
+// so results cached for an older version of the problem are never returned
+func testSetVersion(testParams testCreationParams) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00%s\x00", testParams.kind, testParams.singleTestTemplate, testParams.additionalHelpers)
+	for _, testCase := range testParams.problemTestCases {
+		fmt.Fprintf(hash, "%d\x00%d\x00%s\x00%s\x00", testCase.Id, testCase.Subtask, strings.Join(testCase.Inputs, "\x01"), testCase.ExpectedOutput)
+	}
+	for _, subtask := range testParams.subtasks {
+		fmt.Fprintf(hash, "%d\x00%s\x00%d\x00", subtask.Id, subtask.Name, subtask.Weight)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func resultCacheKey(problemId int, version string, body Request) string {
+	files := slices.Clone(body.Files)
+	slices.SortFunc(files, func(a, b common.SourceFile) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d\x00%s\x00%s\x00%s\x00", problemId, version, body.Language, normaliseCode(body.Code))
+	for _, file := range files {
+		fmt.Fprintf(hash, "%s\x00%s\x00", file.Name, normaliseCode(file.Code))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// whitespace-only edits should not cause tests to be run again
+func normaliseCode(code string) string {
+	code = strings.ReplaceAll(code, "\r\n", "\n")
+	lines := strings.Split(code, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
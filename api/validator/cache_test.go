@@ -0,0 +1,223 @@
+package validator
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"serious-fin/api/common"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newMockTime() *MockTime {
+	return &MockTime{currTime: time.Now()}
+}
+
+type MockTime struct {
+	mu       sync.Mutex
+	currTime time.Time
+}
+
+func (mockTime *MockTime) Now() time.Time {
+	mockTime.mu.Lock()
+	defer mockTime.mu.Unlock()
+	return mockTime.currTime
+}
+
+func (mockTime *MockTime) Advance(duration time.Duration) {
+	mockTime.mu.Lock()
+	defer mockTime.mu.Unlock()
+	mockTime.currTime = mockTime.currTime.Add(duration)
+}
+
+func TestResultCacheConstructorErrorMaxSize(t *testing.T) {
+	_, err := NewResultCache(0, time.Minute)
+	if err == nil {
+		t.Error("Expected constructor with non-positive maxSize to throw")
+	}
+}
+
+func TestResultCacheConstructorErrorTTL(t *testing.T) {
+	_, err := NewResultCache(5, -time.Minute)
+	if err == nil {
+		t.Error("Expected constructor with negative ttl to throw")
+	}
+}
+
+func TestResultCacheAddAndGet(t *testing.T) {
+	cache, err := NewResultCache(5, time.Minute)
+	if err != nil {
+		t.Errorf("Unexpected error while creating cache: %v", err)
+	}
+
+	response := Response{
+		SucceededTests: []int{0},
+		FailedTests:    []FailInfo{},
+		Score:          100,
+		MaxScore:       100,
+	}
+	cache.Add("key", response)
+
+	got, found := cache.Get("key")
+	if !found {
+		t.Fatal("expected to find cached result")
+	}
+	want := response
+	want.Cached = true
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("got %v, want %v", *got, want)
+	}
+}
+
+func TestResultCacheExpires(t *testing.T) {
+	mockTime := newMockTime()
+	cache, err := NewResultCacheWithTimeFunc(5, time.Minute, mockTime.Now)
+	if err != nil {
+		t.Errorf("Unexpected error while creating cache: %v", err)
+	}
+
+	cache.Add("key", Response{})
+	mockTime.Advance(time.Minute)
+
+	if _, found := cache.Get("key"); found {
+		t.Error("expected cached result to be expired")
+	}
+}
+
+func TestResultCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache, err := NewResultCache(2, time.Minute)
+	if err != nil {
+		t.Errorf("Unexpected error while creating cache: %v", err)
+	}
+
+	cache.Add("1", Response{})
+	cache.Add("2", Response{})
+	cache.Get("1")
+	cache.Add("3", Response{})
+
+	if _, found := cache.Get("2"); found {
+		t.Error("expected least recently used result to be evicted")
+	}
+	if _, found := cache.Get("1"); !found {
+		t.Error("expected recently used result to be kept")
+	}
+	if _, found := cache.Get("3"); !found {
+		t.Error("expected newest result to be kept")
+	}
+}
+
+func TestResultCacheStats(t *testing.T) {
+	cache, err := NewResultCache(5, time.Minute)
+	if err != nil {
+		t.Errorf("Unexpected error while creating cache: %v", err)
+	}
+
+	cache.Add("key", Response{})
+	cache.Get("key")
+	cache.Get("key")
+	cache.Get("missing")
+	cache.Get("missing")
+
+	want := CacheStats{
+		Size:     1,
+		Hits:     2,
+		Misses:   2,
+		HitRatio: 0.5,
+	}
+	if got := cache.Stats(); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestResultCacheKeyIgnoresWhitespaceAndFileOrder(t *testing.T) {
+	first := resultCacheKey(1, "v1", Request{
+		Code:     "func a() {}  \r\n",
+		Language: "go",
+		Files:    []common.SourceFile{{Name: "a.go", Code: "a"}, {Name: "b.go", Code: "b"}},
+	})
+	second := resultCacheKey(1, "v1", Request{
+		Code:     "func a() {}",
+		Language: "go",
+		Files:    []common.SourceFile{{Name: "b.go", Code: "b"}, {Name: "a.go", Code: "a"}},
+	})
+	if first != second {
+		t.Error("expected equal keys for whitespace-only and file order differences")
+	}
+}
+
+func TestResultCacheKeyChangesWithVersion(t *testing.T) {
+	body := Request{Code: "code", Language: "go"}
+	if resultCacheKey(1, "v1", body) == resultCacheKey(1, "v2", body) {
+		t.Error("expected different keys for different test set versions")
+	}
+}
+
+func TestTestSetVersionChangesWithTestCases(t *testing.T) {
+	params := testCreationParams{
+		problemTestCases: []common.TestCase{{Id: 0, Inputs: []string{"1"}, ExpectedOutput: "1"}},
+	}
+	before := testSetVersion(params)
+	params.problemTestCases[0].ExpectedOutput = "2"
+	if before == testSetVersion(params) {
+		t.Error("expected test set version to change when test cases change")
+	}
+}
+
+func TestLimitedRunRechecksCacheAfterWaiting(t *testing.T) {
+	cache, err := NewResultCache(10, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler := NewValidatorHandlerWithCache(nil, cache)
+	handler.SetMaxConcurrentRuns(1)
+
+	// the only slot is taken, so the run waits until it is released
+	handler.runSlots <- struct{}{}
+	done := make(chan *Response)
+	go func() {
+		response, _ := handler.runLimited(context.Background(), "key", Request{}, testCreationParams{})
+		done <- response
+	}()
+
+	cache.Add("key", Response{Score: 7})
+	<-handler.runSlots
+	if got := <-done; got == nil || got.Score != 7 || !got.Cached {
+		t.Errorf("expected cached result after waiting for a slot but got %+v", got)
+	}
+}
+
+func TestLimitedRunDoesNotCountRecheckInStats(t *testing.T) {
+	cache, err := NewResultCache(10, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler := NewValidatorHandlerWithCache(nil, cache)
+	handler.SetMaxConcurrentRuns(1)
+
+	if _, found := handler.getCachedResult("key"); found {
+		t.Fatal("expected empty cache")
+	}
+	cache.Add("key", Response{Score: 7})
+	if _, err := handler.runLimited(context.Background(), "key", Request{}, testCreationParams{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := CacheStats{Size: 1, Hits: 0, Misses: 1, HitRatio: 0}
+	if got := cache.Stats(); got != want {
+		t.Errorf("got stats %+v, want %+v", got, want)
+	}
+}
+
+func TestLimitedRunStopsWaitingWhenCancelled(t *testing.T) {
+	handler := NewValidatorHandler(nil)
+	handler.SetMaxConcurrentRuns(1)
+	handler.runSlots <- struct{}{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := handler.runLimited(ctx, "key", Request{}, testCreationParams{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancelled error while waiting for a slot but got %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package validator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"serious-fin/api/common"
+	"slices"
+	"strings"
+)
+
+var sourceFileNameRegex = regexp.MustCompile(`^[A-Za-z0-9_]+\.go$`)
+
+const functionSolutionFile = "solution.go"
+
+// names which are generated by the validator itself and can not be used by submitted files
+func reservedFileNames(kind, code string) []string {
+	if code == "" {
+		return []string{}
+	}
+	if kind == common.ProblemKindStdio {
+		return []string{stdioMainFile}
+	}
+	return []string{functionSolutionFile}
+}
+
+func checkSourceFiles(files []common.SourceFile, reservedNames []string) error {
+	seenNames := make(map[string]bool)
+	for _, file := range files {
+		if !sourceFileNameRegex.MatchString(file.Name) {
+			return fmt.Errorf("file name \"%s\" is not a valid go source file name", file.Name)
+		}
+		if strings.HasSuffix(file.Name, "_test.go") {
+			return fmt.Errorf("file name \"%s\" can not be a test file", file.Name)
+		}
+		if slices.Contains(reservedNames, file.Name) {
+			return fmt.Errorf("file name \"%s\" is reserved", file.Name)
+		}
+		if seenNames[file.Name] {
+			return fmt.Errorf("file name \"%s\" is used more than once", file.Name)
+		}
+		seenNames[file.Name] = true
+	}
+	return nil
+}
+
+// submitted code is written to its own file, with package clause added when it is missing
+func writeCodeFile(path, code string) error {
+	return os.WriteFile(path, []byte(common.WithPackageClause(code)), 0644)
+}
+
+// every file is written as is, so line numbers reported by the compiler match the submitted code
+func writeSourceFiles(dirPath string, files []common.SourceFile) error {
+	for _, file := range files {
+		err := os.WriteFile(filepath.Join(dirPath, file.Name), []byte(file.Code), 0644)
+		if err != nil {
+			return fmt.Errorf("could not write source file \"%s\": %w", file.Name, err)
+		}
+	}
+	return nil
+}
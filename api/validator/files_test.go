@@ -0,0 +1,99 @@
+package validator
+
+import (
+	"os"
+	"path/filepath"
+	"serious-fin/api/common"
+	"testing"
+)
+
+func TestCheckSourceFilesValid(t *testing.T) {
+	files := []common.SourceFile{
+		{Name: "solution.go", Code: "package main"},
+		{Name: "heap_helpers.go", Code: "package main"},
+	}
+	if err := checkSourceFiles(files, []string{}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCheckSourceFilesInvalidName(t *testing.T) {
+	for _, name := range []string{"../escape.go", "dir/file.go", "notes.txt", ""} {
+		err := checkSourceFiles([]common.SourceFile{{Name: name}}, []string{})
+		if err == nil {
+			t.Errorf("expected error for file name \"%s\"", name)
+		}
+	}
+}
+
+func TestCheckSourceFilesTestFile(t *testing.T) {
+	err := checkSourceFiles([]common.SourceFile{{Name: "code_test.go"}}, []string{})
+	if err == nil {
+		t.Error("expected error for test file")
+	}
+}
+
+func TestCheckSourceFilesReservedName(t *testing.T) {
+	err := checkSourceFiles([]common.SourceFile{{Name: "main.go"}}, reservedFileNames(common.ProblemKindStdio, "code"))
+	if err == nil {
+		t.Error("expected error for reserved file name")
+	}
+}
+
+func TestCheckSourceFilesDuplicateName(t *testing.T) {
+	files := []common.SourceFile{{Name: "a.go"}, {Name: "a.go"}}
+	if err := checkSourceFiles(files, []string{}); err == nil {
+		t.Error("expected error for duplicate file names")
+	}
+}
+
+func TestWriteSourceFiles(t *testing.T) {
+	dirPath, err := os.MkdirTemp(".", "test_run_")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	files := []common.SourceFile{
+		{Name: "a.go", Code: "package main\n\nfunc a() {}"},
+		{Name: "b.go", Code: "package main\n\nfunc b() {}"},
+	}
+	if err = writeSourceFiles(dirPath, files); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, file := range files {
+		content, err := os.ReadFile(filepath.Join(dirPath, file.Name))
+		if err != nil {
+			t.Errorf("failed to read created file: %v", err)
+		}
+		if string(content) != file.Code {
+			t.Errorf("got %q, want %q", string(content), file.Code)
+		}
+	}
+}
+
+func TestWriteCodeFileAddsPackageClause(t *testing.T) {
+	dirPath, err := os.MkdirTemp(".", "test_run_")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	for code, want := range map[string]string{
+		"func a() {}":                 "package main; func a() {}",
+		"package main\n\nfunc a() {}": "package main\n\nfunc a() {}",
+	} {
+		path := filepath.Join(dirPath, functionSolutionFile)
+		if err = writeCodeFile(path, code); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("failed to read created file: %v", err)
+		}
+		if string(content) != want {
+			t.Errorf("got %q, want %q", string(content), want)
+		}
+	}
+}
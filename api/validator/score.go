@@ -0,0 +1,83 @@
+package validator
+
+import (
+	"fmt"
+	"serious-fin/api/common"
+	"slices"
+)
+
+type SubtaskResult struct {
+	Id     int    `json:"id"`
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+	Score  int    `json:"score"`
+	Passed bool   `json:"passed"`
+}
+
+// used for problems that do not define any subtasks, all test cases then belong to it
+var defaultSubtask = common.Subtask{
+	Id:     0,
+	Name:   "all tests",
+	Weight: 100,
+}
+
+// a subtask awards its full weight only when every test case belonging to it passes
+func scoreResponse(response *Response, subtasks []common.Subtask, testCases []common.TestCase) {
+	response.Subtasks = make([]SubtaskResult, 0, len(subtasks))
+	response.Score = 0
+	response.MaxScore = 0
+
+	for _, subtask := range subtasks {
+		result := SubtaskResult{
+			Id:     subtask.Id,
+			Name:   subtask.Name,
+			Weight: subtask.Weight,
+		}
+
+		testCount := 0
+		allPassed := true
+		for _, testCase := range testCases {
+			if testCase.Subtask != subtask.Id {
+				continue
+			}
+			testCount++
+			if !slices.Contains(response.SucceededTests, testCase.Id) {
+				allPassed = false
+			}
+		}
+
+		result.Passed = testCount > 0 && allPassed
+		if result.Passed {
+			result.Score = subtask.Weight
+		}
+		response.Subtasks = append(response.Subtasks, result)
+		response.Score += result.Score
+		response.MaxScore += subtask.Weight
+	}
+}
+
+func checkSubtasks(subtasks []common.Subtask, testCases []common.TestCase) error {
+	for _, testCase := range testCases {
+		found := slices.ContainsFunc(subtasks, func(subtask common.Subtask) bool {
+			return subtask.Id == testCase.Subtask
+		})
+		if !found {
+			return fmt.Errorf("test case %d references unknown subtask %d", testCase.Id, testCase.Subtask)
+		}
+	}
+	return nil
+}
+
+func (vh *ValidatorHandler) saveBestScore(userId string, problemId, score int) error {
+	query := `
+	INSERT INTO userProblemScores (userId, problemId, bestScore)
+	VALUES (?, ?, ?)
+	ON CONFLICT (userId, problemId)
+	DO UPDATE SET bestScore = MAX(bestScore, excluded.bestScore)`
+
+	_, err := vh.DB.Exec(query, userId, problemId, score)
+	if err != nil {
+		return fmt.Errorf("could not save best score for user %s (problem id %d): %w", userId, problemId, err)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package validator
+
+import (
+	"errors"
+	"reflect"
+	"serious-fin/api/common"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestScoreDefaultSubtaskAllPassing(t *testing.T) {
+	response := &Response{
+		SucceededTests: []int{0, 1},
+		FailedTests:    []FailInfo{},
+	}
+	testCases := []common.TestCase{{Id: 0}, {Id: 1}}
+
+	scoreResponse(response, []common.Subtask{defaultSubtask}, testCases)
+
+	if response.Score != 100 || response.MaxScore != 100 {
+		t.Errorf("got score %d/%d, want 100/100", response.Score, response.MaxScore)
+	}
+}
+
+func TestScoreDefaultSubtaskIsAllOrNothing(t *testing.T) {
+	response := &Response{
+		SucceededTests: []int{0},
+		FailedTests:    []FailInfo{{Id: 1}},
+	}
+	testCases := []common.TestCase{{Id: 0}, {Id: 1}}
+
+	scoreResponse(response, []common.Subtask{defaultSubtask}, testCases)
+
+	if response.Score != 0 || response.MaxScore != 100 {
+		t.Errorf("got score %d/%d, want 0/100", response.Score, response.MaxScore)
+	}
+}
+
+func TestScoreWeightedSubtasks(t *testing.T) {
+	response := &Response{
+		SucceededTests: []int{0, 1, 2},
+		FailedTests:    []FailInfo{{Id: 3}},
+	}
+	subtasks := []common.Subtask{
+		{Id: 1, Name: "small", Weight: 30},
+		{Id: 2, Name: "large", Weight: 70},
+	}
+	testCases := []common.TestCase{
+		{Id: 0, Subtask: 1},
+		{Id: 1, Subtask: 1},
+		{Id: 2, Subtask: 2},
+		{Id: 3, Subtask: 2},
+	}
+
+	scoreResponse(response, subtasks, testCases)
+
+	want := []SubtaskResult{
+		{Id: 1, Name: "small", Weight: 30, Score: 30, Passed: true},
+		{Id: 2, Name: "large", Weight: 70, Score: 0, Passed: false},
+	}
+	if !reflect.DeepEqual(response.Subtasks, want) {
+		t.Errorf("got %v, want %v", response.Subtasks, want)
+	}
+	if response.Score != 30 || response.MaxScore != 100 {
+		t.Errorf("got score %d/%d, want 30/100", response.Score, response.MaxScore)
+	}
+}
+
+func TestScoreSubtaskWithoutTestsIsNotPassed(t *testing.T) {
+	response := &Response{
+		SucceededTests: []int{},
+		FailedTests:    []FailInfo{},
+	}
+
+	scoreResponse(response, []common.Subtask{{Id: 1, Weight: 50}}, []common.TestCase{})
+
+	if response.Score != 0 {
+		t.Errorf("got score %d, want 0", response.Score)
+	}
+}
+
+func TestSaveBestScore(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	var mockHandler = NewValidatorHandler(db)
+
+	mock.ExpectExec(`INSERT INTO userProblemScores`).WithArgs("user", 1, 70).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	if err = mockHandler.saveBestScore("user", 1, 70); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSaveBestScoreThrowsError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	var mockHandler = NewValidatorHandler(db)
+
+	mock.ExpectExec(`INSERT INTO userProblemScores`).WithArgs("user", 1, 70).WillReturnError(errors.New("error inserting data"))
+
+	if err = mockHandler.saveBestScore("user", 1, 70); err == nil {
+		t.Error("expected error when insert fails")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,177 @@
+package validator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"serious-fin/api/common"
+	"strings"
+	"time"
+)
+
+const (
+	stdioCaseTimeout = 5 * time.Second
+	stdioMainFile    = "main.go"
+	// expected outputs are short, a program writing more than this is stopped
+	stdioOutputLimit = 1 << 20
+)
+
+// keeps at most limit bytes and stops the program as soon as it writes more
+type limitedOutput struct {
+	buffer   bytes.Buffer
+	limit    int
+	exceeded bool
+	stop     func()
+}
+
+// submitted code is written to main.go, with package clause added when it is missing, next to any additional source files. the program is built once
+// and run for every test case, feeding case inputs through stdin and comparing normalised stdout with the expected output
+func runStdioTests(dirPath, code string, testCases []common.TestCase) (*Response, error) {
+	if code != "" {
+		err := writeCodeFile(filepath.Join(dirPath, stdioMainFile), code)
+		if err != nil {
+			return nil, fmt.Errorf("could not write program file: %w", err)
+		}
+	}
+
+	binaryPath, buildOutput, err := buildProgram(dirPath)
+	if err != nil {
+		return nil, fmt.Errorf("error building program: %w", err)
+	}
+	if binaryPath == "" {
+		response := failAllTestCases(testCases, buildOutput, COMPILATION_ERROR)
+		response.BuildOutput = buildOutput
+		return response, nil
+	}
+
+	response := &Response{
+		SucceededTests: []int{},
+		FailedTests:    make([]FailInfo, 0),
+	}
+	for _, testCase := range testCases {
+		got, message, err := runStdioCase(binaryPath, stdinFromInputs(testCase.Inputs))
+		if err != nil {
+			return nil, fmt.Errorf("error running test case %d: %w", testCase.Id, err)
+		}
+
+		want := normaliseOutput(testCase.ExpectedOutput)
+		if message == "" && got == want {
+			response.SucceededTests = append(response.SucceededTests, testCase.Id)
+			continue
+		}
+		if message == "" {
+			message = WRONG_OUTPUT
+		}
+		response.FailedTests = append(response.FailedTests, FailInfo{
+			Id:      testCase.Id,
+			Want:    want,
+			Got:     got,
+			Message: message,
+		})
+	}
+	return response, nil
+}
+
+// returns an empty binary path together with compiler output when the program does not compile
+func buildProgram(dirPath string) (string, string, error) {
+	initCmd := exec.Command("go", "mod", "init", "test_proj")
+	initCmd.Dir = dirPath
+	if err := initCmd.Run(); err != nil {
+		return "", "", fmt.Errorf("go mod init failed: %w", err)
+	}
+
+	binaryPath, err := filepath.Abs(filepath.Join(dirPath, "solution"))
+	if err != nil {
+		return "", "", fmt.Errorf("could not resolve binary path: %w", err)
+	}
+
+	buildCmd := exec.Command("go", "build", "-o", binaryPath, ".")
+	buildCmd.Dir = dirPath
+	output, err := buildCmd.CombinedOutput()
+	if err != nil {
+		var exitError *exec.ExitError
+		if !errors.As(err, &exitError) {
+			return "", "", fmt.Errorf("command execution returned error not of type ExitError: %w", err)
+		}
+		return "", strings.TrimSpace(string(output)), nil
+	}
+	return binaryPath, "", nil
+}
+
+// returns normalised stdout of the program and a failure message if the program did not finish correctly
+func runStdioCase(binaryPath, stdin string) (string, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), stdioCaseTimeout)
+	defer cancel()
+
+	stdout := &limitedOutput{limit: stdioOutputLimit, stop: cancel}
+	cmd := exec.CommandContext(ctx, binaryPath)
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout = stdout
+	err := cmd.Run()
+
+	if stdout.exceeded {
+		return "", OUTPUT_LIMIT_EXCEEDED, nil
+	}
+	got := normaliseOutput(stdout.buffer.String())
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return got, TIME_LIMIT_EXCEEDED, nil
+	}
+	if err != nil {
+		var exitError *exec.ExitError
+		if !errors.As(err, &exitError) {
+			return "", "", fmt.Errorf("could not run program: %w", err)
+		}
+		return got, RUNTIME_ERROR, nil
+	}
+	return got, "", nil
+}
+
+// output over the limit is dropped, the write still succeeds so the program is stopped instead of failing on it
+func (output *limitedOutput) Write(data []byte) (int, error) {
+	if output.exceeded {
+		return len(data), nil
+	}
+	if output.buffer.Len()+len(data) > output.limit {
+		output.exceeded = true
+		output.stop()
+		return len(data), nil
+	}
+	return output.buffer.Write(data)
+}
+
+func failAllTestCases(testCases []common.TestCase, got, message string) *Response {
+	response := &Response{
+		SucceededTests: []int{},
+		FailedTests:    make([]FailInfo, 0, len(testCases)),
+	}
+	for _, testCase := range testCases {
+		response.FailedTests = append(response.FailedTests, FailInfo{
+			Id:      testCase.Id,
+			Want:    normaliseOutput(testCase.ExpectedOutput),
+			Got:     got,
+			Message: message,
+		})
+	}
+	return response
+}
+
+// every input of a test case is passed to the program as a separate line
+func stdinFromInputs(inputs []string) string {
+	if len(inputs) == 0 {
+		return ""
+	}
+	return strings.Join(inputs, "\n") + "\n"
+}
+
+// ignores line ending style, trailing spaces on each line and trailing empty lines
+func normaliseOutput(output string) string {
+	output = strings.ReplaceAll(output, "\r\n", "\n")
+	lines := strings.Split(output, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	return strings.TrimRight(strings.Join(lines, "\n"), "\n")
+}
@@ -0,0 +1,184 @@
+package validator
+
+import (
+	"os"
+	"reflect"
+	"serious-fin/api/common"
+	"testing"
+)
+
+func TestNormaliseOutput(t *testing.T) {
+	got := normaliseOutput("1 2 3  \r\nfoo\t\n\n\n")
+	want := "1 2 3\nfoo"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestStdinFromInputs(t *testing.T) {
+	got := stdinFromInputs([]string{"3", "1 2 3"})
+	want := "3\n1 2 3\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestStdinFromNoInputs(t *testing.T) {
+	if got := stdinFromInputs(nil); got != "" {
+		t.Errorf("expected empty stdin but got %q", got)
+	}
+}
+
+func TestRunStdioTests(t *testing.T) {
+	dirPath, err := os.MkdirTemp(".", "test_run_")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	code := `package main
+
+import "fmt"
+
+func main() {
+	var a, b int
+	fmt.Scan(&a, &b)
+	fmt.Println(a + b)
+}`
+	testCases := []common.TestCase{
+		{Id: 0, Inputs: []string{"1", "2"}, ExpectedOutput: "3"},
+		{Id: 1, Inputs: []string{"2 2"}, ExpectedOutput: "5\n"},
+	}
+
+	got, err := runStdioTests(dirPath, code, testCases)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := &Response{
+		SucceededTests: []int{0},
+		FailedTests: []FailInfo{
+			{
+				Id:      1,
+				Want:    "5",
+				Got:     "4",
+				Message: WRONG_OUTPUT,
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestRunStdioTestsCompilationError(t *testing.T) {
+	dirPath, err := os.MkdirTemp(".", "test_run_")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	testCases := []common.TestCase{
+		{Id: 0, Inputs: []string{"1"}, ExpectedOutput: "1"},
+	}
+
+	got, err := runStdioTests(dirPath, "package main\nfunc main() {", testCases)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(got.FailedTests) != 1 || got.FailedTests[0].Message != COMPILATION_ERROR {
+		t.Errorf("expected single compilation error but got %v", got.FailedTests)
+	}
+}
+
+func TestRunStdioTestsWithoutPackageClause(t *testing.T) {
+	dirPath, err := os.MkdirTemp(".", "test_run_")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	code := `import "fmt"
+
+func main() {
+	var a int
+	fmt.Scan(&a)
+	fmt.Println(a * 3)
+}`
+	testCases := []common.TestCase{
+		{Id: 0, Inputs: []string{"2"}, ExpectedOutput: "6"},
+	}
+
+	got, err := runStdioTests(dirPath, code, testCases)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got.SucceededTests) != 1 || got.BuildOutput != "" {
+		t.Errorf("expected program without package clause to pass but got %+v", got)
+	}
+}
+
+func TestRunStdioTestsStopsProgramOverOutputLimit(t *testing.T) {
+	dirPath, err := os.MkdirTemp(".", "test_run_")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	code := `import "fmt"
+
+func main() {
+	for {
+		fmt.Println("spam")
+	}
+}`
+	testCases := []common.TestCase{
+		{Id: 0, Inputs: []string{}, ExpectedOutput: "spam"},
+	}
+
+	got, err := runStdioTests(dirPath, code, testCases)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got.FailedTests) != 1 || got.FailedTests[0].Message != OUTPUT_LIMIT_EXCEEDED || got.FailedTests[0].Got != "" {
+		t.Errorf("expected output limit to fail the test case but got %+v", got.FailedTests)
+	}
+}
+
+func TestRunStdioTestsWithAdditionalFiles(t *testing.T) {
+	dirPath, err := os.MkdirTemp(".", "test_run_")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dirPath)
+
+	err = writeSourceFiles(dirPath, []common.SourceFile{{
+		Name: "helpers.go",
+		Code: "package main\n\nfunc double(x int) int {\n\treturn x * 2\n}\n",
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	code := `package main
+
+import "fmt"
+
+func main() {
+	var a int
+	fmt.Scan(&a)
+	fmt.Println(double(a))
+}`
+	testCases := []common.TestCase{
+		{Id: 0, Inputs: []string{"4"}, ExpectedOutput: "8"},
+	}
+
+	got, err := runStdioTests(dirPath, code, testCases)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got.SucceededTests, []int{0}) {
+		t.Errorf("expected test to pass but got %v", got)
+	}
+}
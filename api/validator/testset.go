@@ -0,0 +1,42 @@
+package validator
+
+import (
+	"fmt"
+	"serious-fin/api/common"
+)
+
+// problem definition which can be judged without the database, e.g. by problem authors working locally
+type TestSet struct {
+	Kind         string
+	TestTemplate string
+	TestHelpers  string
+	TestCases    []common.TestCase
+	Subtasks     []common.Subtask
+}
+
+func RunTestSet(testSet TestSet, code string, files []common.SourceFile) (*Response, error) {
+	testParams := testCreationParams{
+		singleTestTemplate: testSet.TestTemplate,
+		additionalHelpers:  testSet.TestHelpers,
+		problemTestCases:   testSet.TestCases,
+		subtasks:           testSet.Subtasks,
+		kind:               testSet.Kind,
+	}
+	if testParams.kind == "" {
+		testParams.kind = common.ProblemKindFunction
+	}
+	if len(testParams.subtasks) == 0 {
+		testParams.subtasks = []common.Subtask{defaultSubtask}
+	}
+
+	err := checkSubtasks(testParams.subtasks, testParams.problemTestCases)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subtask configuration: %w", err)
+	}
+	err = checkSourceFiles(files, reservedFileNames(testParams.kind, code))
+	if err != nil {
+		return nil, fmt.Errorf("invalid source files: %w", err)
+	}
+
+	return runValidation(Request{Code: code, Files: files}, testParams)
+}
@@ -0,0 +1,64 @@
+package validator
+
+import (
+	"serious-fin/api/common"
+	"strings"
+	"testing"
+)
+
+func TestRunTestSetUnknownSubtask(t *testing.T) {
+	testSet := TestSet{
+		TestCases: []common.TestCase{{Id: 0, Subtask: 3}},
+		Subtasks:  []common.Subtask{{Id: 1, Weight: 100}},
+	}
+	if _, err := RunTestSet(testSet, "code", nil); err == nil {
+		t.Error("expected error when test case references unknown subtask")
+	}
+}
+
+func TestRunTestSetFunctionKind(t *testing.T) {
+	testSet := TestSet{
+		TestTemplate: `func TestDouble{{ID}}(t *testing.T) {
+	want := {{OUTPUT}}
+	got := double({{INPUT0}})
+	if got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}`,
+		TestCases: []common.TestCase{
+			{Id: 0, Inputs: []string{"2"}, ExpectedOutput: "4"},
+			{Id: 1, Inputs: []string{"3"}, ExpectedOutput: "7"},
+		},
+	}
+
+	got, err := RunTestSet(testSet, "func double(x int) int {\n\treturn x * 2\n}", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got.SucceededTests) != 1 || len(got.FailedTests) != 1 {
+		t.Errorf("expected one passing and one failing test but got %v", got)
+	}
+	if got.MaxScore != defaultSubtask.Weight {
+		t.Errorf("got max score %d, want %d", got.MaxScore, defaultSubtask.Weight)
+	}
+}
+
+func TestRunTestSetReportsLinesOfSubmittedCode(t *testing.T) {
+	testSet := TestSet{
+		TestTemplate: `func TestDouble{{ID}}(t *testing.T) {
+	want := {{OUTPUT}}
+	if got := double({{INPUT0}}); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}`,
+		TestCases: []common.TestCase{{Id: 0, Inputs: []string{"2"}, ExpectedOutput: "4"}},
+	}
+
+	got, err := RunTestSet(testSet, "func double(x int) int {\n\treturn y * 2\n}", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(got.BuildOutput, "solution.go:2:9: undefined: y") {
+		t.Errorf("expected compiler error on line 2 of the submitted code but got %q", got.BuildOutput)
+	}
+}
@@ -2,10 +2,13 @@ package validator
 
 import (
 	"bufio"
+	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"regexp"
 	"serious-fin/api/common"
 	"strconv"
@@ -14,17 +17,31 @@ import (
 )
 
 type ValidatorHandler struct {
-	DB common.DBInterface
+	DB    common.DBInterface
+	Cache *ResultCache
+	// limits test runs executing at the same time, nil means no limit
+	runSlots chan struct{}
 }
 
 type Request struct {
-	ProblemId int    `form:"problemId"`
-	Code      string `form:"code"`
+	ProblemId int                 `form:"problemId"`
+	Code      string              `form:"code"`
+	Files     []common.SourceFile `form:"files"`
+	Language  string              `form:"language"`
+	// set by the server from the login session, the best score is only saved for the signed-in user
+	UserId string `form:"-" json:"-"`
+	// agent session the code came from, only used to attribute outcomes of prompt experiments
+	SessionId string `form:"sessionId"`
 }
 
 type Response struct {
-	FailedTests    []FailInfo `json:"failedTests"`
-	SucceededTests []int      `json:"succeededTests"`
+	FailedTests    []FailInfo      `json:"failedTests"`
+	SucceededTests []int           `json:"succeededTests"`
+	Subtasks       []SubtaskResult `json:"subtasks"`
+	Score          int             `json:"score"`
+	MaxScore       int             `json:"maxScore"`
+	Cached         bool            `json:"cached"`
+	BuildOutput    string          `json:"buildOutput,omitempty"`
 }
 
 type FailInfo struct {
@@ -48,6 +65,8 @@ type testCreationParams struct {
 	singleTestTemplate string
 	additionalHelpers  string
 	problemTestCases   []common.TestCase
+	subtasks           []common.Subtask
+	kind               string
 }
 
 var fileStartTemplate = `package main
@@ -55,7 +74,11 @@ import "testing"
 `
 
 const (
-	WRONG_OUTPUT = "wrong output"
+	WRONG_OUTPUT          = "wrong output"
+	COMPILATION_ERROR     = "compilation error"
+	RUNTIME_ERROR         = "runtime error"
+	TIME_LIMIT_EXCEEDED   = "time limit exceeded"
+	OUTPUT_LIMIT_EXCEEDED = "output limit exceeded"
 )
 
 func NewValidatorHandler(db common.DBInterface) *ValidatorHandler {
@@ -64,19 +87,131 @@ func NewValidatorHandler(db common.DBInterface) *ValidatorHandler {
 	}
 }
 
-func (vh *ValidatorHandler) Validate(body Request) (*Response, error) {
+// identical validation runs are answered from cache instead of running the tests again
+func NewValidatorHandlerWithCache(db common.DBInterface, cache *ResultCache) *ValidatorHandler {
+	return &ValidatorHandler{
+		DB:    db,
+		Cache: cache,
+	}
+}
+
+// every test run starts its own container, so parallel validations (e.g. of several candidates) wait for a free slot
+func (vh *ValidatorHandler) SetMaxConcurrentRuns(maxRuns int) {
+	if maxRuns <= 0 {
+		vh.runSlots = nil
+		return
+	}
+	vh.runSlots = make(chan struct{}, maxRuns)
+}
+
+// ctx only limits waiting for a free test run slot, a started test run is not interrupted
+func (vh *ValidatorHandler) Validate(ctx context.Context, body Request) (*Response, error) {
 	testParams, err := vh.fetchTestCreationParams(body.ProblemId)
 	if err != nil {
 		return nil, fmt.Errorf("could not fetch test creation params: %w", err)
 	}
 
+	err = checkSourceFiles(body.Files, reservedFileNames(testParams.kind, body.Code))
+	if err != nil {
+		return nil, fmt.Errorf("invalid source files: %w", err)
+	}
+
+	cacheKey := resultCacheKey(body.ProblemId, testSetVersion(*testParams), body)
+	testStates, found := vh.getCachedResult(cacheKey)
+	if !found {
+		testStates, err = vh.runLimited(ctx, cacheKey, body, *testParams)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if body.UserId != "" {
+		err = vh.saveBestScore(body.UserId, body.ProblemId, testStates.Score)
+		if err != nil {
+			return nil, fmt.Errorf("error saving score: %w", err)
+		}
+	}
+
+	return testStates, nil
+}
+
+// identical code may have been validated while waiting for a slot, so the cache is checked again.
+// the key was already looked up by the caller, so the second lookup is not counted in cache stats
+func (vh *ValidatorHandler) runLimited(ctx context.Context, cacheKey string, body Request, testParams testCreationParams) (*Response, error) {
+	if vh.runSlots != nil {
+		select {
+		case vh.runSlots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("stopped waiting for a free test run slot: %w", ctx.Err())
+		}
+		defer func() { <-vh.runSlots }()
+
+		if vh.Cache != nil {
+			testStates, found := vh.Cache.recheck(cacheKey)
+			if found {
+				return testStates, nil
+			}
+		}
+	}
+
+	testStates, err := runValidation(body, testParams)
+	if err != nil {
+		return nil, err
+	}
+	vh.cacheResult(cacheKey, *testStates)
+	return testStates, nil
+}
+
+func runValidation(body Request, testParams testCreationParams) (*Response, error) {
 	dirPath, err := os.MkdirTemp(".", "test_run_")
 	if err != nil {
 		return nil, fmt.Errorf("error making temporary directory: %w", err)
 	}
 	defer os.RemoveAll(dirPath)
 
-	err = createTestFile(fmt.Sprintf("%s/code_test.go", dirPath), body.Code, *testParams)
+	err = writeSourceFiles(dirPath, body.Files)
+	if err != nil {
+		return nil, fmt.Errorf("error writing source files: %w", err)
+	}
+
+	var testStates *Response
+	switch testParams.kind {
+	case common.ProblemKindStdio:
+		testStates, err = runStdioTests(dirPath, body.Code, testParams.problemTestCases)
+	default:
+		testStates, err = runFunctionTests(dirPath, body.Code, testParams)
+	}
+	if err != nil {
+		return nil, err
+	}
+	scoreResponse(testStates, testParams.subtasks, testParams.problemTestCases)
+	return testStates, nil
+}
+
+func (vh *ValidatorHandler) getCachedResult(key string) (*Response, bool) {
+	if vh.Cache == nil {
+		return nil, false
+	}
+	return vh.Cache.Get(key)
+}
+
+func (vh *ValidatorHandler) cacheResult(key string, response Response) {
+	if vh.Cache == nil {
+		return
+	}
+	vh.Cache.Add(key, response)
+}
+
+// submitted code goes to solution.go, next to any additional source files, and code_test.go holds only the generated tests
+func runFunctionTests(dirPath, code string, testParams testCreationParams) (*Response, error) {
+	if code != "" {
+		err := writeCodeFile(filepath.Join(dirPath, functionSolutionFile), code)
+		if err != nil {
+			return nil, fmt.Errorf("could not write solution file: %w", err)
+		}
+	}
+
+	err := createTestFile(filepath.Join(dirPath, "code_test.go"), testParams)
 	if err != nil {
 		return nil, fmt.Errorf("error creating test file: %w", err)
 	}
@@ -90,21 +225,17 @@ func (vh *ValidatorHandler) Validate(body Request) (*Response, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error parsing command output %s: %w", testOutput, err)
 	}
-
 	return testStates, nil
 }
 
+// test templates are only needed to generate go tests, so stdio problems do not have them
 func (vh *ValidatorHandler) fetchTestCreationParams(problemId int) (*testCreationParams, error) {
 	var testParams testCreationParams
-	row := vh.DB.QueryRow("SELECT testTemplate, testHelpers FROM goTemplates WHERE problemFk = ?", problemId)
-	err := row.Scan(&testParams.singleTestTemplate, &testParams.additionalHelpers)
-	if err != nil {
-		return nil, fmt.Errorf("error scanning templates and helpers from db (problem id %d): %w", problemId, err)
-	}
-
 	var testCasesString string
-	row = vh.DB.QueryRow("SELECT testCases FROM problems WHERE id = ?", problemId)
-	err = row.Scan(&testCasesString)
+	var subtasksString sql.NullString
+	var kind sql.NullString
+	row := vh.DB.QueryRow("SELECT testCases, subtasks, kind FROM problems WHERE id = ?", problemId)
+	err := row.Scan(&testCasesString, &subtasksString, &kind)
 	if err != nil {
 		return nil, fmt.Errorf("error scanning test cases from db (problem id %d): %w", problemId, err)
 	}
@@ -113,19 +244,46 @@ func (vh *ValidatorHandler) fetchTestCreationParams(problemId int) (*testCreatio
 	if err != nil {
 		return nil, fmt.Errorf("could not unmarshal test cases from string \"%s\" (problem id %d): %w", testCasesString, problemId, err)
 	}
+
+	testParams.kind = common.ProblemKindFunction
+	if kind.Valid && kind.String != "" {
+		testParams.kind = kind.String
+	}
+
+	testParams.subtasks = []common.Subtask{defaultSubtask}
+	if subtasksString.Valid && subtasksString.String != "" {
+		err = json.Unmarshal([]byte(subtasksString.String), &testParams.subtasks)
+		if err != nil {
+			return nil, fmt.Errorf("could not unmarshal subtasks from string \"%s\" (problem id %d): %w", subtasksString.String, problemId, err)
+		}
+	}
+
+	err = checkSubtasks(testParams.subtasks, testParams.problemTestCases)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subtask configuration (problem id %d): %w", problemId, err)
+	}
+	if testParams.kind == common.ProblemKindStdio {
+		return &testParams, nil
+	}
+
+	row = vh.DB.QueryRow("SELECT testTemplate, testHelpers FROM goTemplates WHERE problemFk = ?", problemId)
+	err = row.Scan(&testParams.singleTestTemplate, &testParams.additionalHelpers)
+	if err != nil {
+		return nil, fmt.Errorf("error scanning templates and helpers from db (problem id %d): %w", problemId, err)
+	}
 	return &testParams, nil
 }
 
-func createTestFile(filename, testableCode string, testParams testCreationParams) error {
+func createTestFile(filename string, testParams testCreationParams) error {
 	file, err := os.Create(filename)
 	if err != nil {
 		return fmt.Errorf("could not create file with name \"%s\", error: %w", filename, err)
 	}
 	defer file.Close()
 
-	_, err = fmt.Fprintf(file, "%s\n%s\n", fileStartTemplate, testableCode)
+	_, err = fmt.Fprintf(file, "%s\n", fileStartTemplate)
 	if err != nil {
-		return fmt.Errorf("could not write start template and user code to file: %w", err)
+		return fmt.Errorf("could not write start template to file: %w", err)
 	}
 
 	var newTestCase string
@@ -188,6 +346,11 @@ func parseCommandOutput(cmdOutput string) (*Response, error) {
 		var testLog testEvent
 		_ = json.Unmarshal([]byte(line), &testLog)
 
+		if testLog.Action == "build-output" {
+			response.BuildOutput += testLog.Output
+			continue
+		}
+
 		if testLog.Test == "" {
 			// test event log is not associated with any specific test so we skip this log
 			continue
@@ -232,13 +395,29 @@ func parseCommandOutput(cmdOutput string) (*Response, error) {
 				continue
 			}
 
-			return nil, fmt.Errorf("did not find \"got\" and \"want\" values for failed test \"%s\" in output values %v", testLog.Test, testOutputs[testId])
+			// test did not get to compare its result, e.g. the code panicked
+			response.FailedTests = append(response.FailedTests, failureWithoutResult(testId, testOutputs[testId]))
+			delete(testOutputs, testId)
 		}
 	}
 
 	return response, nil
 }
 
+// output of the test is reported as got, so the reason of the failure is not lost
+func failureWithoutResult(testId int, outputs []string) FailInfo {
+	output := strings.TrimSpace(strings.Join(outputs, ""))
+	message := RUNTIME_ERROR
+	if strings.Contains(output, "test timed out") {
+		message = TIME_LIMIT_EXCEEDED
+	}
+	return FailInfo{
+		Id:      testId,
+		Got:     output,
+		Message: message,
+	}
+}
+
 var testIdFromNameRegex = regexp.MustCompile(`.+_(\d+)$`)
 
 func getTestId(testName string) (int, error) {
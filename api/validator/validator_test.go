@@ -253,6 +253,49 @@ func TestTextBeforeOutput(t *testing.T) {
 	}
 }
 
+func TestPanicIsFailedTest(t *testing.T) {
+	cmdOutput := `
+	{"Time":"2025-07-27T18:04:10.225107394Z","Action":"start","Package":"test_proj"}
+	{"Time":"2025-07-27T18:04:10.226345745Z","Action":"run","Package":"test_proj","Test":"TestTwoSum_0"}
+	{"Time":"2025-07-27T18:04:10.226368636Z","Action":"output","Package":"test_proj","Test":"TestTwoSum_0","Output":"=== RUN   TestTwoSum_0\n"}
+	{"Time":"2025-07-27T18:04:10.226368636Z","Action":"output","Package":"test_proj","Test":"TestTwoSum_0","Output":"--- FAIL: TestTwoSum_0 (0.00s)\n"}
+	{"Time":"2025-07-27T18:04:10.226368636Z","Action":"output","Package":"test_proj","Test":"TestTwoSum_0","Output":"panic: runtime error: index out of range [3] with length 3 [recovered]\n"}
+	{"Time":"2025-07-27T18:04:10.226398054Z","Action":"fail","Package":"test_proj","Test":"TestTwoSum_0","Elapsed":0}`
+
+	got, err := parseCommandOutput(cmdOutput)
+	if err != nil {
+		t.Fatalf("error while parsing: %v", err)
+	}
+	if len(got.FailedTests) != 1 || got.FailedTests[0].Message != RUNTIME_ERROR || !strings.Contains(got.FailedTests[0].Got, "index out of range") {
+		t.Errorf("expected panic to be reported as runtime error but got %+v", got.FailedTests)
+	}
+}
+
+func TestBuildFailureOutput(t *testing.T) {
+	cmdOutput := `
+	{"ImportPath":"test_proj [test_proj.test]","Action":"build-output","Output":"# test_proj [test_proj.test]\n"}
+	{"ImportPath":"test_proj [test_proj.test]","Action":"build-output","Output":"./code_test.go:3:29: undefined: x\n"}
+	{"ImportPath":"test_proj [test_proj.test]","Action":"build-fail"}
+	{"Time":"2026-10-19T09:09:21.743839866Z","Action":"start","Package":"test_proj"}
+	{"Time":"2026-10-19T09:09:21.743907019Z","Action":"output","Package":"test_proj","Output":"FAIL\ttest_proj [build failed]\n","OutputType":"frame"}
+	{"Time":"2026-10-19T09:09:21.743917023Z","Action":"fail","Package":"test_proj","Elapsed":0,"FailedBuild":"test_proj [test_proj.test]"}`
+
+	want := &Response{
+		SucceededTests: []int{},
+		FailedTests:    []FailInfo{},
+		BuildOutput:    "# test_proj [test_proj.test]\n./code_test.go:3:29: undefined: x\n",
+	}
+
+	got, err := parseCommandOutput(cmdOutput)
+	if err != nil {
+		t.Errorf("error while parsing: %v", err)
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
 func TestFetchingCreationParamsBadFirstQuery(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -263,7 +306,7 @@ func TestFetchingCreationParamsBadFirstQuery(t *testing.T) {
 
 	var mockHandler = NewValidatorHandler(db)
 
-	mock.ExpectQuery("SELECT testTemplate, testHelpers FROM goTemplates WHERE problemFk = ?").WithArgs(problemId).WillReturnError(errors.New("error querying data"))
+	mock.ExpectQuery("SELECT testCases, subtasks, kind FROM problems WHERE id = ?").WithArgs(problemId).WillReturnError(errors.New("error querying data"))
 
 	if _, err = mockHandler.fetchTestCreationParams(problemId); err == nil {
 		t.Error("expected error when query fails")
@@ -284,10 +327,10 @@ func TestFetchingCreationParamsBadSecondQuery(t *testing.T) {
 
 	var mockHandler = NewValidatorHandler(db)
 
-	mock.ExpectQuery("SELECT testTemplate, testHelpers FROM goTemplates WHERE problemFk = ?").WithArgs(problemId).WillReturnRows(sqlmock.NewRows([]string{
-		"testTemplate", "testTemplate",
-	}).AddRows([]driver.Value{"foo", "bar"}))
-	mock.ExpectQuery("SELECT testCases FROM problems WHERE id = ?").WithArgs(problemId).WillReturnError(errors.New("error querying data"))
+	mock.ExpectQuery("SELECT testCases, subtasks, kind FROM problems WHERE id = ?").WithArgs(problemId).WillReturnRows(sqlmock.NewRows([]string{
+		"testCases", "subtasks", "kind",
+	}).AddRows([]driver.Value{`[{"id": 0,"inputs":  ["[]int{2, 7, 11, 15}","9"],"output": "[]int{0, 1}"}]`, nil, nil}))
+	mock.ExpectQuery("SELECT testTemplate, testHelpers FROM goTemplates WHERE problemFk = ?").WithArgs(problemId).WillReturnError(errors.New("error querying data"))
 
 	if _, err = mockHandler.fetchTestCreationParams(problemId); err == nil {
 		t.Error("expected error when query fails")
@@ -298,7 +341,7 @@ func TestFetchingCreationParamsBadSecondQuery(t *testing.T) {
 	}
 }
 
-func TestFetchingCreationParamsSecondQueryWrongFormat(t *testing.T) {
+func TestFetchingCreationParamsTestCasesWrongFormat(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -308,12 +351,9 @@ func TestFetchingCreationParamsSecondQueryWrongFormat(t *testing.T) {
 
 	var mockHandler = NewValidatorHandler(db)
 
-	mock.ExpectQuery("SELECT testTemplate, testHelpers FROM goTemplates WHERE problemFk = ?").WithArgs(problemId).WillReturnRows(sqlmock.NewRows([]string{
-		"testTemplate", "testTemplate",
-	}).AddRows([]driver.Value{"foo", "bar"}))
-	mock.ExpectQuery("SELECT testCases FROM problems WHERE id = ?").WithArgs(problemId).WillReturnRows(sqlmock.NewRows([]string{
-		"testCases",
-	}).AddRows([]driver.Value{"bad format"}))
+	mock.ExpectQuery("SELECT testCases, subtasks, kind FROM problems WHERE id = ?").WithArgs(problemId).WillReturnRows(sqlmock.NewRows([]string{
+		"testCases", "subtasks", "kind",
+	}).AddRows([]driver.Value{"bad format", nil, nil}))
 
 	if _, err = mockHandler.fetchTestCreationParams(problemId); err == nil {
 		t.Error("expected error when query fails")
@@ -324,7 +364,7 @@ func TestFetchingCreationParamsSecondQueryWrongFormat(t *testing.T) {
 	}
 }
 
-func TestFetchingCreationParamsSecondQueryCorrectFormat(t *testing.T) {
+func TestFetchingCreationParamsTestCasesCorrectFormat(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -334,12 +374,12 @@ func TestFetchingCreationParamsSecondQueryCorrectFormat(t *testing.T) {
 
 	var mockHandler = NewValidatorHandler(db)
 
+	mock.ExpectQuery("SELECT testCases, subtasks, kind FROM problems WHERE id = ?").WithArgs(problemId).WillReturnRows(sqlmock.NewRows([]string{
+		"testCases", "subtasks", "kind",
+	}).AddRows([]driver.Value{`[{"id": 0,"inputs":  ["[]int{2, 7, 11, 15}","9"],"output": "[]int{0, 1}"}]`, nil, nil}))
 	mock.ExpectQuery("SELECT testTemplate, testHelpers FROM goTemplates WHERE problemFk = ?").WithArgs(problemId).WillReturnRows(sqlmock.NewRows([]string{
 		"testTemplate", "testTemplate",
 	}).AddRows([]driver.Value{"foo", "bar"}))
-	mock.ExpectQuery("SELECT testCases FROM problems WHERE id = ?").WithArgs(problemId).WillReturnRows(sqlmock.NewRows([]string{
-		"testCases",
-	}).AddRows([]driver.Value{`[{"id": 0,"inputs":  ["[]int{2, 7, 11, 15}","9"],"output": "[]int{0, 1}"}]`}))
 
 	if _, err = mockHandler.fetchTestCreationParams(problemId); err != nil {
 		t.Errorf("Unexpected error: %v", err)
@@ -350,9 +390,102 @@ func TestFetchingCreationParamsSecondQueryCorrectFormat(t *testing.T) {
 	}
 }
 
+func TestFetchingCreationParamsWithSubtasks(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	problemId := 1
+
+	var mockHandler = NewValidatorHandler(db)
+
+	mock.ExpectQuery("SELECT testCases, subtasks, kind FROM problems WHERE id = ?").WithArgs(problemId).WillReturnRows(sqlmock.NewRows([]string{
+		"testCases", "subtasks", "kind",
+	}).AddRows([]driver.Value{
+		`[{"id": 0, "inputs": ["1"], "output": "1", "subtask": 1}, {"id": 1, "inputs": ["2"], "output": "2", "subtask": 2}]`,
+		`[{"id": 1, "name": "small", "weight": 30}, {"id": 2, "name": "large", "weight": 70}]`,
+		nil,
+	}))
+	mock.ExpectQuery("SELECT testTemplate, testHelpers FROM goTemplates WHERE problemFk = ?").WithArgs(problemId).WillReturnRows(sqlmock.NewRows([]string{
+		"testTemplate", "testTemplate",
+	}).AddRows([]driver.Value{"foo", "bar"}))
+
+	got, err := mockHandler.fetchTestCreationParams(problemId)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	want := []common.Subtask{
+		{Id: 1, Name: "small", Weight: 30},
+		{Id: 2, Name: "large", Weight: 70},
+	}
+	if !reflect.DeepEqual(got.subtasks, want) {
+		t.Errorf("got %v, want %v", got.subtasks, want)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFetchingCreationParamsUnknownSubtask(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	problemId := 1
+
+	var mockHandler = NewValidatorHandler(db)
+
+	mock.ExpectQuery("SELECT testCases, subtasks, kind FROM problems WHERE id = ?").WithArgs(problemId).WillReturnRows(sqlmock.NewRows([]string{
+		"testCases", "subtasks", "kind",
+	}).AddRows([]driver.Value{
+		`[{"id": 0, "inputs": ["1"], "output": "1", "subtask": 5}]`,
+		`[{"id": 1, "name": "small", "weight": 30}]`,
+		nil,
+	}))
+
+	if _, err = mockHandler.fetchTestCreationParams(problemId); err == nil {
+		t.Error("expected error when test case references unknown subtask")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFetchingCreationParamsStdioKind(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	problemId := 1
+
+	var mockHandler = NewValidatorHandler(db)
+
+	mock.ExpectQuery("SELECT testCases, subtasks, kind FROM problems WHERE id = ?").WithArgs(problemId).WillReturnRows(sqlmock.NewRows([]string{
+		"testCases", "subtasks", "kind",
+	}).AddRows([]driver.Value{`[{"id": 0, "inputs": ["1 2"], "output": "3"}]`, nil, common.ProblemKindStdio}))
+
+	got, err := mockHandler.fetchTestCreationParams(problemId)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if got.kind != common.ProblemKindStdio {
+		t.Errorf("got kind %s, want %s", got.kind, common.ProblemKindStdio)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
 func TestCreateTestFileNonExistentPath(t *testing.T) {
 	dirPath := "/NON/EXISTENT/PATH/code.go"
-	err := createTestFile(dirPath, "code", testCreationParams{})
+	err := createTestFile(dirPath, testCreationParams{})
 	if err == nil {
 		// delete file because it was created when it shouldn't have been
 		err := os.RemoveAll(dirPath)
@@ -366,7 +499,7 @@ func TestCreateTestFileNonExistentPath(t *testing.T) {
 
 func TestCreateTestFileIsStartTemplateAdded(t *testing.T) {
 	dirPath := fmt.Sprintf("./test_file_%s", randSeq(4))
-	err := createTestFile(dirPath, "code", testCreationParams{})
+	err := createTestFile(dirPath, testCreationParams{})
 	if err != nil {
 		t.Errorf("unexpected error when creating file \"%s\": %v", dirPath, err)
 	}
@@ -386,10 +519,9 @@ func TestCreateTestFileIsStartTemplateAdded(t *testing.T) {
 	}
 }
 
-func TestCreateTestFileIsUserCodeAdded(t *testing.T) {
+func TestCreateTestFileLeavesOutUserCode(t *testing.T) {
 	dirPath := fmt.Sprintf("./test_file_%s", randSeq(4))
-	userCode := "foo bar baz"
-	err := createTestFile(dirPath, userCode, testCreationParams{})
+	err := createTestFile(dirPath, testCreationParams{})
 	if err != nil {
 		t.Errorf("unexpected error when creating file \"%s\": %v", dirPath, err)
 	}
@@ -398,9 +530,8 @@ func TestCreateTestFileIsUserCodeAdded(t *testing.T) {
 	if err != nil {
 		t.Errorf("failed to read created file: %v", err)
 	}
-	fileContents := string(content)
-	if !strings.Contains(fileContents, userCode) {
-		t.Errorf("File content does not start with expected template.\nExpected to find:\n%s\nFile contents:\n%s", userCode, fileContents)
+	if string(content) != fileStartTemplate+"\n" {
+		t.Errorf("expected only the start template in the test file but got:\n%s", string(content))
 	}
 
 	err = os.RemoveAll(dirPath)
@@ -444,7 +575,7 @@ func testing_1(t * testing.T) {
 		t.Errorf("got %v, want %v", got, want)
 	}
 }`
-	err := createTestFile(dirPath, "code", testCreationParams{
+	err := createTestFile(dirPath, testCreationParams{
 		singleTestTemplate: testTemplate,
 		problemTestCases:   testCases,
 	})
@@ -470,7 +601,7 @@ func testing_1(t * testing.T) {
 func TestCreateTestFileIsHelperCodeAdded(t *testing.T) {
 	dirPath := fmt.Sprintf("./test_file_%s", randSeq(4))
 	helpers := "helper functions"
-	err := createTestFile(dirPath, "code", testCreationParams{
+	err := createTestFile(dirPath, testCreationParams{
 		additionalHelpers: helpers,
 	})
 	if err != nil {
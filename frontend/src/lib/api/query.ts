@@ -6,6 +6,7 @@ export interface QueryRequest {
 	language: string
 	agent: string
 	sessionId: string
+	problemId: number
 }
 
 export interface QueryResponse {
@@ -19,7 +20,8 @@ export async function query(params: QueryRequest): Promise<string> {
 				input: params.input,
 				code: params.code,
 				language: params.language,
-				agent: params.agent
+				agent: params.agent,
+				problemId: params.problemId
 			}),
 			headers: {
 				'Content-Type': 'application/json'
@@ -5,11 +5,23 @@ export interface ValidateRequest {
 	problemId: string
 	code: string
 	language: string
+	sessionId?: string
+	loginSession?: string
 }
 
+// only called on the server, the login session cookie is not readable in the browser
 export async function validate(req: ValidateRequest): Promise<TestRunOutput> {
+	return postValidate(`${getApiName()}/validate`, req)
+}
+
+// goes through the frontend server, so the best score is saved for the signed-in user
+export async function runTests(req: ValidateRequest): Promise<TestRunOutput> {
+	return postValidate('/api/validate', req)
+}
+
+async function postValidate(url: string, req: ValidateRequest): Promise<TestRunOutput> {
 	try {
-		const resp = await fetch(`${getApiName()}/validate`, {
+		const resp = await fetch(url, {
 			method: 'POST',
 			headers: {
 				'Content-Type': 'application/json'
@@ -1,4 +1,5 @@
 import { toast } from 'svelte-sonner'
+import { v4 as uuidv4 } from 'uuid'
 import { browser } from '$app/environment'
 import { PUBLIC_API_BASE_URL as API_BASE_URL } from '$env/static/public'
 
@@ -61,3 +62,20 @@ export function getApiName(): string {
 		return API_BASE_URL
 	}
 }
+
+/**
+ * Agent conversations are stored by the API, so the session id of a problem is kept in local storage
+ * and the conversation can be continued after the page is opened again.
+ * Ids are kept per user, so people sharing a browser do not continue each other's conversations
+ * @returns stored session id, or a new one which is stored for next time
+ */
+export function getAgentSessionId(problemId: string, userId?: string): string {
+	const key = `agentSession:${userId ?? 'guest'}:${problemId}`
+	const stored = localStorage.getItem(key)
+	if (stored) {
+		return stored
+	}
+	const sessionId = uuidv4()
+	localStorage.setItem(key, sessionId)
+	return sessionId
+}
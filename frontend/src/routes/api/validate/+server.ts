@@ -0,0 +1,14 @@
+import { json } from '@sveltejs/kit'
+import type { RequestHandler } from './$types'
+import { validate, type ValidateRequest } from '$lib/api/validate'
+
+export const POST: RequestHandler = async ({ request, cookies }) => {
+	const body: ValidateRequest = await request.json()
+	try {
+		const testRunOutput = await validate({ ...body, loginSession: cookies.get('session') })
+		return json(testRunOutput)
+	} catch (err) {
+		const message = err instanceof Error ? err.message : 'Unknown error'
+		return json({ message }, { status: 500 })
+	}
+}
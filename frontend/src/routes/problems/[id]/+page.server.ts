@@ -11,14 +11,15 @@ export const load: PageServerLoad = async ({ params, locals }) => {
 }
 
 export const actions = {
-	query: async ({ request }) => {
+	query: async ({ request, params: routeParams }) => {
 		const data = await request.formData()
 		const params: QueryRequest = {
 			input: data.get('input') as string,
 			code: data.get('code') as string,
 			language: data.get('language') as string,
 			agent: data.get('agent') as string,
-			sessionId: data.get('sessionId') as string
+			sessionId: data.get('sessionId') as string,
+			problemId: Number(routeParams.id)
 		}
 		try {
 			const response = await query(params)